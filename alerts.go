@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Alert rules: evaluated against each committed batch of logs with
+// per-rule, per-group sliding windows
+
+const (
+	defaultAlertWindow = 5 * time.Minute
+	maxAlertGroups     = 10000 // Distinct GroupBy values tracked per rule
+	maxAlertLogIDs     = 100   // Triggering log IDs stored with an alert
+	alertSweepInterval = time.Minute
+)
+
+// alertEvent is a matching log inside a rule's window
+type alertEvent struct {
+	at    time.Time
+	logID int64
+}
+
+// alertWindow is the sliding window for one rule and group value
+type alertWindow struct {
+	events    []alertEvent // Oldest first
+	lastFired time.Time
+}
+
+// alertRuleState holds a rule and its windows keyed by group value
+type alertRuleState struct {
+	rule    AlertRule
+	window  time.Duration
+	groups  map[string]*alertWindow
+	dropped bool // Group limit reached, logged once
+}
+
+// AlertEngine evaluates alert rules against newly saved logs
+type AlertEngine struct {
+	mu        sync.Mutex
+	rules     []*alertRuleState
+	lastSweep time.Time
+}
+
+func NewAlertEngine(rules []AlertRule) *AlertEngine {
+	e := &AlertEngine{}
+	e.SetRules(rules)
+	return e
+}
+
+// SetRules replaces the active rules. Window state is kept for rules whose
+// definition hasn't changed.
+func (e *AlertEngine) SetRules(rules []AlertRule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	existing := make(map[string]*alertRuleState, len(e.rules))
+	for _, state := range e.rules {
+		existing[state.rule.ID] = state
+	}
+
+	e.rules = make([]*alertRuleState, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if state, ok := existing[rule.ID]; ok && reflect.DeepEqual(state.rule, rule) {
+			e.rules = append(e.rules, state)
+			continue
+		}
+
+		window := defaultAlertWindow
+		if rule.WindowSeconds > 0 {
+			window = time.Duration(rule.WindowSeconds) * time.Second
+		}
+		e.rules = append(e.rules, &alertRuleState{
+			rule:   rule,
+			window: window,
+			groups: make(map[string]*alertWindow),
+		})
+	}
+}
+
+// Process adds a committed batch to the rule windows and returns any alerts
+// that fired. Entries must have their IDs set.
+func (e *AlertEngine) Process(batch []PendingLog) []*Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.rules) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Sub(e.lastSweep) >= alertSweepInterval {
+		for _, state := range e.rules {
+			state.sweep(now)
+		}
+		e.lastSweep = now
+	}
+
+	var fired []*Alert
+	for _, pending := range batch {
+		for _, state := range e.rules {
+			if alert := state.add(pending.Entry, now); alert != nil {
+				fired = append(fired, alert)
+			}
+		}
+	}
+	return fired
+}
+
+// add records entry if it matches the rule and returns an alert if the
+// threshold was reached
+func (s *alertRuleState) add(entry *LogEntry, now time.Time) *Alert {
+	rule := &s.rule
+	if !alertRuleMatches(rule, entry) {
+		return nil
+	}
+
+	group := ""
+	if rule.GroupBy != "" {
+		value, ok := alertFieldValue(entry, rule.GroupBy)
+		if !ok || value == "" {
+			// Logs without the group field can't be attributed to a group
+			return nil
+		}
+		group = value
+	}
+
+	w := s.groups[group]
+	if w == nil {
+		if len(s.groups) >= maxAlertGroups {
+			s.sweep(now)
+		}
+		if len(s.groups) >= maxAlertGroups {
+			if !s.dropped {
+				log.Printf("Alert rule %q: tracking %d groups, ignoring new %s values", rule.Name, maxAlertGroups, rule.GroupBy)
+				s.dropped = true
+			}
+			return nil
+		}
+		w = &alertWindow{}
+		s.groups[group] = w
+	}
+
+	threshold := max(rule.Threshold, 1)
+
+	// Slide the window and keep at most threshold events
+	w.events = append(w.events, alertEvent{at: now, logID: entry.ID})
+	cutoff := now.Add(-s.window)
+	start := 0
+	for start < len(w.events) && w.events[start].at.Before(cutoff) {
+		start++
+	}
+	start = max(start, len(w.events)-max(threshold, maxAlertLogIDs))
+	w.events = w.events[start:]
+
+	if len(w.events) < threshold {
+		return nil
+	}
+	if rule.CooldownSeconds > 0 && now.Sub(w.lastFired) < time.Duration(rule.CooldownSeconds)*time.Second {
+		return nil
+	}
+
+	alert := &Alert{
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		GroupBy:    rule.GroupBy,
+		GroupValue: group,
+		Count:      len(w.events),
+		FiredAt:    now,
+		channels:   rule.Channels,
+	}
+	ids := w.events[max(0, len(w.events)-maxAlertLogIDs):]
+	alert.LogIDs = make([]int64, len(ids))
+	for i, event := range ids {
+		alert.LogIDs[i] = event.logID
+	}
+	alert.Message = alertMessage(rule, alert, entry, s.window)
+
+	w.events = w.events[:0]
+	w.lastFired = now
+	return alert
+}
+
+// sweep drops groups with no events in the window and no active cooldown
+func (s *alertRuleState) sweep(now time.Time) {
+	cutoff := now.Add(-s.window)
+	cooldown := time.Duration(s.rule.CooldownSeconds) * time.Second
+	for group, w := range s.groups {
+		if len(w.events) > 0 && !w.events[len(w.events)-1].at.Before(cutoff) {
+			continue
+		}
+		if now.Sub(w.lastFired) < cooldown {
+			continue
+		}
+		delete(s.groups, group)
+	}
+	if len(s.groups) < maxAlertGroups {
+		s.dropped = false
+	}
+}
+
+// alertRuleMatches checks a log against the rule's match conditions
+func alertRuleMatches(rule *AlertRule, entry *LogEntry) bool {
+	if !rule.LogMatch.Matches(entry) {
+		return false
+	}
+	for field, want := range rule.Fields {
+		value, ok := alertFieldValue(entry, field)
+		if !ok || value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// alertFieldValue returns a log column or parsed field as a string
+func alertFieldValue(entry *LogEntry, field string) (string, bool) {
+	switch field {
+	case "hostname":
+		return entry.Hostname, true
+	case "appname":
+		return entry.AppName, true
+	case "remote_addr":
+		return entry.RemoteAddr, true
+	case "device_type":
+		return entry.DeviceType, true
+	case "event_type":
+		return entry.EventType, true
+	case "event_category":
+		return entry.EventCategory, true
+	case "severity":
+		return strconv.Itoa(int(entry.Severity)), true
+	}
+
+	value, ok := entry.ParsedFields[field]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// alertMessage builds the summary stored with an alert
+func alertMessage(rule *AlertRule, alert *Alert, entry *LogEntry, window time.Duration) string {
+	name := rule.Name
+	if name == "" {
+		name = rule.ID
+	}
+
+	if rule.Threshold <= 1 {
+		message := entry.Message
+		if message == "" {
+			message = entry.RawMessage
+		}
+		return name + ": " + message[:min(len(message), 200)]
+	}
+
+	msg := fmt.Sprintf("%s: %d matching logs within %s", name, alert.Count, window)
+	if alert.GroupBy != "" {
+		msg += fmt.Sprintf(" (%s=%s)", alert.GroupBy, alert.GroupValue)
+	}
+	return msg
+}
+
+// validateAlertRule checks a rule from the API and fills in its ID
+func validateAlertRule(rule *AlertRule) error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	if rule.Threshold < 0 || rule.WindowSeconds < 0 || rule.CooldownSeconds < 0 {
+		return errors.New("threshold, window_seconds and cooldown_seconds must not be negative")
+	}
+	for _, sev := range rule.Severities {
+		if sev < 0 || sev > 7 {
+			return fmt.Errorf("invalid severity %d (must be 0-7)", sev)
+		}
+	}
+	if rule.ID == "" {
+		rule.ID = fmt.Sprintf("alert-%d", time.Now().UnixNano())
+	}
+	return nil
+}
+
+// evaluateAlerts runs the alert rules against a committed batch, stores fired
+// alerts and queues their notifications
+func (s *Server) evaluateAlerts(batch []PendingLog) {
+	for _, alert := range s.alerts.Process(batch) {
+		if err := s.db.InsertAlert(alert); err != nil {
+			log.Printf("Error saving alert for rule %q: %v", alert.RuleName, err)
+			continue
+		}
+		log.Printf("Alert fired: %s", alert.Message)
+		s.notifier.Notify(alert, alert.channels)
+	}
+}
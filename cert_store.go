@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Listener certificates are served from a store that reloads the
+// certificate, key, CA and CRL files when they change, so rotating a
+// certificate doesn't restart the listener or drop connected senders
+
+const (
+	certReloadInterval = 30 * time.Second
+	certExpiryWarning  = 30 * 24 * time.Hour
+)
+
+// CertificateInfo describes the certificate a listener is serving
+type CertificateInfo struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Expiring      bool      `json:"expiring"` // Expires within 30 days, or has expired
+	LoadedAt      time.Time `json:"loaded_at"`
+	ReloadError   string    `json:"reload_error,omitempty"` // Why the files could not be reloaded; the previous certificate is still served
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// certStore holds a listener's current TLS configuration
+type certStore struct {
+	mu          sync.RWMutex
+	listener    ListenerConfig // Certificate, key, CA and CRL paths
+	config      *tls.Config    // Current configuration, with client certificate settings
+	cert        *tls.Certificate
+	leaf        *x509.Certificate
+	stamps      map[string]fileStamp // Files as of the last reload attempt
+	loadedAt    time.Time
+	reloadError string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newCertStore loads a listener's certificate files and watches them for
+// changes until Close is called
+func newCertStore(listener ListenerConfig) (*certStore, error) {
+	c := &certStore{
+		listener: listener,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	go c.watch()
+	return c, nil
+}
+
+// TLSConfig returns the configuration to serve connections with. It looks
+// up the current certificate and client certificate settings for every
+// handshake.
+func (c *certStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate:     c.getCertificate,
+		GetConfigForClient: c.configForClient,
+	}
+}
+
+func (c *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certStore) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config, nil
+}
+
+// SetFiles points the store at new certificate files (e.g. after an upload)
+// and loads them. On error the previous certificate is kept.
+func (c *certStore) SetFiles(listener ListenerConfig) error {
+	c.mu.Lock()
+	c.listener.CertFile = listener.CertFile
+	c.listener.KeyFile = listener.KeyFile
+	c.listener.CaCertFile = listener.CaCertFile
+	c.listener.CRLFile = listener.CRLFile
+	c.mu.Unlock()
+	return c.reload()
+}
+
+// reload loads the files again, logging the outcome
+func (c *certStore) reload() error {
+	err := c.load()
+	c.mu.RLock()
+	listenerName := c.listener.Name
+	c.mu.RUnlock()
+	if err != nil {
+		log.Printf("Failed to reload TLS certificate of listener %s, keeping the current one: %v", listenerName, err)
+	} else {
+		log.Printf("Reloaded TLS certificate of listener %s", listenerName)
+	}
+	return err
+}
+
+// load reads the listener's files and, if they are valid, starts serving them
+func (c *certStore) load() error {
+	c.mu.RLock()
+	listener := c.listener
+	c.mu.RUnlock()
+
+	// Stamped before reading, so a file changed during the load is read again
+	stamps := certFileStamps(listener)
+	config, err := listenerTLSConfig(listener)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stamps = stamps
+	if err != nil {
+		c.reloadError = err.Error()
+		return err
+	}
+
+	cert := config.Certificates[0]
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			c.reloadError = err.Error()
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+	if remaining := time.Until(leaf.NotAfter); remaining < certExpiryWarning {
+		log.Printf("Warning: TLS certificate of listener %s expires %s", listener.Name, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	config.Certificates = nil
+	config.GetCertificate = c.getCertificate
+	c.config = config
+	c.cert = &cert
+	c.leaf = leaf
+	c.loadedAt = time.Now()
+	c.reloadError = ""
+	return nil
+}
+
+// watch reloads the files when any of them changes
+func (c *certStore) watch() {
+	defer close(c.done)
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			changed := !stampsEqual(c.stamps, certFileStamps(c.listener))
+			c.mu.RUnlock()
+			if changed {
+				c.reload()
+			}
+		}
+	}
+}
+
+// Close stops watching the files. Safe to call on a nil store.
+func (c *certStore) Close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+}
+
+// Info describes the certificate being served
+func (c *certStore) Info() CertificateInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	remaining := time.Until(c.leaf.NotAfter)
+	return CertificateInfo{
+		Subject:       c.leaf.Subject.String(),
+		Issuer:        c.leaf.Issuer.String(),
+		NotAfter:      c.leaf.NotAfter,
+		DaysRemaining: int(remaining.Hours() / 24),
+		Expiring:      remaining < certExpiryWarning,
+		LoadedAt:      c.loadedAt,
+		ReloadError:   c.reloadError,
+	}
+}
+
+// certFileStamps returns the current versions of a listener's certificate
+// files. Missing files get a zero stamp.
+func certFileStamps(listener ListenerConfig) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range []string{listener.CertFile, listener.KeyFile, listener.CaCertFile, listener.CRLFile} {
+		if path == "" {
+			continue
+		}
+		var stamp fileStamp
+		if info, err := os.Stat(path); err == nil {
+			stamp = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+		stamps[path] = stamp
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+// listenerCertStore returns the certificate store of a running listener, or nil
+func (s *Server) listenerCertStore(listenerID string) *certStore {
+	s.listenerMu.RLock()
+	defer s.listenerMu.RUnlock()
+	return s.activeListeners[listenerID].Certs
+}
+
+var errListenerNotFound = errors.New("listener not found")
+
+// setListenerCertFile points a listener's certificate, key, CA or CRL at an
+// uploaded file and saves the configuration. If the listener is running it
+// reloads its certificate and its certStore is returned; a reload error is
+// reported by the store's Info.
+func (s *Server) setListenerCertFile(listenerID, fileType, path string) (*certStore, error) {
+	var listener *ListenerConfig
+	for i := range s.config.Listeners {
+		if s.config.Listeners[i].ID == listenerID {
+			listener = &s.config.Listeners[i]
+			break
+		}
+	}
+	if listener == nil {
+		return nil, errListenerNotFound
+	}
+
+	switch fileType {
+	case "cert":
+		listener.CertFile = path
+	case "key":
+		listener.KeyFile = path
+	case "ca":
+		listener.CaCertFile = path
+	case "crl":
+		listener.CRLFile = path
+	default:
+		return nil, fmt.Errorf("unknown file type %q", fileType)
+	}
+	if err := SaveConfig("config.json", s.config); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+
+	certs := s.listenerCertStore(listenerID)
+	if certs != nil {
+		certs.SetFiles(*listener)
+	}
+	return certs, nil
+}
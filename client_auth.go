@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client certificate authentication for TLS and RELP listeners: whether
+// certificates are required, revocation and fingerprint checks, and mapping
+// a verified certificate to the device it identifies
+
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional" // Verify certificates clients present, accept clients without one
+	clientAuthRequire  = "require"  // Reject clients without a valid certificate
+)
+
+// validateListenerClientAuth checks a listener's client certificate settings
+func validateListenerClientAuth(listener *ListenerConfig) error {
+	configured := listener.ClientAuth != "" || listener.CRLFile != "" || len(listener.ClientFingerprints) > 0
+	if configured && listener.Protocol != "TLS" && listener.Protocol != "RELP" {
+		return errors.New("client_auth, crl_file and client_fingerprints are only supported for tls and relp listeners")
+	}
+
+	switch listener.ClientAuth {
+	case "", clientAuthNone, clientAuthOptional:
+	case clientAuthRequire:
+		if listener.CaCertFile == "" && len(listener.ClientFingerprints) == 0 {
+			return errors.New("client_auth require needs ca_cert_file or client_fingerprints")
+		}
+	default:
+		return errors.New("client_auth must be none, optional or require")
+	}
+
+	if listener.CRLFile != "" && listener.CaCertFile == "" {
+		return errors.New("crl_file requires ca_cert_file")
+	}
+	for _, fingerprint := range listener.ClientFingerprints {
+		if _, err := normalizeFingerprint(fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientAuthMode returns the listener's client_auth with the default applied:
+// optional when a CA or fingerprints are configured, otherwise none
+func clientAuthMode(listener ListenerConfig) string {
+	if listener.ClientAuth != "" {
+		return listener.ClientAuth
+	}
+	if listener.CaCertFile != "" || len(listener.ClientFingerprints) > 0 {
+		return clientAuthOptional
+	}
+	return clientAuthNone
+}
+
+// normalizeFingerprint converts a SHA-256 fingerprint written as hex, with
+// or without colons, to lowercase hex
+func normalizeFingerprint(value string) (string, error) {
+	fingerprint := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(value), ":", ""))
+	if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid client certificate fingerprint %q: expected a SHA-256 hash in hex", value)
+	}
+	return fingerprint, nil
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of a certificate in lowercase hex
+func certificateFingerprint(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// clientCertPolicy holds the checks applied to client certificates after
+// the TLS library has verified their chain
+type clientCertPolicy struct {
+	fingerprints map[string]bool // Allowed leaf certificates, empty = any
+	revoked      map[string]bool // Issuer and serial number of revoked certificates
+	caVerified   bool            // Chains are verified against ca_cert_file
+}
+
+// newClientCertPolicy loads a listener's fingerprints and CRL. caCerts are
+// the certificates in the listener's ca_cert_file, if any.
+func newClientCertPolicy(listener ListenerConfig, caCerts []*x509.Certificate) (*clientCertPolicy, error) {
+	policy := &clientCertPolicy{
+		fingerprints: make(map[string]bool),
+		revoked:      make(map[string]bool),
+		caVerified:   len(caCerts) > 0,
+	}
+	for _, value := range listener.ClientFingerprints {
+		fingerprint, err := normalizeFingerprint(value)
+		if err != nil {
+			return nil, err
+		}
+		policy.fingerprints[fingerprint] = true
+	}
+	if listener.CRLFile != "" {
+		if err := policy.loadCRL(listener.CRLFile, caCerts); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// readCRLFile parses the revocation lists in a PEM or DER CRL file
+func readCRLFile(path string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, data) // Not PEM, try DER
+	}
+
+	crls := make([]*x509.RevocationList, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+// loadCRL reads revoked certificates from a CRL file. Each CRL must be
+// signed by one of the listener's CA certificates.
+func (p *clientCertPolicy) loadCRL(path string, caCerts []*x509.Certificate) error {
+	crls, err := readCRLFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, crl := range crls {
+		if err := checkCRLIssuer(crl, caCerts); err != nil {
+			return err
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			log.Printf("Warning: CRL %s from %s is past its next update (%s)", path, crl.Issuer, crl.NextUpdate.Format(time.RFC3339))
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			p.revoked[revocationKey(crl.RawIssuer, entry.SerialNumber.String())] = true
+		}
+	}
+	return nil
+}
+
+// checkCRLIssuer verifies that crl is signed by one of caCerts
+func checkCRLIssuer(crl *x509.RevocationList, caCerts []*x509.Certificate) error {
+	for _, ca := range caCerts {
+		if string(ca.RawSubject) == string(crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("CRL issued by %s is not signed by a certificate in ca_cert_file", crl.Issuer)
+}
+
+func revocationKey(rawIssuer []byte, serial string) string {
+	return string(rawIssuer) + "/" + serial
+}
+
+// verify is used as tls.Config.VerifyPeerCertificate. It runs after chain
+// verification, so verifiedChains is empty when the listener has no CA.
+func (p *clientCertPolicy) verify(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil // No certificate; whether one is required is up to ClientAuth
+	}
+
+	if len(p.fingerprints) > 0 && !p.fingerprints[certificateFingerprint(rawCerts[0])] {
+		return errors.New("client certificate fingerprint is not in client_fingerprints")
+	}
+
+	if p.caVerified && len(verifiedChains) == 0 {
+		return errors.New("client certificate was not verified")
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if p.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.String())] {
+				return fmt.Errorf("client certificate %s (serial %s) is revoked", cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// configureClientAuth sets how tlsConfig requests and checks client
+// certificates
+func configureClientAuth(tlsConfig *tls.Config, listener ListenerConfig) error {
+	var caCerts []*x509.Certificate
+	if listener.CaCertFile != "" {
+		caPEM, err := os.ReadFile(listener.CaCertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		for rest := caPEM; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				caCerts = append(caCerts, cert)
+			}
+		}
+		if len(caCerts) == 0 {
+			return fmt.Errorf("failed to parse CA certificate")
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		for _, cert := range caCerts {
+			tlsConfig.ClientCAs.AddCert(cert)
+		}
+	}
+
+	mode := clientAuthMode(listener)
+	if mode == clientAuthNone {
+		tlsConfig.ClientAuth = tls.NoClientCert
+		return nil
+	}
+
+	policy, err := newClientCertPolicy(listener, caCerts)
+	if err != nil {
+		return err
+	}
+	tlsConfig.VerifyPeerCertificate = policy.verify
+
+	// Without a CA, certificates are only checked against client_fingerprints
+	switch {
+	case mode == clientAuthRequire && len(caCerts) > 0:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case mode == clientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case len(caCerts) > 0:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	return nil
+}
+
+// certificateIdentities lists the names a client certificate can be bound
+// to a device by: its subject, common name and subject alternative names
+func certificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, ip.String())
+	}
+	return identities
+}
+
+// clientIdentity returns the identity of a TLS connection's client
+// certificate and the device bound to it. The identity is the certificate
+// name that matched a device, or its subject if none did. Both are empty
+// when the client sent no certificate.
+func (s *Server) clientIdentity(conn *tls.Conn) (identity, deviceID string) {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", ""
+	}
+	if device, matched := s.devices.MatchCertificate(certs[0]); device != nil {
+		return matched, device.ID
+	}
+	return certs[0].Subject.String(), ""
+}
@@ -7,8 +7,10 @@ import (
 
 type Config struct {
 	Database struct {
-		Path  string `json:"path"`
-		Limit int    `json:"limit"` // 0 = unlimited
+		Path            string `json:"path"`
+		Limit           int    `json:"limit"`                       // 0 = unlimited
+		BatchSize       int    `json:"batch_size,omitempty"`        // Max logs per write transaction, 0 = default (500)
+		FlushIntervalMs int    `json:"flush_interval_ms,omitempty"` // Max time a log waits before being written, 0 = default (250)
 	} `json:"database"`
 	Servers struct {
 		UDP struct {
@@ -29,17 +31,126 @@ type Config struct {
 	Web struct {
 		Port int `json:"port"`
 	} `json:"web"`
-	Parsing struct {
-		BestEffort     bool `json:"best_effort"`
-		RFC3164Enabled bool `json:"rfc3164_enabled"`
-		RFC5424Enabled bool `json:"rfc5424_enabled"`
-	} `json:"parsing"`
-	Listeners         []ListenerConfig     `json:"listeners,omitempty"`
-	Devices           []DeviceConfig       `json:"devices,omitempty"`
-	SeverityOverrides map[string]uint8     `json:"severity_overrides,omitempty"` // event_type -> severity (0-7)
-	Views             []ViewConfig         `json:"views,omitempty"`
-	Customization     *CustomizationConfig `json:"customization,omitempty"`
-	EnabledModules    map[string]bool      `json:"enabled_modules,omitempty"` // device_type -> enabled
+	Parsing           ParsingConfig         `json:"parsing"`
+	Listeners         []ListenerConfig      `json:"listeners,omitempty"`
+	Devices           []DeviceConfig        `json:"devices,omitempty"`
+	DeviceHealth      *DeviceHealthConfig   `json:"device_health,omitempty"`
+	SeverityOverrides map[string]uint8      `json:"severity_overrides,omitempty"` // event_type -> severity (0-7)
+	Views             []ViewConfig          `json:"views,omitempty"`
+	Customization     *CustomizationConfig  `json:"customization,omitempty"`
+	EnabledModules    map[string]bool       `json:"enabled_modules,omitempty"` // device_type -> enabled
+	Retention         *RetentionConfig      `json:"retention,omitempty"`
+	AlertRules        []AlertRule           `json:"alert_rules,omitempty"`
+	Notifications     []NotificationChannel `json:"notification_channels,omitempty"`
+	ForwardTargets    []ForwardTarget       `json:"forward_targets,omitempty"`
+}
+
+// DeviceHealthConfig controls device silence detection
+type DeviceHealthConfig struct {
+	SilenceAfterMinutes int `json:"silence_after_minutes,omitempty"` // Minutes without messages before a device is silent, 0 = default (15)
+}
+
+// RetentionConfig controls how long logs are kept. Database.Limit (max rows)
+// is enforced alongside these settings.
+type RetentionConfig struct {
+	MaxAgeDays      int             `json:"max_age_days,omitempty"`     // Delete logs older than this, 0 = keep forever
+	MaxSizeMB       int             `json:"max_size_mb,omitempty"`      // Delete oldest logs while the database is larger, 0 = unlimited
+	IntervalMinutes int             `json:"interval_minutes,omitempty"` // How often retention runs, 0 = default (10)
+	Rules           []RetentionRule `json:"rules,omitempty"`            // Per device type/severity overrides of MaxAgeDays
+}
+
+// RetentionRule overrides the max age for matching logs. The first matching
+// rule wins; logs matching no rule use RetentionConfig.MaxAgeDays.
+type RetentionRule struct {
+	DeviceType string `json:"device_type,omitempty"` // Empty = any device type
+	Severities []int  `json:"severities,omitempty"`  // Empty = any severity
+	MaxAgeDays int    `json:"max_age_days"`          // 0 = keep forever
+}
+
+// LogMatch selects logs by severity, device and event type. Empty fields
+// match any log.
+type LogMatch struct {
+	Severities []int  `json:"severities,omitempty"`
+	Device     string `json:"device,omitempty"` // Hostname/remote address substring
+	DeviceType string `json:"device_type,omitempty"`
+	EventType  string `json:"event_type,omitempty"`
+}
+
+// AlertRule fires when Threshold logs matching its conditions arrive within
+// WindowSeconds. With GroupBy set, logs are counted separately per value of
+// that field, e.g. 10 login_failure events from the same source_ip.
+type AlertRule struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	LogMatch
+	Fields          map[string]string `json:"fields,omitempty"`           // Parsed field -> required value
+	Threshold       int               `json:"threshold,omitempty"`        // Matching logs needed to fire, 0 = every log
+	WindowSeconds   int               `json:"window_seconds,omitempty"`   // Sliding window for Threshold, 0 = default (300)
+	GroupBy         string            `json:"group_by,omitempty"`         // Log column or parsed field to count per value
+	CooldownSeconds int               `json:"cooldown_seconds,omitempty"` // Minimum time between alerts per group
+	Channels        []string          `json:"channels,omitempty"`         // Notification channel IDs to notify when fired
+}
+
+// NotificationChannel is an output that fired alerts are delivered to.
+// Exactly one of Webhook, SMTP or Syslog is used, selected by Type.
+type NotificationChannel struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Type       string              `json:"type"` // "webhook", "smtp" or "syslog"
+	Enabled    bool                `json:"enabled"`
+	MaxRetries *int                `json:"max_retries,omitempty"` // Retries after a failed delivery, nil = default (3)
+	Webhook    *WebhookConfig      `json:"webhook,omitempty"`
+	SMTP       *SMTPConfig         `json:"smtp,omitempty"`
+	Syslog     *SyslogNotifyConfig `json:"syslog,omitempty"`
+}
+
+// WebhookConfig posts alerts to an HTTP endpoint
+type WebhookConfig struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method,omitempty"`          // Default POST
+	Headers        map[string]string `json:"headers,omitempty"`         // Extra request headers
+	BodyTemplate   string            `json:"body_template,omitempty"`   // Go text/template producing JSON, empty = alert as JSON
+	Secret         string            `json:"secret,omitempty"`          // HMAC-SHA256 key for the X-Qlog-Signature header
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"` // Default 10
+}
+
+// SMTPConfig emails alerts
+type SMTPConfig struct {
+	Host       string   `json:"host"`
+	Port       int      `json:"port"`               // Default 25 (587 with STARTTLS)
+	Security   string   `json:"security,omitempty"` // "none" or "starttls"
+	Username   string   `json:"username,omitempty"` // PLAIN auth, requires STARTTLS unless host is localhost
+	Password   string   `json:"password,omitempty"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+	SkipVerify bool     `json:"skip_verify,omitempty"` // Don't verify the server certificate (STARTTLS)
+}
+
+// SyslogNotifyConfig sends alerts as RFC5424 messages to another syslog receiver
+type SyslogNotifyConfig struct {
+	Address    string `json:"address"`            // host:port
+	Protocol   string `json:"protocol,omitempty"` // "udp" (default), "tcp" or "tls"
+	Facility   *uint8 `json:"facility,omitempty"` // Default 16 (local0)
+	Severity   *uint8 `json:"severity,omitempty"` // Default 1 (alert)
+	AppName    string `json:"app_name,omitempty"` // Default "qlog"
+	SkipVerify bool   `json:"skip_verify,omitempty"`
+}
+
+// ForwardTarget relays saved logs to an upstream syslog receiver. Logs are
+// buffered on disk so they survive an outage of the target.
+type ForwardTarget struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Enabled    bool   `json:"enabled"`
+	Address    string `json:"address"`                // host:port
+	Protocol   string `json:"protocol,omitempty"`     // "udp" (default), "tcp" or "tls"
+	Framing    string `json:"framing,omitempty"`      // TCP/TLS: "octet-counting" (default) or "non-transparent" (LF)
+	Format     string `json:"format,omitempty"`       // "raw" (default), "rfc5424" or "rfc3164"
+	CaCertFile string `json:"ca_cert_file,omitempty"` // CA to verify the TLS server, empty = system roots
+	SkipVerify bool   `json:"skip_verify,omitempty"`  // Don't verify the TLS server certificate
+	QueueMaxMB int    `json:"queue_max_mb,omitempty"` // On-disk buffer limit, 0 = default (256)
+	LogMatch          // Only forward matching logs
 }
 
 type CustomizationConfig struct {
@@ -83,27 +194,79 @@ type WidgetConfig struct {
 	Config map[string]interface{} `json:"config"`
 }
 
+// ParsingConfig holds the parser defaults for listeners that don't set their own
+type ParsingConfig struct {
+	BestEffort     bool `json:"best_effort"`
+	RFC3164Enabled bool `json:"rfc3164_enabled"` // Used by auto-detecting listeners
+	RFC5424Enabled bool `json:"rfc5424_enabled"` // Used by auto-detecting listeners
+}
+
 type ListenerConfig struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Enabled     bool   `json:"enabled"`
-	Protocol    string `json:"protocol"` // UDP, TCP, TLS
-	Port        int    `json:"port"`
-	Framing     string `json:"framing,omitempty"`      // "non-transparent" or "octet-counting" (for TCP/TLS)
-	Parser      string `json:"parser"`                 // "RFC5424" or "RFC3164"
-	CertFile    string `json:"cert_file,omitempty"`    // Path to uploaded certificate
-	KeyFile     string `json:"key_file,omitempty"`     // Path to uploaded private key
-	CaCertFile  string `json:"ca_cert_file,omitempty"` // Path to CA certificate for client validation (RFC 5425)
-	Description string `json:"description,omitempty"`
+	ID                 string           `json:"id"`
+	Name               string           `json:"name"`
+	Enabled            bool             `json:"enabled"`
+	Protocol           string           `json:"protocol"` // UDP, TCP, TLS, RELP, UNIX
+	Port               int              `json:"port"`
+	Framing            string           `json:"framing,omitempty"`             // TCP and UNIX stream: "non-transparent" (default), "octet-counting" or "auto" to detect per connection; TLS always uses octet-counting
+	Parser             string           `json:"parser"`                        // "RFC5424", "RFC3164", "auto" (default) or "raw" (store unparsed)
+	BestEffort         *bool            `json:"best_effort,omitempty"`         // Accept partially valid messages, nil = global parsing setting
+	ParserFallback     string           `json:"parser_fallback,omitempty"`     // When no parser accepts a message: "alternate" (default for RFC5424/RFC3164), "raw" (default otherwise) or "drop"
+	CertFile           string           `json:"cert_file,omitempty"`           // Path to uploaded certificate
+	KeyFile            string           `json:"key_file,omitempty"`            // Path to uploaded private key
+	CaCertFile         string           `json:"ca_cert_file,omitempty"`        // Path to CA certificate for client validation (RFC 5425)
+	QueueSize          int              `json:"queue_size,omitempty"`          // Max messages waiting for a worker before drops (UDP, UNIX datagram), 0 = default
+	Workers            int              `json:"workers,omitempty"`             // Number of workers processing queued messages (UDP, UNIX datagram), 0 = CPU count
+	ProxyProtocol      bool             `json:"proxy_protocol,omitempty"`      // Read PROXY protocol v1/v2 headers (TCP, TLS)
+	ProxyTrustedCIDRs  []string         `json:"proxy_trusted_cidrs,omitempty"` // Peers whose PROXY headers are honored; others are served as direct connections
+	ClientAuth         string           `json:"client_auth,omitempty"`         // Client certificates (TLS, RELP): "none", "optional" (default with a CA or fingerprints) or "require"
+	CRLFile            string           `json:"crl_file,omitempty"`            // Path to a CRL of revoked client certificates, signed by the CA
+	ClientFingerprints []string         `json:"client_fingerprints,omitempty"` // SHA-256 fingerprints of the only client certificates accepted, empty = any
+	AllowCIDRs         []string         `json:"allow_cidrs,omitempty"`         // Only accept senders in these IPs/CIDR ranges, empty = all (not UNIX)
+	DenyCIDRs          []string         `json:"deny_cidrs,omitempty"`          // Refuse senders in these IPs/CIDR ranges, checked before allow_cidrs (not UNIX)
+	RateLimit          *RateLimitConfig `json:"rate_limit,omitempty"`          // Per-source message rate limit, nil = unlimited
+	Multiline          *MultilineConfig `json:"multiline,omitempty"`           // Join continuation lines into one message (TCP), nil = off
+	SocketPath         string           `json:"socket_path,omitempty"`         // Socket file to bind (UNIX)
+	SocketType         string           `json:"socket_type,omitempty"`         // "datagram" (default) or "stream" (UNIX)
+	SocketMode         string           `json:"socket_mode,omitempty"`         // Octal file permissions of the socket, e.g. "0660" (UNIX), default "0666"
+	DeviceID           string           `json:"device_id,omitempty"`           // Device messages are attributed to (UNIX)
+	Description        string           `json:"description,omitempty"`
 }
 
 type DeviceConfig struct {
 	ID          string   `json:"id"`
 	Name        string   `json:"name"`
-	DeviceType  string   `json:"device_type"`  // e.g., "meraki", "generic"
-	ListenerID  string   `json:"listener_id"`  // Which listener this device uses
-	IPAddresses []string `json:"ip_addresses"` // IP addresses to filter messages from
+	DeviceType  string   `json:"device_type"`         // e.g., "meraki", "generic"
+	ListenerID  string   `json:"listener_id"`         // Which listener this device uses
+	IPAddresses []string `json:"ip_addresses"`        // Sender IPs or CIDR ranges (IPv4/IPv6) to match messages from
+	Hostnames   []string `json:"hostnames,omitempty"` // Syslog HOSTNAME values to match when no address matches
 	Description string   `json:"description,omitempty"`
+
+	// Client certificate subjects, common names or subject alternative names
+	// (DNS, email, URI, IP) that identify the device on TLS and RELP
+	// listeners, regardless of the sender's address
+	CertIdentities []string `json:"cert_identities,omitempty"`
+
+	SilenceAfterMinutes int              `json:"silence_after_minutes,omitempty"` // Overrides device_health.silence_after_minutes
+	RateLimit           *RateLimitConfig `json:"rate_limit,omitempty"`            // Overrides the listener's rate_limit for this device's senders
+	Multiline           *MultilineConfig `json:"multiline,omitempty"`             // Overrides the listener's multiline rules for this device's senders (TCP)
+}
+
+// RateLimitConfig is a token bucket applied to each source address
+type RateLimitConfig struct {
+	MessagesPerSecond float64 `json:"messages_per_second"`       // Sustained rate, <= 0 = unlimited
+	Burst             int     `json:"burst,omitempty"`           // Bucket size, default one second's worth
+	Action            string  `json:"action,omitempty"`          // For excess messages: "drop" (default) or "sample"
+	SampleRate        int     `json:"sample_rate,omitempty"`     // With "sample", keep 1 in sample_rate excess messages, default 10
+	ExemptSeverity    *int    `json:"exempt_severity,omitempty"` // Never limit severities <= this, default 2 (critical), -1 = limit all
+}
+
+// MultilineConfig joins frames that continue the previous message, such as
+// the lines of a stack trace sent one per frame, into one log
+type MultilineConfig struct {
+	ContinuationPattern string `json:"continuation_pattern,omitempty"` // Regular expression matching the message of a continuation line
+	LeadingWhitespace   bool   `json:"leading_whitespace,omitempty"`   // Messages starting with a space or tab are continuation lines
+	MaxLines            int    `json:"max_lines,omitempty"`            // Most lines joined into one log, 0 = default (500)
+	FlushTimeoutMs      int    `json:"flush_timeout_ms,omitempty"`     // How long to wait for further lines before storing, 0 = default (1000)
 }
 
 func LoadConfig(path string) (*Config, error) {
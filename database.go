@@ -4,17 +4,37 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteDriver is go-sqlite3 with qLog's SQL functions registered
+const sqliteDriver = "sqlite3_qlog"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// cidr_match(ip, cidr) is used by the query language for CIDR filters
+			return conn.RegisterFunc("cidr_match", cidrMatch, true)
+		},
+	})
+}
+
 type Database struct {
 	db *sql.DB
+
+	// Full-text search state: ftsAvailable when SQLite was built with FTS5,
+	// ftsReady once existing rows have been backfilled into the index
+	ftsAvailable atomic.Bool
+	ftsReady     atomic.Bool
 }
 
 func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	db, err := sql.Open(sqliteDriver, dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -51,6 +71,10 @@ func (d *Database) initSchema() error {
 		event_type TEXT,
 		event_category TEXT,
 		parsed_fields TEXT,
+		listener_id TEXT,
+		received_at DATETIME,
+		device_id TEXT,
+		client_identity TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
@@ -92,26 +116,154 @@ func (d *Database) initSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts(username);
 	CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address);
 	CREATE INDEX IF NOT EXISTS idx_login_attempts_created ON login_attempts(created_at);
+
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id TEXT NOT NULL,
+		rule_name TEXT,
+		group_by TEXT,
+		group_value TEXT,
+		event_count INTEGER NOT NULL DEFAULT 0,
+		message TEXT,
+		log_ids TEXT,
+		fired_at DATETIME NOT NULL,
+		acknowledged INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_alerts_fired_at ON alerts(fired_at);
+	CREATE INDEX IF NOT EXISTS idx_alerts_rule_id ON alerts(rule_id);
+
+	CREATE TABLE IF NOT EXISTS notification_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		alert_id INTEGER,
+		channel_id TEXT NOT NULL,
+		channel_name TEXT,
+		channel_type TEXT,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_notification_deliveries_created ON notification_deliveries(created_at);
+	CREATE INDEX IF NOT EXISTS idx_notification_deliveries_alert ON notification_deliveries(alert_id);
+
+	CREATE TABLE IF NOT EXISTS unknown_senders (
+		source_ip TEXT PRIMARY KEY,
+		message_count INTEGER NOT NULL DEFAULT 0,
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		hostname TEXT,
+		listener_id TEXT,
+		suggested_device_type TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_unknown_senders_last_seen ON unknown_senders(last_seen);
+
+	CREATE TABLE IF NOT EXISTS quarantine (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_ip TEXT NOT NULL,
+		protocol TEXT,
+		rfc_format TEXT,
+		entry TEXT NOT NULL,
+		received_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_quarantine_source ON quarantine(source_ip, id);
+
+	CREATE TABLE IF NOT EXISTS listener_stats (
+		listener_id TEXT PRIMARY KEY,
+		counters TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS issued_certificates (
+		serial TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		listener_id TEXT,
+		device_id TEXT,
+		fingerprint TEXT NOT NULL,
+		not_before DATETIME NOT NULL,
+		not_after DATETIME NOT NULL,
+		issued_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_issued_certificates_device ON issued_certificates(device_id);
 	`
 
-	_, err := d.db.Exec(schema)
-	return err
+	if _, err := d.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the logs table was first released
+	if err := d.addColumnIfMissing("logs", "listener_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("logs", "received_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("logs", "device_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("logs", "client_identity", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_device_id ON logs(device_id, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_listener_id ON logs(listener_id, timestamp);
+	`); err != nil {
+		return err
+	}
+
+	return d.initSearchIndex()
 }
 
-func (d *Database) InsertLog(entry *LogEntry, protocol, rfcFormat string) error {
-	structuredDataJSON, _ := json.Marshal(entry.StructuredData)
-	parsedFieldsJSON, _ := json.Marshal(entry.ParsedFields)
+// addColumnIfMissing adds a column to a table created by an older version
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	query := `
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+const insertLogQuery = `
 	INSERT INTO logs (
 		timestamp, priority, facility, severity, version,
 		hostname, appname, procid, msgid, message,
 		structured_data, raw_message, remote_addr, protocol, rfc_format,
-		device_type, event_type, event_category, parsed_fields
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		device_type, event_type, event_category, parsed_fields,
+		listener_id, received_at, device_id, client_identity
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := d.db.Exec(query,
+// insertLogArgs returns the column values for insertLogQuery
+func insertLogArgs(entry *LogEntry, protocol, rfcFormat string) []interface{} {
+	structuredDataJSON, _ := json.Marshal(entry.StructuredData)
+	parsedFieldsJSON, _ := json.Marshal(entry.ParsedFields)
+
+	return []interface{}{
 		entry.Timestamp,
 		entry.Priority,
 		entry.Facility,
@@ -131,24 +283,70 @@ func (d *Database) InsertLog(entry *LogEntry, protocol, rfcFormat string) error
 		entry.EventType,
 		entry.EventCategory,
 		string(parsedFieldsJSON),
-	)
+		entry.ListenerID,
+		entry.ReceivedAt,
+		entry.DeviceID,
+		entry.ClientIdentity,
+	}
+}
 
-	return err
+func (d *Database) InsertLog(entry *LogEntry, protocol, rfcFormat string) error {
+	result, err := d.db.Exec(insertLogQuery, insertLogArgs(entry, protocol, rfcFormat)...)
+	if err != nil {
+		return err
+	}
+
+	if id, err := result.LastInsertId(); err == nil {
+		entry.ID = id
+	}
+	return nil
+}
+
+// InsertLogBatch inserts a batch of logs in a single transaction using a
+// prepared statement. Entry IDs are filled in on success.
+func (d *Database) InsertLogBatch(batch []PendingLog) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(insertLogQuery)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, len(batch))
+	for i, pending := range batch {
+		result, err := stmt.Exec(insertLogArgs(pending.Entry, pending.Protocol, pending.RFCFormat)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		ids[i], _ = result.LastInsertId()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Only assign IDs once the rows are committed
+	for i, pending := range batch {
+		pending.Entry.ID = ids[i]
+	}
+	return nil
 }
 
-func (d *Database) GetLogs(limit, offset int, severity *uint8, device, deviceType, eventType, dateRange, search string) ([]*LogEntry, error) {
-	return d.GetLogsWithCustomDate(limit, offset, severity, device, deviceType, eventType, dateRange, "", "", search)
+// GetLogs returns logs matching the filters, newest first. deviceID and
+// listenerID match exactly; device is a substring match on hostname, remote
+// address and parsed fields.
+func (d *Database) GetLogs(limit, offset int, severity *uint8, device, deviceID, listenerID, deviceType, eventType, dateRange, search string) ([]*LogEntry, error) {
+	return d.GetLogsWithCustomDate(limit, offset, severity, device, deviceID, listenerID, deviceType, eventType, dateRange, "", "", search)
 }
 
-func (d *Database) GetLogsWithCustomDate(limit, offset int, severity *uint8, device, deviceType, eventType, dateRange, dateFrom, dateTo, search string) ([]*LogEntry, error) {
-	query := `
-	SELECT id, timestamp, priority, facility, severity, version,
-	       hostname, appname, procid, msgid, message,
-	       structured_data, raw_message, remote_addr,
-	       device_type, event_type, event_category, parsed_fields
-	FROM logs
-	WHERE 1=1
-	`
+func (d *Database) GetLogsWithCustomDate(limit, offset int, severity *uint8, device, deviceID, listenerID, deviceType, eventType, dateRange, dateFrom, dateTo, search string) ([]*LogEntry, error) {
+	query := "SELECT " + logColumns + " FROM logs WHERE 1=1"
 	args := []interface{}{}
 
 	// Date range filter - check for custom date range first
@@ -178,6 +376,14 @@ func (d *Database) GetLogsWithCustomDate(limit, offset int, severity *uint8, dev
 		devicePattern := "%" + device + "%"
 		args = append(args, devicePattern, devicePattern, devicePattern)
 	}
+	if deviceID != "" {
+		query += " AND device_id = ?"
+		args = append(args, deviceID)
+	}
+	if listenerID != "" {
+		query += " AND listener_id = ?"
+		args = append(args, listenerID)
+	}
 	if deviceType != "" {
 		query += " AND device_type = ?"
 		args = append(args, deviceType)
@@ -186,7 +392,11 @@ func (d *Database) GetLogsWithCustomDate(limit, offset int, severity *uint8, dev
 		query += " AND event_type = ?"
 		args = append(args, eventType)
 	}
-	if search != "" {
+	if ftsQuery := d.searchIndexQuery(search); ftsQuery != "" {
+		query += " AND id IN (SELECT rowid FROM logs_fts WHERE logs_fts MATCH ?)"
+		args = append(args, ftsQuery)
+	} else if search != "" {
+		// Full-text index unavailable or still backfilling - fall back to LIKE.
 		// Enhanced search: search across multiple fields including raw_message, hostname, message, and parsed_fields
 		searchPattern := "%" + search + "%"
 		query += ` AND (
@@ -211,41 +421,468 @@ func (d *Database) GetLogsWithCustomDate(limit, offset int, severity *uint8, dev
 	}
 	defer rows.Close()
 
+	return scanLogRows(rows)
+}
+
+// logColumns is the column list read into a LogEntry by scanLogEntry
+const logColumns = `id, timestamp, priority, facility, severity, version,
+	       hostname, appname, procid, msgid, message,
+	       structured_data, raw_message, remote_addr,
+	       device_type, event_type, event_category, parsed_fields,
+	       listener_id, received_at, device_id, client_identity`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLogEntry reads a row selected with logColumns
+func scanLogEntry(row rowScanner) (*LogEntry, error) {
+	var entry LogEntry
+	var structuredDataJSON string
+	var parsedFieldsJSON string
+	var listenerID, deviceID, clientIdentity sql.NullString // NULL for logs stored before these columns existed
+	var receivedAt sql.NullTime
+
+	err := row.Scan(
+		&entry.ID, &entry.Timestamp, &entry.Priority, &entry.Facility,
+		&entry.Severity, &entry.Version, &entry.Hostname, &entry.AppName,
+		&entry.ProcID, &entry.MsgID, &entry.Message, &structuredDataJSON,
+		&entry.RawMessage, &entry.RemoteAddr,
+		&entry.DeviceType, &entry.EventType, &entry.EventCategory, &parsedFieldsJSON,
+		&listenerID, &receivedAt, &deviceID, &clientIdentity,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.ListenerID = listenerID.String
+	entry.ReceivedAt = receivedAt.Time
+	entry.DeviceID = deviceID.String
+	entry.ClientIdentity = clientIdentity.String
+
+	if structuredDataJSON != "" {
+		json.Unmarshal([]byte(structuredDataJSON), &entry.StructuredData)
+	}
+	if entry.StructuredData == nil {
+		entry.StructuredData = make(map[string]map[string]string)
+	}
+
+	if parsedFieldsJSON != "" {
+		json.Unmarshal([]byte(parsedFieldsJSON), &entry.ParsedFields)
+	}
+	if entry.ParsedFields == nil {
+		entry.ParsedFields = make(map[string]interface{})
+	}
+
+	return &entry, nil
+}
+
+// scanLogRows reads all rows selected with logColumns, skipping unreadable rows
+func scanLogRows(rows *sql.Rows) ([]*LogEntry, error) {
 	logs := make([]*LogEntry, 0) // Initialize as empty slice, not nil
 	for rows.Next() {
-		var entry LogEntry
-		var structuredDataJSON string
-		var parsedFieldsJSON string
-
-		err := rows.Scan(
-			&entry.ID, &entry.Timestamp, &entry.Priority, &entry.Facility,
-			&entry.Severity, &entry.Version, &entry.Hostname, &entry.AppName,
-			&entry.ProcID, &entry.MsgID, &entry.Message, &structuredDataJSON,
-			&entry.RawMessage, &entry.RemoteAddr,
-			&entry.DeviceType, &entry.EventType, &entry.EventCategory, &parsedFieldsJSON,
-		)
+		entry, err := scanLogEntry(rows)
 		if err != nil {
 			continue
 		}
+		logs = append(logs, entry)
+	}
+
+	return logs, rows.Err()
+}
+
+// Full-text search functions
+
+// searchIndexColumns are the logs columns indexed by logs_fts
+const searchIndexColumns = "raw_message, message, hostname, appname, device_type, event_type, event_category, parsed_fields"
+
+// initSearchIndex creates the FTS5 index over logs and the triggers keeping it
+// in sync. If SQLite was built without FTS5 search falls back to LIKE.
+//
+// Rows that existed before the index was created are indexed in the
+// background by BackfillSearchIndex, newest first. logs_fts_state.pending_max
+// is the highest id not yet indexed; the delete/update triggers skip those
+// rows so the index never receives a delete for a row it doesn't contain.
+func (d *Database) initSearchIndex() error {
+	var exists int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'logs_fts'").Scan(&exists); err != nil {
+		return err
+	}
 
-		if structuredDataJSON != "" {
-			json.Unmarshal([]byte(structuredDataJSON), &entry.StructuredData)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS logs_fts USING fts5(" + searchIndexColumns + ", content='logs', content_rowid='id')")
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Printf("Full-text search unavailable (build with -tags sqlite_fts5); search will use LIKE")
+			return nil
 		}
-		if entry.StructuredData == nil {
-			entry.StructuredData = make(map[string]map[string]string)
+		return err
+	}
+
+	newColumns := "new." + strings.ReplaceAll(searchIndexColumns, ", ", ", new.")
+	oldColumns := "old." + strings.ReplaceAll(searchIndexColumns, ", ", ", old.")
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS logs_fts_state (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		pending_max INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TRIGGER IF NOT EXISTS logs_fts_insert AFTER INSERT ON logs BEGIN
+		INSERT INTO logs_fts(rowid, ` + searchIndexColumns + `) VALUES (new.id, ` + newColumns + `);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS logs_fts_delete AFTER DELETE ON logs
+	WHEN old.id > (SELECT pending_max FROM logs_fts_state WHERE id = 1) BEGIN
+		INSERT INTO logs_fts(logs_fts, rowid, ` + searchIndexColumns + `) VALUES ('delete', old.id, ` + oldColumns + `);
+	END;
+
+	DROP TRIGGER IF EXISTS logs_fts_update;
+	CREATE TRIGGER logs_fts_update AFTER UPDATE OF ` + searchIndexColumns + ` ON logs
+	WHEN old.id > (SELECT pending_max FROM logs_fts_state WHERE id = 1) BEGIN
+		INSERT INTO logs_fts(logs_fts, rowid, ` + searchIndexColumns + `) VALUES ('delete', old.id, ` + oldColumns + `);
+		INSERT INTO logs_fts(rowid, ` + searchIndexColumns + `) VALUES (new.id, ` + newColumns + `);
+	END;
+	`
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+
+	// A new index on an existing database needs the current rows backfilled
+	if exists == 0 {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO logs_fts_state (id, pending_max) SELECT 1, COALESCE(MAX(id), 0) FROM logs"); err != nil {
+			return err
 		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	d.ftsAvailable.Store(true)
+
+	var pending int64
+	d.db.QueryRow("SELECT pending_max FROM logs_fts_state WHERE id = 1").Scan(&pending)
+	d.ftsReady.Store(pending == 0)
+
+	return nil
+}
 
-		if parsedFieldsJSON != "" {
-			json.Unmarshal([]byte(parsedFieldsJSON), &entry.ParsedFields)
+// searchIndexQuery returns the FTS5 MATCH expression for search, or "" if the
+// index can't be used (unavailable, still backfilling, or nothing to match)
+func (d *Database) searchIndexQuery(search string) string {
+	if search == "" || !d.ftsReady.Load() {
+		return ""
+	}
+	return buildFTSQuery(search)
+}
+
+// BackfillSearchIndex indexes rows that existed before the full-text index
+// was created, in small chunks, newest first. Search uses LIKE until done.
+func (d *Database) BackfillSearchIndex() {
+	if !d.ftsAvailable.Load() || d.ftsReady.Load() {
+		return
+	}
+
+	var pending int64
+	d.db.QueryRow("SELECT pending_max FROM logs_fts_state WHERE id = 1").Scan(&pending)
+	log.Printf("Backfilling full-text search index (%d rows pending)...", pending)
+
+	start := time.Now()
+	for {
+		remaining, err := d.backfillSearchIndexChunk(retentionChunkSize)
+		if err != nil {
+			log.Printf("Full-text index backfill failed, retrying: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
 		}
-		if entry.ParsedFields == nil {
-			entry.ParsedFields = make(map[string]interface{})
+		if remaining == 0 {
+			break
 		}
+		// Leave room for the log writer between chunks
+		time.Sleep(10 * time.Millisecond)
+	}
 
-		logs = append(logs, &entry)
+	d.ftsReady.Store(true)
+	log.Printf("Full-text search index backfill complete in %s", time.Since(start).Round(time.Second))
+}
+
+// backfillSearchIndexChunk indexes up to chunk ids below pending_max and
+// returns the new pending_max
+func (d *Database) backfillSearchIndexChunk(chunk int64) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	return logs, rows.Err()
+	var pending int64
+	if err := tx.QueryRow("SELECT pending_max FROM logs_fts_state WHERE id = 1").Scan(&pending); err != nil {
+		return 0, err
+	}
+	if pending <= 0 {
+		return 0, nil
+	}
+
+	low := max(pending-chunk, 0)
+	_, err = tx.Exec("INSERT INTO logs_fts(rowid, "+searchIndexColumns+") SELECT id, "+searchIndexColumns+" FROM logs WHERE id > ? AND id <= ?", low, pending)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE logs_fts_state SET pending_max = ? WHERE id = 1", low); err != nil {
+		return 0, err
+	}
+
+	return low, tx.Commit()
+}
+
+// SearchLogs runs a full-text search ordered by relevance (bm25), falling
+// back to a LIKE search ordered by time if the index can't be used
+func (d *Database) SearchLogs(search string, limit, offset int) ([]*LogEntry, error) {
+	ftsQuery := d.searchIndexQuery(search)
+	if ftsQuery == "" {
+		return d.GetLogs(limit, offset, nil, "", "", "", "", "", "", search)
+	}
+
+	query := "SELECT " + logColumns + ` FROM logs
+	JOIN (SELECT rowid AS match_id, bm25(logs_fts) AS match_rank FROM logs_fts WHERE logs_fts MATCH ?) matches
+	ON matches.match_id = logs.id
+	ORDER BY matches.match_rank, logs.timestamp DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := d.db.Query(query, ftsQuery, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLogRows(rows)
+}
+
+// BackfillDeviceIDs attributes logs stored before device_id was recorded to
+// the device match returns for their sender, in small chunks, newest first.
+// Logs with no matching device get an empty device_id.
+func (d *Database) BackfillDeviceIDs(match func(remoteAddr, hostname string) string) {
+	var pending int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM logs WHERE device_id IS NULL").Scan(&pending); err != nil || pending == 0 {
+		return
+	}
+	log.Printf("Backfilling device IDs (%d logs pending)...", pending)
+
+	start := time.Now()
+	matched := make(map[[2]string]string)
+	for {
+		n, err := d.backfillDeviceIDsChunk(retentionChunkSize, func(remoteAddr, hostname string) string {
+			key := [2]string{remoteAddr, hostname}
+			id, ok := matched[key]
+			if !ok {
+				id = match(remoteAddr, hostname)
+				matched[key] = id
+			}
+			return id
+		})
+		if err != nil {
+			log.Printf("Device ID backfill failed, retrying: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if n == 0 {
+			break
+		}
+		// Leave room for the log writer between chunks
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	log.Printf("Device ID backfill complete in %s", time.Since(start).Round(time.Second))
+}
+
+// backfillDeviceIDsChunk sets device_id on up to chunk logs that have none
+// and returns how many it updated
+func (d *Database) backfillDeviceIDsChunk(chunk int64, match func(remoteAddr, hostname string) string) (int, error) {
+	rows, err := d.db.Query("SELECT id, remote_addr, hostname FROM logs WHERE device_id IS NULL ORDER BY id DESC LIMIT ?", chunk)
+	if err != nil {
+		return 0, err
+	}
+	type pendingLog struct {
+		id                   int64
+		remoteAddr, hostname string
+	}
+	var batch []pendingLog
+	for rows.Next() {
+		var p pendingLog
+		var remoteAddr, hostname sql.NullString
+		if err := rows.Scan(&p.id, &remoteAddr, &hostname); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.remoteAddr, p.hostname = remoteAddr.String, hostname.String
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE logs SET device_id = ? WHERE id = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, p := range batch {
+		if _, err := stmt.Exec(match(p.remoteAddr, p.hostname), p.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// GetDeviceLastSeen returns when the newest log from deviceID was received,
+// or the zero time if there is none. Internal events are ignored. Logs
+// stored without a receive time fall back to their timestamp.
+func (d *Database) GetDeviceLastSeen(deviceID string) (time.Time, error) {
+	var receivedAt, timestamp sql.NullTime
+	err := d.db.QueryRow(`SELECT received_at, timestamp FROM logs
+		WHERE device_id = ? AND protocol != 'internal'
+		ORDER BY COALESCE(received_at, timestamp) DESC LIMIT 1`, deviceID).Scan(&receivedAt, &timestamp)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if receivedAt.Valid && !receivedAt.Time.IsZero() {
+		return receivedAt.Time, nil
+	}
+	return timestamp.Time, nil
+}
+
+// SaveListenerStats stores the cumulative counters of each listener
+func (d *Database) SaveListenerStats(stats map[string]ListenerCounters) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.Prepare(`INSERT INTO listener_stats (listener_id, counters, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(listener_id) DO UPDATE SET counters = excluded.counters, updated_at = excluded.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	now := time.Now()
+	for listenerID, counters := range stats {
+		countersJSON, err := json.Marshal(counters)
+		if err != nil {
+			return err
+		}
+		if _, err := upsert.Exec(listenerID, string(countersJSON), now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetListenerStats returns the saved counters of every listener
+func (d *Database) GetListenerStats() (map[string]ListenerCounters, error) {
+	rows, err := d.db.Query("SELECT listener_id, counters FROM listener_stats")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]ListenerCounters)
+	for rows.Next() {
+		var listenerID, countersJSON string
+		if err := rows.Scan(&listenerID, &countersJSON); err != nil {
+			return nil, err
+		}
+		var counters ListenerCounters
+		if err := json.Unmarshal([]byte(countersJSON), &counters); err != nil {
+			log.Printf("Ignoring unreadable statistics of listener %s: %v", listenerID, err)
+			continue
+		}
+		stats[listenerID] = counters
+	}
+	return stats, rows.Err()
+}
+
+// DeleteListenerStats removes the saved counters of a listener
+func (d *Database) DeleteListenerStats(listenerID string) error {
+	_, err := d.db.Exec("DELETE FROM listener_stats WHERE listener_id = ?", listenerID)
+	return err
+}
+
+// InsertIssuedCertificate records a certificate issued by the internal CA
+func (d *Database) InsertIssuedCertificate(cert *IssuedCertificate) error {
+	_, err := d.db.Exec(`INSERT INTO issued_certificates
+		(serial, kind, subject, listener_id, device_id, fingerprint, not_before, not_after, issued_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cert.Serial, cert.Kind, cert.Subject, cert.ListenerID, cert.DeviceID, cert.Fingerprint,
+		cert.NotBefore, cert.NotAfter, cert.IssuedAt,
+	)
+	return err
+}
+
+// GetIssuedCertificates returns the certificates issued by the internal CA,
+// newest first. A non-empty deviceID returns only that device's certificates.
+func (d *Database) GetIssuedCertificates(deviceID string) ([]*IssuedCertificate, error) {
+	query := `SELECT serial, kind, subject, listener_id, device_id, fingerprint, not_before, not_after, issued_at, revoked_at
+		FROM issued_certificates`
+	args := []interface{}{}
+	if deviceID != "" {
+		query += " WHERE device_id = ?"
+		args = append(args, deviceID)
+	}
+	rows, err := d.db.Query(query+" ORDER BY issued_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := make([]*IssuedCertificate, 0)
+	for rows.Next() {
+		var cert IssuedCertificate
+		var listenerID, deviceID sql.NullString
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&cert.Serial, &cert.Kind, &cert.Subject, &listenerID, &deviceID, &cert.Fingerprint,
+			&cert.NotBefore, &cert.NotAfter, &cert.IssuedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		cert.ListenerID = listenerID.String
+		cert.DeviceID = deviceID.String
+		if revokedAt.Valid {
+			cert.RevokedAt = &revokedAt.Time
+		}
+		certs = append(certs, &cert)
+	}
+	return certs, rows.Err()
+}
+
+// RevokeIssuedCertificate marks a certificate revoked, keeping the time of
+// an earlier revocation. Returns sql.ErrNoRows for an unknown serial.
+func (d *Database) RevokeIssuedCertificate(serial string, revokedAt time.Time) error {
+	result, err := d.db.Exec("UPDATE issued_certificates SET revoked_at = COALESCE(revoked_at, ?) WHERE serial = ?", revokedAt, serial)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
 func (d *Database) GetStats() (map[string]interface{}, error) {
@@ -349,43 +986,8 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) GetLogByID(id int64) (*LogEntry, error) {
-	var entry LogEntry
-	var structuredDataJSON string
-
-	var parsedFieldsJSON string
-	err := d.db.QueryRow(`
-		SELECT id, timestamp, priority, facility, severity, version,
-		       hostname, appname, procid, msgid, message,
-		       structured_data, raw_message, remote_addr,
-		       device_type, event_type, event_category, parsed_fields
-		FROM logs
-		WHERE id = ?
-	`, id).Scan(
-		&entry.ID, &entry.Timestamp, &entry.Priority, &entry.Facility,
-		&entry.Severity, &entry.Version, &entry.Hostname, &entry.AppName,
-		&entry.ProcID, &entry.MsgID, &entry.Message, &structuredDataJSON,
-		&entry.RawMessage, &entry.RemoteAddr,
-		&entry.DeviceType, &entry.EventType, &entry.EventCategory, &parsedFieldsJSON,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	if structuredDataJSON != "" {
-		json.Unmarshal([]byte(structuredDataJSON), &entry.StructuredData)
-	}
-	if entry.StructuredData == nil {
-		entry.StructuredData = make(map[string]map[string]string)
-	}
-
-	if parsedFieldsJSON != "" {
-		json.Unmarshal([]byte(parsedFieldsJSON), &entry.ParsedFields)
-	}
-	if entry.ParsedFields == nil {
-		entry.ParsedFields = make(map[string]interface{})
-	}
-
-	return &entry, nil
+	row := d.db.QueryRow("SELECT "+logColumns+" FROM logs WHERE id = ?", id)
+	return scanLogEntry(row)
 }
 
 func (d *Database) GetEventTypes() ([]string, error) {
@@ -413,45 +1015,23 @@ type QueryResult struct {
 	Meta    map[string]interface{}   `json:"meta,omitempty"`
 }
 
-// ExecuteQuery executes a custom query with aggregations
+// ExecuteQuery runs a query language query (see query_language.go) within
+// the given time range and returns its rows
 func (d *Database) ExecuteQuery(queryConfig map[string]interface{}) (*QueryResult, error) {
-	// Extract query parameters
-	selectField := getString(queryConfig, "select", "count(*)")
-	fromTable := getString(queryConfig, "from", "logs")
-	whereClause := getString(queryConfig, "where", "")
-	groupBy := getString(queryConfig, "groupBy", "")
-	orderBy := getString(queryConfig, "orderBy", "")
-	limit := getInt(queryConfig, "limit", 100)
+	queryText := getString(queryConfig, "query", "")
 	timeRange := getString(queryConfig, "timeRange", "24h")
 
-	// Build WHERE clause with time range
-	timeFilter := d.buildTimeFilter(timeRange)
-	if whereClause != "" {
-		whereClause = "(" + whereClause + ") AND " + timeFilter
-	} else {
-		whereClause = timeFilter
+	parsed, err := ParseQuery(queryText)
+	if err != nil {
+		return nil, err
 	}
 
-	// Build query
-	query := "SELECT " + selectField
-	if groupBy != "" {
-		query += ", " + groupBy
-	}
-	query += " FROM " + fromTable
-	if whereClause != "" {
-		query += " WHERE " + whereClause
-	}
-	if groupBy != "" {
-		query += " GROUP BY " + groupBy
-	}
-	if orderBy != "" {
-		query += " ORDER BY " + orderBy
+	compiled, err := CompileQuery(parsed, d.buildTimeFilter(timeRange), d.ftsReady.Load())
+	if err != nil {
+		return nil, err
 	}
-	query += " LIMIT ?"
-
-	args := []interface{}{limit}
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.db.Query(compiled.SQL, compiled.Args...)
 	if err != nil {
 		return nil, fmt.Errorf("query execution failed: %w", err)
 	}
@@ -465,6 +1045,10 @@ func (d *Database) ExecuteQuery(queryConfig map[string]interface{}) (*QueryResul
 	result := &QueryResult{
 		Columns: columns,
 		Rows:    []map[string]interface{}{},
+		Meta: map[string]interface{}{
+			"query":      queryText,
+			"time_range": timeRange,
+		},
 	}
 
 	for rows.Next() {
@@ -501,34 +1085,10 @@ func (d *Database) GetAggregatedData(aggregation map[string]interface{}) (*Query
 	filters := getMap(aggregation, "filters")
 	topN := getInt(aggregation, "topN", 10)
 
-	timeFilter := d.buildTimeFilter(timeRange)
-
-	// Build WHERE clause
-	whereParts := []string{timeFilter}
-	if filters != nil {
-		if deviceType := getString(filters, "device_type", ""); deviceType != "" {
-			whereParts = append(whereParts, "device_type = '"+deviceType+"'")
-		}
-		if eventType := getString(filters, "event_type", ""); eventType != "" {
-			whereParts = append(whereParts, "event_type = '"+eventType+"'")
-		}
-		if severity := getString(filters, "severity", ""); severity != "" {
-			whereParts = append(whereParts, "severity = "+severity)
-		}
-		// Support filtering by JSON fields in parsed_fields
-		if action := getString(filters, "action", ""); action != "" {
-			whereParts = append(whereParts, "json_extract(parsed_fields, '$.action') = '"+action+"'")
-		}
-		if protocol := getString(filters, "protocol", ""); protocol != "" {
-			whereParts = append(whereParts, "json_extract(parsed_fields, '$.protocol') = '"+protocol+"'")
-		}
-	}
-
-	whereClause := "(" + whereParts[0]
-	for i := 1; i < len(whereParts); i++ {
-		whereClause += " AND " + whereParts[i]
+	whereClause, args := d.buildFilterClause(timeRange, filters)
+	if groupBy == "device" {
+		groupBy = "device_id"
 	}
-	whereClause += ")"
 
 	// Build SELECT based on operation
 	var selectExpr string
@@ -576,8 +1136,9 @@ func (d *Database) GetAggregatedData(aggregation map[string]interface{}) (*Query
 	}
 
 	query += " LIMIT ?"
+	args = append(args, topN)
 
-	rows, err := d.db.Query(query, topN)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("aggregation failed: %w", err)
 	}
@@ -623,24 +1184,10 @@ func (d *Database) GetTimeSeriesData(config map[string]interface{}) (*QueryResul
 	filters := getMap(config, "filters")
 	groupBy := getString(config, "groupBy", "")
 
-	timeFilter := d.buildTimeFilter(timeRange)
-
-	// Build WHERE clause
-	whereParts := []string{timeFilter}
-	if filters != nil {
-		if deviceType := getString(filters, "device_type", ""); deviceType != "" {
-			whereParts = append(whereParts, "device_type = '"+deviceType+"'")
-		}
-		if eventType := getString(filters, "event_type", ""); eventType != "" {
-			whereParts = append(whereParts, "event_type = '"+eventType+"'")
-		}
-	}
-
-	whereClause := "(" + whereParts[0]
-	for i := 1; i < len(whereParts); i++ {
-		whereClause += " AND " + whereParts[i]
+	whereClause, args := d.buildFilterClause(timeRange, filters)
+	if groupBy == "device" {
+		groupBy = "device_id"
 	}
-	whereClause += ")"
 
 	// Build time grouping
 	var timeGroupExpr string
@@ -675,7 +1222,7 @@ func (d *Database) GetTimeSeriesData(config map[string]interface{}) (*QueryResul
 	}
 	query += " ORDER BY time_bucket"
 
-	rows, err := d.db.Query(query)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("time series query failed: %w", err)
 	}
@@ -713,6 +1260,35 @@ func (d *Database) GetTimeSeriesData(config map[string]interface{}) (*QueryResul
 }
 
 // Helper functions
+
+// buildFilterClause builds the WHERE clause shared by aggregations and time
+// series from the time range and filters map
+func (d *Database) buildFilterClause(timeRange string, filters map[string]interface{}) (string, []interface{}) {
+	whereParts := []string{d.buildTimeFilter(timeRange)}
+	args := []interface{}{}
+	if filters != nil {
+		for _, column := range []string{"device_id", "listener_id", "device_type", "event_type"} {
+			if value := getString(filters, column, ""); value != "" {
+				whereParts = append(whereParts, column+" = ?")
+				args = append(args, value)
+			}
+		}
+		if severity := getString(filters, "severity", ""); severity != "" {
+			whereParts = append(whereParts, "severity = ?")
+			args = append(args, severity)
+		}
+		// Support filtering by JSON fields in parsed_fields
+		for _, field := range []string{"action", "protocol"} {
+			if value := getString(filters, field, ""); value != "" {
+				whereParts = append(whereParts, "json_extract(parsed_fields, '$."+field+"') = ?")
+				args = append(args, value)
+			}
+		}
+	}
+
+	return "(" + strings.Join(whereParts, " AND ") + ")", args
+}
+
 func (d *Database) buildTimeFilter(timeRange string) string {
 	switch timeRange {
 	case "1h":
@@ -887,3 +1463,458 @@ func (d *Database) CleanExpiredSessions() error {
 	_, err := d.db.Exec("DELETE FROM sessions WHERE expires_at <= datetime('now')")
 	return err
 }
+
+// Alert functions
+
+const alertColumns = "id, rule_id, rule_name, group_by, group_value, event_count, message, log_ids, fired_at, acknowledged"
+
+// InsertAlert stores a fired alert and sets its ID
+func (d *Database) InsertAlert(alert *Alert) error {
+	logIDs, _ := json.Marshal(alert.LogIDs)
+	result, err := d.db.Exec(
+		"INSERT INTO alerts (rule_id, rule_name, group_by, group_value, event_count, message, log_ids, fired_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		alert.RuleID, alert.RuleName, alert.GroupBy, alert.GroupValue, alert.Count, alert.Message, string(logIDs), alert.FiredAt,
+	)
+	if err != nil {
+		return err
+	}
+	alert.ID, err = result.LastInsertId()
+	return err
+}
+
+// scanAlert reads an alert selected with alertColumns
+func scanAlert(row rowScanner) (*Alert, error) {
+	var alert Alert
+	var ruleName, groupBy, groupValue, message, logIDs sql.NullString
+	var acknowledged int
+	if err := row.Scan(&alert.ID, &alert.RuleID, &ruleName, &groupBy, &groupValue, &alert.Count,
+		&message, &logIDs, &alert.FiredAt, &acknowledged); err != nil {
+		return nil, err
+	}
+	alert.RuleName = ruleName.String
+	alert.GroupBy = groupBy.String
+	alert.GroupValue = groupValue.String
+	alert.Message = message.String
+	alert.Acknowledged = acknowledged == 1
+	alert.LogIDs = []int64{}
+	if logIDs.String != "" {
+		json.Unmarshal([]byte(logIDs.String), &alert.LogIDs)
+	}
+	return &alert, nil
+}
+
+// GetAlerts returns fired alerts, newest first, optionally filtered by rule
+// and acknowledgement state
+func (d *Database) GetAlerts(limit, offset int, ruleID string, unacknowledgedOnly bool) ([]*Alert, error) {
+	query := "SELECT " + alertColumns + " FROM alerts WHERE 1=1"
+	args := []interface{}{}
+	if ruleID != "" {
+		query += " AND rule_id = ?"
+		args = append(args, ruleID)
+	}
+	if unacknowledgedOnly {
+		query += " AND acknowledged = 0"
+	}
+	query += " ORDER BY fired_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*Alert{}
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// GetAlertByID returns a single alert
+func (d *Database) GetAlertByID(id int64) (*Alert, error) {
+	return scanAlert(d.db.QueryRow("SELECT "+alertColumns+" FROM alerts WHERE id = ?", id))
+}
+
+// AcknowledgeAlert marks an alert as acknowledged
+func (d *Database) AcknowledgeAlert(id int64) error {
+	result, err := d.db.Exec("UPDATE alerts SET acknowledged = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAlert removes an alert
+func (d *Database) DeleteAlert(id int64) error {
+	_, err := d.db.Exec("DELETE FROM alerts WHERE id = ?", id)
+	return err
+}
+
+// InsertNotificationDelivery records the outcome of delivering an alert to a channel
+func (d *Database) InsertNotificationDelivery(delivery *NotificationDelivery) error {
+	result, err := d.db.Exec(
+		"INSERT INTO notification_deliveries (alert_id, channel_id, channel_name, channel_type, status, attempts, error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.AlertID, delivery.ChannelID, delivery.ChannelName, delivery.ChannelType,
+		delivery.Status, delivery.Attempts, delivery.Error, delivery.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	delivery.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetNotificationDeliveries returns the delivery log, newest first,
+// optionally filtered by channel and alert
+func (d *Database) GetNotificationDeliveries(limit int, channelID string, alertID int64) ([]*NotificationDelivery, error) {
+	query := "SELECT id, alert_id, channel_id, channel_name, channel_type, status, attempts, error, created_at FROM notification_deliveries WHERE 1=1"
+	args := []interface{}{}
+	if channelID != "" {
+		query += " AND channel_id = ?"
+		args = append(args, channelID)
+	}
+	if alertID > 0 {
+		query += " AND alert_id = ?"
+		args = append(args, alertID)
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*NotificationDelivery{}
+	for rows.Next() {
+		var delivery NotificationDelivery
+		var channelName, channelType, errMsg sql.NullString
+		if err := rows.Scan(&delivery.ID, &delivery.AlertID, &delivery.ChannelID, &channelName, &channelType,
+			&delivery.Status, &delivery.Attempts, &errMsg, &delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		delivery.ChannelName = channelName.String
+		delivery.ChannelType = channelType.String
+		delivery.Error = errMsg.String
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// Quarantine functions
+
+const unknownSenderColumns = `s.source_ip, s.message_count, s.first_seen, s.last_seen, s.hostname, s.listener_id,
+	s.suggested_device_type, (SELECT COUNT(*) FROM quarantine q WHERE q.source_ip = s.source_ip)`
+
+// InsertQuarantineBatch stores messages from unknown senders, updates the
+// per-sender counters and keeps at most perSource messages for each sender
+func (d *Database) InsertQuarantineBatch(batch []QuarantinedLog, perSource int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare("INSERT INTO quarantine (source_ip, protocol, rfc_format, entry, received_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	upsert, err := tx.Prepare(`INSERT INTO unknown_senders
+		(source_ip, message_count, first_seen, last_seen, hostname, listener_id, suggested_device_type)
+		VALUES (?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT(source_ip) DO UPDATE SET
+			message_count = message_count + 1,
+			last_seen = excluded.last_seen,
+			hostname = COALESCE(NULLIF(excluded.hostname, ''), hostname),
+			listener_id = COALESCE(NULLIF(excluded.listener_id, ''), listener_id),
+			suggested_device_type = COALESCE(NULLIF(excluded.suggested_device_type, ''), suggested_device_type)`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	sources := make(map[string]bool)
+	for _, item := range batch {
+		entryJSON, err := json.Marshal(item.Entry)
+		if err != nil {
+			return err
+		}
+		receivedAt := item.Entry.ReceivedAt
+		if _, err := insert.Exec(item.SourceIP, item.Protocol, item.RFCFormat, string(entryJSON), receivedAt); err != nil {
+			return err
+		}
+		if _, err := upsert.Exec(item.SourceIP, receivedAt, receivedAt, item.Entry.Hostname,
+			item.Entry.ListenerID, item.SuggestedDeviceType); err != nil {
+			return err
+		}
+		sources[item.SourceIP] = true
+	}
+
+	// Keep only the newest messages per sender
+	for source := range sources {
+		if _, err := tx.Exec(`DELETE FROM quarantine WHERE source_ip = ? AND id <= (
+			SELECT id FROM quarantine WHERE source_ip = ? ORDER BY id DESC LIMIT 1 OFFSET ?)`,
+			source, source, perSource); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PruneUnknownSenders removes the least recently seen senders and their
+// messages so that at most maxSenders remain
+func (d *Database) PruneUnknownSenders(maxSenders int) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stale := `SELECT source_ip FROM unknown_senders ORDER BY last_seen DESC LIMIT -1 OFFSET ?`
+	if _, err := tx.Exec("DELETE FROM quarantine WHERE source_ip IN ("+stale+")", maxSenders); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM unknown_senders WHERE source_ip IN ("+stale+")", maxSenders)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := result.RowsAffected()
+	return removed, tx.Commit()
+}
+
+// scanUnknownSender reads a row selected with unknownSenderColumns
+func scanUnknownSender(row rowScanner) (*UnknownSender, error) {
+	var sender UnknownSender
+	var hostname, listenerID, suggested sql.NullString
+	if err := row.Scan(&sender.SourceIP, &sender.MessageCount, &sender.FirstSeen, &sender.LastSeen,
+		&hostname, &listenerID, &suggested, &sender.Quarantined); err != nil {
+		return nil, err
+	}
+	sender.Hostname = hostname.String
+	sender.ListenerID = listenerID.String
+	sender.SuggestedDeviceType = suggested.String
+	return &sender, nil
+}
+
+// GetUnknownSenders returns unknown senders, most recently seen first
+func (d *Database) GetUnknownSenders(limit int) ([]*UnknownSender, error) {
+	rows, err := d.db.Query("SELECT "+unknownSenderColumns+" FROM unknown_senders s ORDER BY s.last_seen DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	senders := make([]*UnknownSender, 0)
+	for rows.Next() {
+		sender, err := scanUnknownSender(rows)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+	}
+	return senders, rows.Err()
+}
+
+// GetUnknownSender returns one sender, or sql.ErrNoRows
+func (d *Database) GetUnknownSender(sourceIP string) (*UnknownSender, error) {
+	return scanUnknownSender(d.db.QueryRow("SELECT "+unknownSenderColumns+" FROM unknown_senders s WHERE s.source_ip = ?", sourceIP))
+}
+
+// GetQuarantinedLogs returns the quarantined messages of a sender, oldest
+// first. limit <= 0 returns all of them.
+func (d *Database) GetQuarantinedLogs(sourceIP string, limit int) ([]QuarantinedLog, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := d.db.Query(`SELECT id, source_ip, protocol, rfc_format, entry FROM quarantine
+		WHERE source_ip = ? ORDER BY id LIMIT ?`, sourceIP, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]QuarantinedLog, 0)
+	for rows.Next() {
+		var item QuarantinedLog
+		var protocol, rfcFormat sql.NullString
+		var entryJSON string
+		if err := rows.Scan(&item.ID, &item.SourceIP, &protocol, &rfcFormat, &entryJSON); err != nil {
+			return nil, err
+		}
+		item.Protocol = protocol.String
+		item.RFCFormat = rfcFormat.String
+		item.Entry = &LogEntry{}
+		if err := json.Unmarshal([]byte(entryJSON), item.Entry); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteUnknownSender removes a sender and its quarantined messages
+func (d *Database) DeleteUnknownSender(sourceIP string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM quarantine WHERE source_ip = ?", sourceIP); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM unknown_senders WHERE source_ip = ?", sourceIP)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// Retention functions
+
+// retentionChunkSize is the number of rows removed per DELETE so ingest
+// isn't blocked behind one long write transaction
+const retentionChunkSize = 1000
+
+// deleteLogsInChunks deletes logs matching where (oldest first) in chunks
+// until no rows match or limit rows have been deleted (limit <= 0 = no limit).
+func (d *Database) deleteLogsInChunks(where string, args []interface{}, limit int64) (int64, error) {
+	var total int64
+	for limit <= 0 || total < limit {
+		chunk := int64(retentionChunkSize)
+		if limit > 0 && limit-total < chunk {
+			chunk = limit - total
+		}
+
+		query := "DELETE FROM logs WHERE id IN (SELECT id FROM logs WHERE " + where + " ORDER BY id LIMIT ?)"
+		result, err := d.db.Exec(query, append(append([]interface{}{}, args...), chunk)...)
+		if err != nil {
+			return total, err
+		}
+
+		n, _ := result.RowsAffected()
+		total += n
+		if n < chunk {
+			break
+		}
+
+		// Give the log writer a chance to take the write lock between chunks
+		time.Sleep(10 * time.Millisecond)
+	}
+	return total, nil
+}
+
+// retentionRuleCondition builds the SQL condition matching a retention rule
+func retentionRuleCondition(rule RetentionRule) (string, []interface{}) {
+	parts := []string{"1=1"}
+	args := []interface{}{}
+
+	if rule.DeviceType != "" {
+		parts = append(parts, "device_type = ?")
+		args = append(args, rule.DeviceType)
+	}
+	if len(rule.Severities) > 0 {
+		placeholders := make([]string, len(rule.Severities))
+		for i, severity := range rule.Severities {
+			placeholders[i] = "?"
+			args = append(args, severity)
+		}
+		parts = append(parts, "severity IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	return "(" + strings.Join(parts, " AND ") + ")", args
+}
+
+// PruneLogsByAge deletes logs received before cutoff. If rule is set only
+// logs matching it are deleted. Logs matching any rule in exclude are kept.
+// created_at (receive time) is used rather than the device-reported timestamp
+// so devices with a wrong clock can't cause premature deletion.
+func (d *Database) PruneLogsByAge(cutoff time.Time, rule *RetentionRule, exclude []RetentionRule) (int64, error) {
+	where := "created_at < ?"
+	args := []interface{}{cutoff.UTC().Format("2006-01-02 15:04:05")}
+
+	if rule != nil {
+		cond, condArgs := retentionRuleCondition(*rule)
+		where += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	for _, excluded := range exclude {
+		cond, condArgs := retentionRuleCondition(excluded)
+		where += " AND NOT " + cond
+		args = append(args, condArgs...)
+	}
+
+	return d.deleteLogsInChunks(where, args, 0)
+}
+
+// PruneLogsToCount deletes the oldest logs until at most maxRows remain
+func (d *Database) PruneLogsToCount(maxRows int) (int64, error) {
+	var count int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM logs").Scan(&count); err != nil {
+		return 0, err
+	}
+
+	excess := count - int64(maxRows)
+	if excess <= 0 {
+		return 0, nil
+	}
+	return d.deleteLogsInChunks("1=1", nil, excess)
+}
+
+// GetDatabaseSize returns the bytes used by the database, excluding free pages
+func (d *Database) GetDatabaseSize() (int64, error) {
+	var pageCount, freePages, pageSize int64
+	if err := d.db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := d.db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return 0, err
+	}
+	if err := d.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return (pageCount - freePages) * pageSize, nil
+}
+
+// PruneLogsToSize deletes the oldest logs until the database uses at most
+// maxBytes. The number of rows to delete is estimated from the average row size.
+func (d *Database) PruneLogsToSize(maxBytes int64) (int64, error) {
+	size, err := d.GetDatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	if size <= maxBytes {
+		return 0, nil
+	}
+
+	var count int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM logs").Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	bytesPerRow := size / count
+	if bytesPerRow <= 0 {
+		bytesPerRow = 1
+	}
+	toDelete := (size-maxBytes)/bytesPerRow + 1
+
+	return d.deleteLogsInChunks("1=1", nil, toDelete)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Device health tracking: last seen, message rate, severity mix and silence
+// detection for configured devices
+
+const (
+	defaultSilenceAfter = 15 * time.Minute
+	healthCheckInterval = 30 * time.Second
+	healthRateMinutes   = 5 // Minutes averaged for messages_per_minute
+
+	deviceStatusActive  = "active"
+	deviceStatusSilent  = "silent"
+	deviceStatusUnknown = "unknown" // No messages seen yet
+)
+
+// DeviceHealth is a device's recent activity. Counters cover messages
+// received since the server started.
+type DeviceHealth struct {
+	DeviceID            string           `json:"device_id"`
+	Status              string           `json:"status"` // "active", "silent" or "unknown"
+	LastSeen            *time.Time       `json:"last_seen,omitempty"`
+	SilentSince         *time.Time       `json:"silent_since,omitempty"`
+	SilenceAfterMinutes float64          `json:"silence_after_minutes"`
+	MessagesPerMinute   float64          `json:"messages_per_minute"` // Averaged over the last 5 minutes
+	TotalMessages       int64            `json:"total_messages"`
+	SeverityCounts      map[string]int64 `json:"severity_counts"`
+	ParseSuccessRate    float64          `json:"parse_success_rate"` // Share of messages parsed as RFC5424/RFC3164
+	ListenerID          string           `json:"listener_id,omitempty"`
+	Protocol            string           `json:"protocol,omitempty"`
+}
+
+// minuteCount is the number of messages received in one wall-clock minute
+type minuteCount struct {
+	minute int64
+	count  int64
+}
+
+// deviceActivity is the tracked state of one device
+type deviceActivity struct {
+	lastSeen    time.Time
+	silent      bool
+	silentSince time.Time
+	total       int64
+	parsed      int64
+	severities  [8]int64
+	minutes     [healthRateMinutes + 1]minuteCount
+	listenerID  string
+	protocol    string
+}
+
+// DeviceHealthTracker records activity per device ID and reports when
+// devices go silent or start sending again
+type DeviceHealthTracker struct {
+	mu       sync.Mutex
+	devices  map[string]*deviceActivity
+	onChange func(deviceID string, silent bool, lastSeen time.Time)
+}
+
+// NewDeviceHealthTracker creates a tracker. onChange is called, without the
+// tracker's lock held, when a device goes silent or comes back.
+func NewDeviceHealthTracker(onChange func(deviceID string, silent bool, lastSeen time.Time)) *DeviceHealthTracker {
+	return &DeviceHealthTracker{
+		devices:  make(map[string]*deviceActivity),
+		onChange: onChange,
+	}
+}
+
+// activity returns the state for deviceID, creating it if needed. Caller
+// must hold t.mu.
+func (t *DeviceHealthTracker) activity(deviceID string) *deviceActivity {
+	a, ok := t.devices[deviceID]
+	if !ok {
+		a = &deviceActivity{}
+		t.devices[deviceID] = a
+	}
+	return a
+}
+
+// Record counts a message saved for entry.DeviceID
+func (t *DeviceHealthTracker) Record(entry *LogEntry, protocol, rfcFormat string) {
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	t.mu.Lock()
+	a := t.activity(entry.DeviceID)
+	resumed := a.silent
+	a.silent = false
+	a.lastSeen = now
+	a.total++
+	if rfcFormat != "UNKNOWN" {
+		a.parsed++
+	}
+	if entry.Severity < 8 {
+		a.severities[entry.Severity]++
+	}
+	bucket := &a.minutes[minute%int64(len(a.minutes))]
+	if bucket.minute != minute {
+		*bucket = minuteCount{minute: minute}
+	}
+	bucket.count++
+	a.listenerID = entry.ListenerID
+	a.protocol = protocol
+	t.mu.Unlock()
+
+	if resumed && t.onChange != nil {
+		t.onChange(entry.DeviceID, false, now)
+	}
+}
+
+// Seed sets a device's last seen time from stored logs at startup. Devices
+// already quiet for longer than silenceAfter start out silent without an event.
+func (t *DeviceHealthTracker) Seed(deviceID string, lastSeen time.Time, silenceAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a := t.activity(deviceID)
+	if !a.lastSeen.IsZero() {
+		return
+	}
+	a.lastSeen = lastSeen
+	if time.Since(lastSeen) > silenceAfter {
+		a.silent = true
+		a.silentSince = lastSeen.Add(silenceAfter)
+	}
+}
+
+// Check marks devices that have sent nothing for longer than silenceAfter
+// returns for them as silent, and forgets devices no longer configured
+func (t *DeviceHealthTracker) Check(devices []DeviceConfig, silenceAfter func(DeviceConfig) time.Duration) {
+	now := time.Now()
+	type change struct {
+		deviceID string
+		lastSeen time.Time
+	}
+	var silenced []change
+
+	t.mu.Lock()
+	configured := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		configured[device.ID] = true
+		a, ok := t.devices[device.ID]
+		if !ok || a.silent || a.lastSeen.IsZero() {
+			continue
+		}
+		if now.Sub(a.lastSeen) > silenceAfter(device) {
+			a.silent = true
+			a.silentSince = now
+			silenced = append(silenced, change{device.ID, a.lastSeen})
+		}
+	}
+	for id := range t.devices {
+		if !configured[id] {
+			delete(t.devices, id)
+		}
+	}
+	t.mu.Unlock()
+
+	if t.onChange != nil {
+		for _, c := range silenced {
+			t.onChange(c.deviceID, true, c.lastSeen)
+		}
+	}
+}
+
+// Health returns the current health of deviceID
+func (t *DeviceHealthTracker) Health(deviceID string, silenceAfter time.Duration) DeviceHealth {
+	health := DeviceHealth{
+		DeviceID:            deviceID,
+		Status:              deviceStatusUnknown,
+		SilenceAfterMinutes: silenceAfter.Minutes(),
+		SeverityCounts:      make(map[string]int64),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.devices[deviceID]
+	if !ok || a.lastSeen.IsZero() {
+		return health
+	}
+
+	lastSeen := a.lastSeen
+	health.LastSeen = &lastSeen
+	health.Status = deviceStatusActive
+	if a.silent {
+		silentSince := a.silentSince
+		health.Status = deviceStatusSilent
+		health.SilentSince = &silentSince
+	}
+
+	current := time.Now().Unix() / 60
+	var recent int64
+	for _, bucket := range a.minutes {
+		if bucket.minute > current-healthRateMinutes && bucket.minute <= current {
+			recent += bucket.count
+		}
+	}
+	health.MessagesPerMinute = float64(recent) / healthRateMinutes
+
+	health.TotalMessages = a.total
+	if a.total > 0 {
+		health.ParseSuccessRate = float64(a.parsed) / float64(a.total)
+	}
+	for severity, count := range a.severities {
+		if count > 0 {
+			health.SeverityCounts[(&LogEntry{Severity: uint8(severity)}).GetSeverityName()] = count
+		}
+	}
+	health.ListenerID = a.listenerID
+	health.Protocol = a.protocol
+	return health
+}
+
+// silenceAfter returns how long device may send nothing before it's silent
+func (s *Server) silenceAfter(device DeviceConfig) time.Duration {
+	if device.SilenceAfterMinutes > 0 {
+		return time.Duration(device.SilenceAfterMinutes) * time.Minute
+	}
+	if s.config.DeviceHealth != nil && s.config.DeviceHealth.SilenceAfterMinutes > 0 {
+		return time.Duration(s.config.DeviceHealth.SilenceAfterMinutes) * time.Minute
+	}
+	return defaultSilenceAfter
+}
+
+// deviceHealth returns the health of a configured device
+func (s *Server) deviceHealth(device DeviceConfig) DeviceHealth {
+	return s.health.Health(device.ID, s.silenceAfter(device))
+}
+
+// monitorDeviceHealth seeds last seen times from stored logs, then
+// periodically checks configured devices for silence
+func (s *Server) monitorDeviceHealth() {
+	for _, device := range s.devices.Devices() {
+		lastSeen, err := s.db.GetDeviceLastSeen(device.ID)
+		if err != nil {
+			log.Printf("Device health: failed to load last seen time for %s: %v", device.ID, err)
+			continue
+		}
+		if !lastSeen.IsZero() {
+			s.health.Seed(device.ID, lastSeen, s.silenceAfter(device))
+		}
+	}
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.health.Check(s.devices.Devices(), s.silenceAfter)
+	}
+}
+
+// deviceHealthChanged saves an internal event log when a device goes silent
+// or comes back, so it shows up in the logs, live tail, forwarding and alerts
+func (s *Server) deviceHealthChanged(deviceID string, silent bool, lastSeen time.Time) {
+	device := s.devices.ByID(deviceID)
+	if device == nil {
+		return
+	}
+
+	now := time.Now()
+	entry := &LogEntry{
+		Timestamp:      now,
+		ReceivedAt:     now,
+		Facility:       5, // syslog (messages generated internally)
+		Hostname:       "qlog",
+		AppName:        "qlog",
+		DeviceID:       device.ID,
+		DeviceType:     "qlog",
+		EventCategory:  "device_health",
+		StructuredData: make(map[string]map[string]string),
+		ParsedFields: map[string]interface{}{
+			"device_id":   device.ID,
+			"device_name": device.Name,
+			"last_seen":   lastSeen.Format(time.RFC3339),
+		},
+	}
+	if silent {
+		entry.Severity = 4 // Warning
+		entry.EventType = "device_silent"
+		entry.Message = fmt.Sprintf("Device %s has sent no messages since %s", device.Name, lastSeen.Format(time.RFC3339))
+		entry.ParsedFields["silent_for_seconds"] = int64(now.Sub(lastSeen).Seconds())
+	} else {
+		entry.Severity = 5 // Notice
+		entry.EventType = "device_resumed"
+		entry.Message = fmt.Sprintf("Device %s is sending messages again", device.Name)
+	}
+	entry.Priority = entry.Facility*8 + entry.Severity
+	log.Printf("Device health: %s", entry.Message)
+
+	if err := s.writer.Enqueue(entry, "internal", "INTERNAL"); err != nil {
+		log.Printf("Device health: failed to save event: %v", err)
+	}
+}
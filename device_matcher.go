@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Device assignment: maps a message's sender address (exact IP or CIDR,
+// IPv4 or IPv6), syslog HOSTNAME or client certificate to a configured device
+
+// prefixNode is a node of a binary trie keyed by address bits
+type prefixNode struct {
+	children [2]*prefixNode
+	device   *DeviceConfig // Device whose prefix ends at this node, if any
+}
+
+// DeviceMatcher finds the device for a message. Lookups walk a prefix trie,
+// so their cost depends on the address length rather than the device count.
+type DeviceMatcher struct {
+	mu             sync.RWMutex
+	devices        []DeviceConfig
+	v4             *prefixNode
+	v6             *prefixNode
+	hostnames      map[string]*DeviceConfig
+	certIdentities map[string]*DeviceConfig
+	byID           map[string]*DeviceConfig
+}
+
+// NewDeviceMatcher builds a matcher for the configured devices
+func NewDeviceMatcher(devices []DeviceConfig) *DeviceMatcher {
+	m := &DeviceMatcher{}
+	m.SetDevices(devices)
+	return m
+}
+
+// SetDevices rebuilds the lookup structures. Only devices assigned to a
+// listener are matched. When several devices list the same address or
+// hostname the first one wins.
+func (m *DeviceMatcher) SetDevices(devices []DeviceConfig) {
+	v4, v6 := &prefixNode{}, &prefixNode{}
+	hostnames := make(map[string]*DeviceConfig)
+	certIdentities := make(map[string]*DeviceConfig)
+	byID := make(map[string]*DeviceConfig)
+
+	devices = append([]DeviceConfig(nil), devices...) // Copy so later config edits don't change a live matcher
+	for i := range devices {
+		device := &devices[i]
+		byID[device.ID] = device
+		if device.ListenerID == "" {
+			continue
+		}
+
+		for _, value := range device.IPAddresses {
+			prefix, err := parseDevicePrefix(value)
+			if err != nil {
+				continue // Rejected by validateDevice for API changes; skip bad hand edits
+			}
+			root := v4
+			if !prefix.Addr().Is4() {
+				root = v6
+			}
+			root.insert(prefix, device)
+		}
+		for _, hostname := range device.Hostnames {
+			key := strings.ToLower(strings.TrimSpace(hostname))
+			if _, exists := hostnames[key]; key != "" && !exists {
+				hostnames[key] = device
+			}
+		}
+		for _, identity := range device.CertIdentities {
+			key := strings.ToLower(strings.TrimSpace(identity))
+			if _, exists := certIdentities[key]; key != "" && !exists {
+				certIdentities[key] = device
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.devices = devices
+	m.v4, m.v6, m.hostnames, m.certIdentities, m.byID = v4, v6, hostnames, certIdentities, byID
+	m.mu.Unlock()
+}
+
+// Match returns the device for a sender, or nil. The most specific address
+// prefix wins; the syslog hostname is only used if no address matches.
+func (m *DeviceMatcher) Match(remoteAddr, hostname string) *DeviceConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if addr, ok := remoteIP(remoteAddr); ok {
+		root := m.v4
+		if !addr.Is4() {
+			root = m.v6
+		}
+		if device := root.lookup(addr); device != nil {
+			return device
+		}
+	}
+
+	if hostname != "" {
+		if device, ok := m.hostnames[strings.ToLower(hostname)]; ok {
+			return device
+		}
+	}
+	return nil
+}
+
+// MatchCertificate returns the device bound to a client certificate, or
+// nil, and the certificate name that matched. The subject is tried first,
+// then the common name and subject alternative names.
+func (m *DeviceMatcher) MatchCertificate(cert *x509.Certificate) (*DeviceConfig, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, identity := range certificateIdentities(cert) {
+		if device, ok := m.certIdentities[strings.ToLower(identity)]; ok {
+			return device, identity
+		}
+	}
+	return nil, ""
+}
+
+// ByID returns the configured device with the given ID, or nil. Used for
+// listeners whose messages all belong to one device.
+func (m *DeviceMatcher) ByID(id string) *DeviceConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.byID[id]
+}
+
+// Devices returns a copy of the configured devices, for use outside request
+// handlers where s.config may be replaced concurrently
+func (m *DeviceMatcher) Devices() []DeviceConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]DeviceConfig(nil), m.devices...)
+}
+
+// insert stores device at the node for prefix unless one is already there
+func (n *prefixNode) insert(prefix netip.Prefix, device *DeviceConfig) {
+	bytes := prefix.Addr().AsSlice()
+	node := n
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := (bytes[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	if node.device == nil {
+		node.device = device
+	}
+}
+
+// lookup returns the device with the longest prefix containing addr
+func (n *prefixNode) lookup(addr netip.Addr) *DeviceConfig {
+	bytes := addr.AsSlice()
+	best := n.device
+	node := n
+	for i := 0; i < len(bytes)*8; i++ {
+		node = node.children[(bytes[i/8]>>(7-i%8))&1]
+		if node == nil {
+			break
+		}
+		if node.device != nil {
+			best = node.device
+		}
+	}
+	return best
+}
+
+// parseDevicePrefix parses a device address entry: an IP or a CIDR prefix.
+// IPv4-mapped IPv6 addresses are treated as IPv4.
+func parseDevicePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// remoteIP extracts the IP from a remote address such as "192.0.2.1:514",
+// "[2001:db8::1]:514" or a bare IP
+func remoteIP(remoteAddr string) (netip.Addr, bool) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// validateDevice checks the match entries of a device from the API
+func validateDevice(device *DeviceConfig) error {
+	if len(device.IPAddresses) == 0 && len(device.Hostnames) == 0 && len(device.CertIdentities) == 0 {
+		return errors.New("at least one IP address, CIDR range, hostname or certificate identity is required")
+	}
+	for _, value := range device.IPAddresses {
+		if _, err := parseDevicePrefix(value); err != nil {
+			return fmt.Errorf("invalid IP address or CIDR range %q", value)
+		}
+	}
+	for _, hostname := range device.Hostnames {
+		if strings.TrimSpace(hostname) == "" {
+			return errors.New("hostnames must not be empty")
+		}
+	}
+	for _, identity := range device.CertIdentities {
+		if strings.TrimSpace(identity) == "" {
+			return errors.New("certificate identities must not be empty")
+		}
+	}
+	if err := validateRateLimit(device.RateLimit); err != nil {
+		return err
+	}
+	return validateMultiline(device.Multiline)
+}
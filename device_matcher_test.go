@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+// TestDeviceMatcherMatch matches senders by address prefix and hostname
+func TestDeviceMatcherMatch(t *testing.T) {
+	m := NewDeviceMatcher([]DeviceConfig{
+		{ID: "campus", ListenerID: "l1", IPAddresses: []string{"10.0.0.0/8"}},
+		{ID: "core", ListenerID: "l1", IPAddresses: []string{"10.1.0.0/16"}},
+		{ID: "firewall", ListenerID: "l1", IPAddresses: []string{"10.1.2.3"}, Hostnames: []string{"fw01"}},
+		{ID: "duplicate", ListenerID: "l1", IPAddresses: []string{"10.1.2.3", "192.0.2.0/24"}, Hostnames: []string{"FW01", "edge"}},
+		{ID: "mapped", ListenerID: "l1", IPAddresses: []string{"::ffff:198.51.100.0/120"}},
+		{ID: "v6", ListenerID: "l1", IPAddresses: []string{"2001:db8::/32"}},
+		{ID: "v6-host", ListenerID: "l1", IPAddresses: []string{"2001:db8::10"}},
+		{ID: "by-name", ListenerID: "l1", Hostnames: []string{"web01"}},
+		{ID: "unassigned", IPAddresses: []string{"172.16.0.0/12"}, Hostnames: []string{"lab"}},
+		{ID: "everything", ListenerID: "l1", IPAddresses: []string{"0.0.0.0/0"}},
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		hostname   string
+		want       string // Device ID, "" for no match
+	}{
+		{name: "covering prefix", remoteAddr: "10.200.0.1:514", want: "campus"},
+		{name: "longer prefix wins", remoteAddr: "10.1.9.9:514", want: "core"},
+		{name: "exact address wins", remoteAddr: "10.1.2.3:514", want: "firewall"},
+		{name: "first device wins on duplicates", remoteAddr: "10.1.2.3:40000", want: "firewall"},
+		{name: "duplicate's other prefix", remoteAddr: "192.0.2.77:514", want: "duplicate"},
+		{name: "IPv4-mapped sender", remoteAddr: "[::ffff:10.1.2.3]:514", want: "firewall"},
+		{name: "IPv4-mapped device entry", remoteAddr: "198.51.100.20:514", want: "mapped"},
+		{name: "IPv6 prefix", remoteAddr: "[2001:db8:1::1]:514", want: "v6"},
+		{name: "IPv6 host", remoteAddr: "[2001:db8::10]:514", want: "v6-host"},
+		{name: "IPv6 zone", remoteAddr: "[2001:db8::10%eth0]:514", want: "v6-host"},
+		{name: "bare IP", remoteAddr: "10.1.2.3", want: "firewall"},
+		{name: "address beats hostname", remoteAddr: "10.1.9.9:514", hostname: "web01", want: "core"},
+		{name: "default route beats hostname", remoteAddr: "203.0.113.5:514", hostname: "web01", want: "everything"},
+		{name: "hostname when no address matches", remoteAddr: "[2001:db9::1]:514", hostname: "web01", want: "by-name"},
+		{name: "hostname case-insensitive", remoteAddr: "[2001:db9::1]:514", hostname: "WEB01", want: "by-name"},
+		{name: "first device wins on duplicate hostnames", remoteAddr: "[2001:db9::1]:514", hostname: "fw01", want: "firewall"},
+		{name: "hostname without address", remoteAddr: "", hostname: "edge", want: "duplicate"},
+		{name: "unassigned device not matched", remoteAddr: "[2001:db9::1]:514", hostname: "lab", want: ""},
+		{name: "no match", remoteAddr: "[2001:db9::1]:514", hostname: "unknown", want: ""},
+		{name: "unparsable address", remoteAddr: "/dev/log", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if device := m.Match(tt.remoteAddr, tt.hostname); device != nil {
+			got = device.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: Match(%q, %q) = %q, want %q", tt.name, tt.remoteAddr, tt.hostname, got, tt.want)
+		}
+	}
+}
+
+// TestDeviceMatcherSetDevices replaces the devices and keeps its own copy
+func TestDeviceMatcherSetDevices(t *testing.T) {
+	devices := []DeviceConfig{{ID: "a", ListenerID: "l1", IPAddresses: []string{"10.0.0.1"}}}
+	m := NewDeviceMatcher(devices)
+	devices[0].ID = "changed"
+	if device := m.Match("10.0.0.1:514", ""); device == nil || device.ID != "a" {
+		t.Errorf("config edit changed the live matcher: got %v", device)
+	}
+	if device := m.ByID("a"); device == nil {
+		t.Errorf("ByID(a) = nil")
+	}
+
+	m.SetDevices([]DeviceConfig{{ID: "b", ListenerID: "l1", IPAddresses: []string{"10.0.0.0/24"}}})
+	if device := m.Match("10.0.0.1:514", ""); device == nil || device.ID != "b" {
+		t.Errorf("after SetDevices: got %v, want b", device)
+	}
+	if device := m.ByID("a"); device != nil {
+		t.Errorf("after SetDevices: ByID(a) = %v, want nil", device)
+	}
+	if got := m.Devices(); len(got) != 1 || got[0].ID != "b" {
+		t.Errorf("Devices() = %v, want b", got)
+	}
+}
+
+// TestParseDevicePrefix accepts IPs and CIDR ranges and unmaps IPv4-mapped ones
+func TestParseDevicePrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // "" for an error
+	}{
+		{"10.1.2.3", "10.1.2.3/32"},
+		{" 10.1.2.3 ", "10.1.2.3/32"},
+		{"10.1.2.3/16", "10.1.0.0/16"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"10.1.2.3/33", ""},
+		{"host.example.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		prefix, err := parseDevicePrefix(tt.value)
+		got := ""
+		if err == nil {
+			got = prefix.String()
+		}
+		if got != tt.want {
+			t.Errorf("parseDevicePrefix(%q) = %q (%v), want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Disk-backed FIFO queue used to buffer forwarded logs while a target is
+// unreachable.
+//
+// Records are appended to numbered segment files as
+// [4-byte length][8-byte enqueue time (unix nanos)][payload]. The read
+// position is saved to a cursor file so the backlog survives restarts;
+// delivery is at-least-once (records sent just before a crash may be resent).
+
+const (
+	diskQueueSegmentSize = 8 << 20
+	diskQueueHeaderSize  = 12
+	diskQueueMaxRecord   = 1 << 20
+	diskQueueCursorFile  = "cursor"
+	diskQueueCursorEvery = time.Second
+)
+
+// DiskQueueStats reports the backlog of a queue
+type DiskQueueStats struct {
+	Pending      int64   `json:"pending"`       // Records waiting to be sent
+	PendingBytes int64   `json:"pending_bytes"` // Size of waiting records
+	Dropped      int64   `json:"dropped"`       // Records rejected because the queue was full
+	LagSeconds   float64 `json:"lag_seconds"`   // Age of the oldest waiting record
+}
+
+type DiskQueue struct {
+	dir      string
+	maxBytes int64
+
+	mu           sync.Mutex
+	writeSeg     int64
+	writeFile    *os.File
+	writeOff     int64
+	readSeg      int64
+	readFile     *os.File
+	readOff      int64
+	peekSize     int64     // Size of the record returned by the last Peek
+	headTime     time.Time // Enqueue time of the record returned by the last Peek
+	pending      int64
+	pendingBytes int64
+	dropped      int64
+	cursorSaved  time.Time
+	notify       chan struct{}
+}
+
+// OpenDiskQueue opens or creates a queue in dir holding at most maxBytes of
+// pending records
+func OpenDiskQueue(dir string, maxBytes int64) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	q := &DiskQueue{
+		dir:      dir,
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}, 1),
+	}
+
+	segments, err := q.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	cursorSeg, cursorOff := q.loadCursor()
+	if len(segments) == 0 {
+		q.readSeg = max(cursorSeg, 1)
+		q.writeSeg = q.readSeg
+	} else {
+		q.readSeg = segments[0]
+		if cursorSeg >= segments[0] && cursorSeg <= segments[len(segments)-1] {
+			q.readSeg, q.readOff = cursorSeg, cursorOff
+		}
+		q.writeSeg = segments[len(segments)-1]
+	}
+
+	// Remove consumed segments and count the backlog
+	for _, seq := range segments {
+		if seq < q.readSeg {
+			os.Remove(q.segmentPath(seq))
+			continue
+		}
+
+		start := int64(0)
+		if seq == q.readSeg {
+			start = q.readOff
+		}
+		count, size, end, err := scanSegment(q.segmentPath(seq), start)
+		if err != nil {
+			return nil, err
+		}
+		q.pending += count
+		q.pendingBytes += size
+
+		// Drop a partially written record at the end of the write segment
+		if seq == q.writeSeg {
+			if err := os.Truncate(q.segmentPath(seq), end); err != nil {
+				return nil, err
+			}
+			q.writeOff = end
+		}
+	}
+
+	if q.readSeg == q.writeSeg && q.readOff > q.writeOff {
+		q.readOff = q.writeOff
+	}
+
+	q.writeFile, err = os.OpenFile(q.segmentPath(q.writeSeg), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.writeFile.Seek(q.writeOff, io.SeekStart); err != nil {
+		q.writeFile.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *DiskQueue) segmentPath(seq int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d.seg", seq))
+}
+
+// listSegments returns the sequence numbers of existing segments in order
+func (q *DiskQueue) listSegments() ([]int64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// scanSegment counts the complete records in a segment from start and
+// returns the offset just past the last complete record
+func scanSegment(path string, start int64) (count, size, end int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	end = start
+	header := make([]byte, diskQueueHeaderSize)
+	for {
+		if _, err := file.ReadAt(header, end); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		recordSize := diskQueueHeaderSize + length
+		if length > diskQueueMaxRecord || end+recordSize > info.Size() {
+			break
+		}
+		end += recordSize
+		size += recordSize
+		count++
+	}
+	return count, size, end, nil
+}
+
+func (q *DiskQueue) loadCursor() (int64, int64) {
+	data, err := os.ReadFile(filepath.Join(q.dir, diskQueueCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	var seq, off int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &off); err != nil {
+		return 0, 0
+	}
+	return seq, off
+}
+
+// saveCursor persists the read position. Caller must hold q.mu.
+func (q *DiskQueue) saveCursor() {
+	path := filepath.Join(q.dir, diskQueueCursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", q.readSeg, q.readOff)), 0600); err != nil {
+		log.Printf("Disk queue %s: failed to save cursor: %v", q.dir, err)
+		return
+	}
+	os.Rename(tmp, path)
+	q.cursorSaved = time.Now()
+}
+
+// Push appends records to the queue. Records that don't fit within the size
+// limit are dropped; the number accepted is returned.
+func (q *DiskQueue) Push(records [][]byte) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.writeFile == nil {
+		q.dropped += int64(len(records))
+		return 0
+	}
+
+	now := time.Now().UnixNano()
+	buf := make([]byte, 0, 4096)
+	accepted := 0
+	var acceptedBytes int64
+	for _, record := range records {
+		recordSize := int64(diskQueueHeaderSize + len(record))
+		if len(record) > diskQueueMaxRecord || q.pendingBytes+acceptedBytes+recordSize > q.maxBytes {
+			q.dropped++
+			continue
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(record)))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(now))
+		buf = append(buf, record...)
+		accepted++
+		acceptedBytes += recordSize
+	}
+	if accepted == 0 {
+		return 0
+	}
+
+	if q.writeOff > 0 && q.writeOff+int64(len(buf)) > diskQueueSegmentSize {
+		if err := q.rotate(); err != nil {
+			log.Printf("Disk queue %s: failed to start new segment: %v", q.dir, err)
+			q.dropped += int64(accepted)
+			return 0
+		}
+	}
+
+	n, err := q.writeFile.Write(buf)
+	if err != nil {
+		log.Printf("Disk queue %s: write failed: %v", q.dir, err)
+		// Cut off any partial write so the segment stays readable
+		q.writeFile.Truncate(q.writeOff)
+		q.writeFile.Seek(q.writeOff, io.SeekStart)
+		q.dropped += int64(accepted)
+		return 0
+	}
+	q.writeOff += int64(n)
+	q.pending += int64(accepted)
+	q.pendingBytes += acceptedBytes
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return accepted
+}
+
+// rotate starts a new write segment. Caller must hold q.mu.
+func (q *DiskQueue) rotate() error {
+	file, err := os.OpenFile(q.segmentPath(q.writeSeg+1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	q.writeFile.Close()
+	q.writeFile = file
+	q.writeSeg++
+	q.writeOff = 0
+	return nil
+}
+
+// SetMaxBytes changes the size limit. Records already queued are kept.
+func (q *DiskQueue) SetMaxBytes(maxBytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxBytes = maxBytes
+}
+
+// Peek returns the oldest record without removing it. ok is false if the
+// queue is empty.
+func (q *DiskQueue) Peek() (record []byte, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	header := make([]byte, diskQueueHeaderSize)
+	for {
+		if q.readSeg == q.writeSeg && q.readOff >= q.writeOff {
+			return nil, false, nil
+		}
+
+		if q.readFile == nil {
+			q.readFile, err = os.Open(q.segmentPath(q.readSeg))
+			if err != nil {
+				if os.IsNotExist(err) && q.readSeg < q.writeSeg {
+					q.nextReadSegment()
+					continue
+				}
+				return nil, false, err
+			}
+		}
+
+		if _, err := q.readFile.ReadAt(header, q.readOff); err != nil {
+			if q.readSeg < q.writeSeg {
+				// End of a finished segment
+				q.nextReadSegment()
+				continue
+			}
+			return nil, false, err
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > diskQueueMaxRecord {
+			if q.readSeg < q.writeSeg {
+				log.Printf("Disk queue %s: skipping corrupt segment %d", q.dir, q.readSeg)
+				q.nextReadSegment()
+				continue
+			}
+			log.Printf("Disk queue %s: corrupt record, discarding the rest of segment %d", q.dir, q.readSeg)
+			q.readOff = q.writeOff
+			q.pending, q.pendingBytes = 0, 0
+			return nil, false, nil
+		}
+		record = make([]byte, size)
+		if _, err := q.readFile.ReadAt(record, q.readOff+diskQueueHeaderSize); err != nil {
+			if q.readSeg < q.writeSeg {
+				log.Printf("Disk queue %s: skipping truncated record in segment %d", q.dir, q.readSeg)
+				q.nextReadSegment()
+				continue
+			}
+			return nil, false, err
+		}
+
+		q.peekSize = diskQueueHeaderSize + int64(size)
+		q.headTime = time.Unix(0, int64(binary.BigEndian.Uint64(header[4:])))
+		return record, true, nil
+	}
+}
+
+// nextReadSegment removes the finished read segment. Caller must hold q.mu.
+func (q *DiskQueue) nextReadSegment() {
+	if q.readFile != nil {
+		q.readFile.Close()
+		q.readFile = nil
+	}
+	os.Remove(q.segmentPath(q.readSeg))
+	q.readSeg++
+	q.readOff = 0
+	q.saveCursor()
+}
+
+// Ack removes the record returned by the last Peek
+func (q *DiskQueue) Ack() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.peekSize == 0 {
+		return
+	}
+	q.readOff += q.peekSize
+	q.pending = max(q.pending-1, 0)
+	q.pendingBytes = max(q.pendingBytes-q.peekSize, 0)
+	q.peekSize = 0
+
+	if time.Since(q.cursorSaved) >= diskQueueCursorEvery {
+		q.saveCursor()
+	}
+}
+
+// Wait returns a channel that receives after records are pushed
+func (q *DiskQueue) Wait() <-chan struct{} {
+	return q.notify
+}
+
+func (q *DiskQueue) Stats() DiskQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := DiskQueueStats{
+		Pending:      q.pending,
+		PendingBytes: q.pendingBytes,
+		Dropped:      q.dropped,
+	}
+	if q.pending > 0 && !q.headTime.IsZero() {
+		stats.LagSeconds = time.Since(q.headTime).Seconds()
+	}
+	return stats
+}
+
+// Close saves the read position and closes the segment files
+func (q *DiskQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.writeFile == nil {
+		return
+	}
+	q.saveCursor()
+	q.writeFile.Close()
+	q.writeFile = nil
+	if q.readFile != nil {
+		q.readFile.Close()
+		q.readFile = nil
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// drainQueue reads and acknowledges every pending record
+func drainQueue(t *testing.T, q *DiskQueue) []string {
+	t.Helper()
+	var records []string
+	for {
+		record, ok, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return records
+		}
+		records = append(records, string(record))
+		q.Ack()
+	}
+}
+
+func pushStrings(q *DiskQueue, records ...string) int {
+	data := make([][]byte, len(records))
+	for i, record := range records {
+		data[i] = []byte(record)
+	}
+	return q.Push(data)
+}
+
+// TestDiskQueueSegmentRollover starts a new segment once one is full and
+// removes segments that have been read
+func TestDiskQueueSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenDiskQueue(dir, 64<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	const count = 10 // Seven 1 MiB records fit in a segment
+	for i := 0; i < count; i++ {
+		if n := q.Push([][]byte{bytes.Repeat([]byte{byte('a' + i)}, diskQueueMaxRecord)}); n != 1 {
+			t.Fatalf("record %d was not accepted", i)
+		}
+	}
+	segments, err := q.listSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segments))
+	}
+	if stats := q.Stats(); stats.Pending != count {
+		t.Errorf("got %d pending records, want %d", stats.Pending, count)
+	}
+
+	records := drainQueue(t, q)
+	if len(records) != count {
+		t.Fatalf("read %d records, want %d", len(records), count)
+	}
+	for i, record := range records {
+		if len(record) != diskQueueMaxRecord || record[0] != byte('a'+i) {
+			t.Errorf("record %d is out of order or damaged", i)
+		}
+	}
+	if segments, _ := q.listSegments(); len(segments) != 1 {
+		t.Errorf("got %d segments after reading, want 1", len(segments))
+	}
+	if stats := q.Stats(); stats.Pending != 0 || stats.PendingBytes != 0 {
+		t.Errorf("got %+v after reading, want an empty queue", stats)
+	}
+}
+
+// TestDiskQueueCursorSurvivesReopen resumes after the last acknowledged record
+func TestDiskQueueCursorSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenDiskQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushStrings(q, "first", "second", "third")
+	if record, ok, _ := q.Peek(); !ok || string(record) != "first" {
+		t.Fatalf("got %q, want first", record)
+	}
+	q.Ack()
+	q.Close()
+
+	q, err = OpenDiskQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := q.Stats(); stats.Pending != 2 {
+		t.Errorf("got %d pending records after reopening, want 2", stats.Pending)
+	}
+	if got := drainQueue(t, q); strings.Join(got, ",") != "second,third" {
+		t.Errorf("got %q after reopening, want second and third", got)
+	}
+	q.Close()
+
+	q, err = OpenDiskQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if got := drainQueue(t, q); len(got) != 0 {
+		t.Errorf("got %q after reading everything, want nothing", got)
+	}
+	pushStrings(q, "fourth")
+	if got := drainQueue(t, q); strings.Join(got, ",") != "fourth" {
+		t.Errorf("got %q, want fourth", got)
+	}
+}
+
+// TestDiskQueueTruncatedFinalRecord drops a partially written last record
+// and keeps appending after the complete ones
+func TestDiskQueueTruncatedFinalRecord(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenDiskQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushStrings(q, "first", "second", "third")
+	q.Close()
+
+	segments, err := q.listSegments()
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("got segments %v (%v), want one", segments, err)
+	}
+	path := q.segmentPath(segments[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = OpenDiskQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if stats := q.Stats(); stats.Pending != 2 {
+		t.Errorf("got %d pending records, want 2", stats.Pending)
+	}
+	pushStrings(q, "fourth")
+	if got := drainQueue(t, q); strings.Join(got, ",") != "first,second,fourth" {
+		t.Errorf("got %q, want first, second and fourth", got)
+	}
+}
+
+// TestDiskQueueSizeLimit drops records that don't fit
+func TestDiskQueueSizeLimit(t *testing.T) {
+	q, err := OpenDiskQueue(t.TempDir(), 2*(diskQueueHeaderSize+5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if n := pushStrings(q, "one..", "two..", "three"); n != 2 {
+		t.Errorf("accepted %d records, want 2", n)
+	}
+	if stats := q.Stats(); stats.Dropped != 1 {
+		t.Errorf("got %d dropped records, want 1", stats.Dropped)
+	}
+	drainQueue(t, q)
+	if n := pushStrings(q, "four."); n != 1 {
+		t.Errorf("accepted %d records after reading, want 1", n)
+	}
+}
+
+// TestForwarderRemovesDeletedTargetQueue keeps the queue of a disabled target
+// and deletes that of a removed one
+func TestForwarderRemovesDeletedTargetQueue(t *testing.T) {
+	dir := t.TempDir()
+	target := ForwardTarget{ID: "target-1", Name: "test", Enabled: true, Address: "127.0.0.1:9", Protocol: "udp"}
+	f := NewForwarder(dir, []ForwardTarget{target})
+	defer f.Close()
+
+	queueDir := filepath.Join(dir, safeFileName(target.ID))
+	if _, err := os.Stat(queueDir); err != nil {
+		t.Fatalf("queue of a running target: %v", err)
+	}
+
+	target.Enabled = false
+	f.SetTargets([]ForwardTarget{target})
+	if _, err := os.Stat(queueDir); err != nil {
+		t.Errorf("queue of a disabled target: %v", err)
+	}
+
+	f.SetTargets(nil)
+	if _, err := os.Stat(queueDir); !os.IsNotExist(err) {
+		t.Errorf("queue of a removed target still exists (%v)", err)
+	}
+}
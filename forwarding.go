@@ -0,0 +1,467 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Syslog forwarding: relays saved logs to upstream receivers through a
+// disk-backed queue per target
+
+const (
+	defaultForwardQueueMB = 256
+	forwardDialTimeout    = 10 * time.Second
+	forwardWriteTimeout   = 10 * time.Second
+	forwardMaxBackoff     = 30 * time.Second
+)
+
+// ForwardStats reports the state of a forwarding target
+type ForwardStats struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Address     string     `json:"address"`
+	Connected   bool       `json:"connected"`
+	Sent        int64      `json:"sent"`
+	Errors      int64      `json:"errors"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	DiskQueueStats
+}
+
+// forwardTarget is a running target: its queue and sender goroutine
+type forwardTarget struct {
+	cfg   ForwardTarget
+	queue *DiskQueue
+	stop  chan struct{}
+	done  chan struct{}
+
+	connected atomic.Bool
+	sent      atomic.Int64
+	errors    atomic.Int64
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Forwarder manages the configured forwarding targets
+type Forwarder struct {
+	dir        string
+	setMu      sync.Mutex      // Serializes SetTargets and Close
+	configured map[string]bool // IDs of the configured targets, running or not
+	mu         sync.RWMutex
+	targets    []*forwardTarget
+}
+
+// NewForwarder starts the enabled targets. Queues are kept in dir.
+func NewForwarder(dir string, targets []ForwardTarget) *Forwarder {
+	f := &Forwarder{dir: dir}
+	f.SetTargets(targets)
+	return f
+}
+
+// SetTargets applies a new target list. Unchanged targets keep running;
+// changed targets restart their sender on the same queue; removed targets
+// have their queued logs deleted. Old senders are stopped after the new list
+// is in place, so a sender stuck dialing doesn't hold up Publish.
+func (f *Forwarder) SetTargets(targets []ForwardTarget) {
+	f.setMu.Lock()
+	defer f.setMu.Unlock()
+
+	f.mu.RLock()
+	running := make(map[string]*forwardTarget, len(f.targets))
+	for _, t := range f.targets {
+		running[t.cfg.ID] = t
+	}
+	f.mu.RUnlock()
+
+	configured := make(map[string]bool, len(targets))
+	next := make([]*forwardTarget, 0, len(targets))
+	var replaced, restarted, stopped []*forwardTarget
+	for _, cfg := range targets {
+		configured[cfg.ID] = true
+
+		if t, ok := running[cfg.ID]; ok {
+			delete(running, cfg.ID)
+			switch {
+			case cfg.Enabled && reflect.DeepEqual(t.cfg, cfg):
+				next = append(next, t)
+			case cfg.Enabled:
+				// Keep the queue open so logs published during the restart are kept
+				t.queue.SetMaxBytes(forwardQueueBytes(cfg))
+				nt := newForwardTarget(cfg, t.queue)
+				next = append(next, nt)
+				replaced = append(replaced, t)
+				restarted = append(restarted, nt)
+			default:
+				stopped = append(stopped, t)
+			}
+			continue
+		}
+		if !cfg.Enabled {
+			continue
+		}
+
+		t, err := f.startTarget(cfg)
+		if err != nil {
+			log.Printf("Forwarding: failed to start target %q: %v", cfg.Name, err)
+			continue
+		}
+		next = append(next, t)
+	}
+
+	f.mu.Lock()
+	f.targets = next
+	f.mu.Unlock()
+
+	for i, t := range replaced {
+		t.stopSender()
+		go restarted[i].run()
+	}
+	for _, t := range stopped {
+		t.close()
+	}
+	// Targets no longer in the config, including disabled ones that weren't running
+	for _, t := range running {
+		t.close()
+	}
+	for id := range f.configured {
+		if !configured[id] {
+			os.RemoveAll(f.queueDir(id))
+		}
+	}
+	f.configured = configured
+}
+
+func (f *Forwarder) queueDir(id string) string {
+	return filepath.Join(f.dir, safeFileName(id))
+}
+
+// forwardQueueBytes is the queue size limit of a target
+func forwardQueueBytes(cfg ForwardTarget) int64 {
+	maxMB := cfg.QueueMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultForwardQueueMB
+	}
+	return int64(maxMB) << 20
+}
+
+func newForwardTarget(cfg ForwardTarget, queue *DiskQueue) *forwardTarget {
+	return &forwardTarget{
+		cfg:   cfg,
+		queue: queue,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+func (f *Forwarder) startTarget(cfg ForwardTarget) (*forwardTarget, error) {
+	queue, err := OpenDiskQueue(f.queueDir(cfg.ID), forwardQueueBytes(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	t := newForwardTarget(cfg, queue)
+	if stats := queue.Stats(); stats.Pending > 0 {
+		log.Printf("Forwarding: target %q has %d queued logs from a previous run", cfg.Name, stats.Pending)
+	}
+	go t.run()
+	return t, nil
+}
+
+// Publish queues a committed batch for every target whose filter matches
+func (f *Forwarder) Publish(batch []PendingLog) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, t := range f.targets {
+		var records [][]byte
+		for _, pending := range batch {
+			if !t.cfg.LogMatch.Matches(pending.Entry) {
+				continue
+			}
+			records = append(records, []byte(formatForwardMessage(&t.cfg, pending.Entry)))
+		}
+		if len(records) > 0 {
+			t.queue.Push(records)
+		}
+	}
+}
+
+// Stats returns the state of each running target
+func (f *Forwarder) Stats() []ForwardStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	stats := make([]ForwardStats, 0, len(f.targets))
+	for _, t := range f.targets {
+		s := ForwardStats{
+			ID:             t.cfg.ID,
+			Name:           t.cfg.Name,
+			Address:        t.cfg.Address,
+			Connected:      t.connected.Load(),
+			Sent:           t.sent.Load(),
+			Errors:         t.errors.Load(),
+			DiskQueueStats: t.queue.Stats(),
+		}
+		t.mu.Lock()
+		if t.lastError != "" {
+			at := t.lastErrorAt
+			s.LastError = t.lastError
+			s.LastErrorAt = &at
+		}
+		t.mu.Unlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// Close stops all targets. Queued logs stay on disk for the next start.
+func (f *Forwarder) Close() {
+	f.setMu.Lock()
+	defer f.setMu.Unlock()
+
+	f.mu.Lock()
+	targets := f.targets
+	f.targets = nil
+	f.mu.Unlock()
+
+	for _, t := range targets {
+		t.close()
+	}
+}
+
+// stopSender stops the sender goroutine, leaving the queue open
+func (t *forwardTarget) stopSender() {
+	close(t.stop)
+	<-t.done
+}
+
+// close stops the sender and closes the queue
+func (t *forwardTarget) close() {
+	t.stopSender()
+	t.queue.Close()
+}
+
+// run sends queued logs in order, reconnecting with backoff on failure. A
+// log is only removed from the queue once it has been written.
+func (t *forwardTarget) run() {
+	defer close(t.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		t.connected.Store(false)
+	}()
+
+	backoff := time.Second
+	for {
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+
+		record, ok, err := t.queue.Peek()
+		if err != nil {
+			t.recordError(fmt.Errorf("queue read failed: %w", err))
+			if t.sleep(time.Second) {
+				return
+			}
+			continue
+		}
+		if !ok {
+			select {
+			case <-t.stop:
+				return
+			case <-t.queue.Wait():
+			}
+			continue
+		}
+
+		if conn == nil {
+			conn, err = t.dial()
+			if err != nil {
+				t.recordError(err)
+				if t.sleep(backoff) {
+					return
+				}
+				backoff = min(backoff*2, forwardMaxBackoff)
+				continue
+			}
+			t.connected.Store(true)
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout))
+		if _, err := conn.Write(t.frame(record)); err != nil {
+			t.recordError(err)
+			conn.Close()
+			conn = nil
+			t.connected.Store(false)
+			continue
+		}
+
+		t.queue.Ack()
+		t.sent.Add(1)
+		backoff = time.Second
+	}
+}
+
+// sleep waits for d, returning true if the target was stopped meanwhile
+func (t *forwardTarget) sleep(d time.Duration) bool {
+	select {
+	case <-t.stop:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t *forwardTarget) recordError(err error) {
+	t.errors.Add(1)
+
+	t.mu.Lock()
+	// Log only when the error changes to avoid flooding during an outage
+	if err.Error() != t.lastError {
+		log.Printf("Forwarding to %q (%s): %v", t.cfg.Name, t.cfg.Address, err)
+	}
+	t.lastError = err.Error()
+	t.lastErrorAt = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *forwardTarget) dial() (net.Conn, error) {
+	switch t.cfg.Protocol {
+	case "tcp":
+		return net.DialTimeout("tcp", t.cfg.Address, forwardDialTimeout)
+	case "tls":
+		tlsConfig, err := forwardTLSConfig(&t.cfg)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{Timeout: forwardDialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", t.cfg.Address, tlsConfig)
+	default:
+		return net.DialTimeout("udp", t.cfg.Address, forwardDialTimeout)
+	}
+}
+
+// frame applies the target's transport framing to a message
+func (t *forwardTarget) frame(record []byte) []byte {
+	if t.cfg.Protocol != "tcp" && t.cfg.Protocol != "tls" {
+		return record
+	}
+	if t.cfg.Framing == "non-transparent" {
+		// A newline inside the message would split it in two
+		msg := strings.ReplaceAll(string(record), "\n", " ")
+		return []byte(msg + "\n")
+	}
+	return append([]byte(strconv.Itoa(len(record))+" "), record...)
+}
+
+func forwardTLSConfig(cfg *ForwardTarget) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.SkipVerify,
+	}
+	if cfg.CaCertFile != "" {
+		caPEM, err := os.ReadFile(cfg.CaCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// formatForwardMessage renders a log in the target's output format
+func formatForwardMessage(cfg *ForwardTarget, entry *LogEntry) string {
+	priority := entry.Facility*8 + entry.Severity
+
+	hostname := entry.Hostname
+	if hostname == "" {
+		hostname = entry.RemoteAddr
+		if host, _, err := net.SplitHostPort(entry.RemoteAddr); err == nil {
+			hostname = host
+		}
+	}
+
+	switch cfg.Format {
+	case "rfc5424":
+		return formatRFC5424(priority, entry.Timestamp, hostname, entry.AppName, entry.ProcID, entry.MsgID,
+			formatStructuredData(entry.StructuredData), entry.Message)
+	case "rfc3164":
+		return formatRFC3164(priority, entry.Timestamp, hostname, entry.AppName, entry.ProcID, entry.Message)
+	default:
+		return entry.RawMessage
+	}
+}
+
+// validateForwardTarget checks a target from the API and fills in its ID
+func validateForwardTarget(target *ForwardTarget) error {
+	if target.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, _, err := net.SplitHostPort(target.Address); err != nil {
+		return fmt.Errorf("address must be host:port: %w", err)
+	}
+	switch target.Protocol {
+	case "", "udp", "tcp", "tls":
+	default:
+		return errors.New("protocol must be udp, tcp or tls")
+	}
+	switch target.Framing {
+	case "", "octet-counting", "non-transparent":
+	default:
+		return errors.New("framing must be octet-counting or non-transparent")
+	}
+	switch target.Format {
+	case "", "raw", "rfc5424", "rfc3164":
+	default:
+		return errors.New("format must be raw, rfc5424 or rfc3164")
+	}
+	if target.QueueMaxMB < 0 {
+		return errors.New("queue_max_mb must not be negative")
+	}
+	for _, sev := range target.Severities {
+		if sev < 0 || sev > 7 {
+			return fmt.Errorf("invalid severity %d (must be 0-7)", sev)
+		}
+	}
+	if target.ID == "" {
+		target.ID = fmt.Sprintf("forward-%d", time.Now().UnixNano())
+	}
+	return nil
+}
+
+// safeFileName maps an ID to a name that can't escape its directory
+func safeFileName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream framing for TCP/TLS syslog (RFC 6587)
+
+// maxFrameSize is the largest syslog frame accepted from a stream
+const maxFrameSize = 65535
+
+// errFrameTooLarge is returned for frames over the size limit
+var errFrameTooLarge = errors.New("frame too large")
+
+// Listener framing settings
+const (
+	framingNonTransparent = "non-transparent"
+	framingOctetCounting  = "octet-counting"
+	framingAuto           = "auto" // Detected per connection
+)
+
+// maxFrameLengthDigits is the number of digits in maxFrameSize
+const maxFrameLengthDigits = 5
+
+// validateListenerFraming checks a listener's framing setting
+func validateListenerFraming(listener *ListenerConfig) error {
+	switch listener.Framing {
+	case "", framingNonTransparent, framingOctetCounting:
+	case framingAuto:
+		if listener.Protocol != "TCP" && listener.Protocol != "UNIX" {
+			return errors.New("framing auto is only supported for TCP and UNIX stream listeners")
+		}
+	default:
+		return errors.New("framing must be non-transparent, octet-counting or auto")
+	}
+	return nil
+}
+
+// detectFraming peeks at the start of a stream and reports whether the
+// sender uses octet counting ("MSG-LEN SP", digits then a space) or
+// non-transparent framing (anything else, normally a message starting with
+// "<"). Leading line breaks and NULs are discarded.
+func detectFraming(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return "", err
+		}
+		if b[0] != '\n' && b[0] != '\r' && b[0] != 0 {
+			break
+		}
+		r.Discard(1)
+	}
+
+	// Peek one byte at a time so a short first message doesn't block detection
+	for n := 1; n <= maxFrameLengthDigits+1; n++ {
+		start, err := r.Peek(n)
+		if err == io.EOF {
+			// The stream ended early; let the non-transparent reader take what is there
+			return framingNonTransparent, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		b := start[n-1]
+		switch {
+		case b >= '0' && b <= '9' && !(n == 1 && b == '0'):
+			continue
+		case b == ' ' && n > 1:
+			return framingOctetCounting, nil
+		}
+		return framingNonTransparent, nil
+	}
+	return framingNonTransparent, nil
+}
+
+// scanSyslogLines is a bufio.SplitFunc for non-transparent framing that ends
+// messages at LF or NUL and drops the CR of a CRLF terminator
+func scanSyslogLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		return i + 1, bytes.TrimSuffix(data[:i], []byte("\r")), nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+	}
+	return 0, nil, nil
+}
+
+// readOctetCountedFrame reads one "MSG-LEN SP SYSLOG-MSG" frame and returns
+// the message bytes exactly as sent. Line breaks between frames are skipped.
+func readOctetCountedFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	length, digits := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && digits > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch {
+		case b >= '0' && b <= '9':
+			length = length*10 + int(b-'0')
+			digits++
+			if length > maxSize {
+				return nil, fmt.Errorf("%w: length exceeds %d bytes", errFrameTooLarge, maxSize)
+			}
+			continue
+		case b == ' ' && digits > 0:
+		case (b == '\n' || b == '\r') && digits == 0:
+			continue
+		default:
+			return nil, errors.New("invalid octet-counting frame header")
+		}
+		break
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
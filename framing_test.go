@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestDetectFraming tells octet-counted streams from LF-framed ones without
+// consuming the first message
+func TestDetectFraming(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		rest  string // What the reader holds afterwards, if different from input
+	}{
+		{name: "octet counting", input: "36 <34>1 - host app - - - message", want: framingOctetCounting},
+		{name: "single digit count", input: "5 hello", want: framingOctetCounting},
+		{name: "LF framing", input: "<34>Oct 11 22:14:15 host su: hi\n", want: framingNonTransparent},
+		{name: "leading line breaks and NULs", input: "\r\n\x0012 <34>1 - h a", want: framingOctetCounting, rest: "12 <34>1 - h a"},
+		{name: "leading zero", input: "0 <34>", want: framingNonTransparent},
+		{name: "digits without space", input: "12<34>", want: framingNonTransparent},
+		{name: "space before digits", input: " 12 <34>", want: framingNonTransparent},
+		{name: "count longer than the max size", input: "999999 <34>", want: framingNonTransparent},
+		{name: "count over the max size", input: "99999 <34>", want: framingOctetCounting},
+		{name: "stream ends during the count", input: "12", want: framingNonTransparent},
+		{name: "plain text", input: "hello world\n", want: framingNonTransparent},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		got, err := detectFraming(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		want := tt.input
+		if tt.rest != "" {
+			want = tt.rest
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != want {
+			t.Errorf("%s: reader holds %q, want %q", tt.name, rest, want)
+		}
+	}
+
+	if _, err := detectFraming(bufio.NewReader(strings.NewReader("\n\n"))); err != io.EOF {
+		t.Errorf("empty stream: got %v, want io.EOF", err)
+	}
+}
+
+// TestReadOctetCountedFrame reads frames and rejects bad or oversized ones
+func TestReadOctetCountedFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error // Returned after the wanted frames; io.EOF at a clean end
+		errText string
+	}{
+		{name: "one frame", input: "5 hello", want: []string{"hello"}, wantErr: io.EOF},
+		{name: "frames with line breaks between", input: "5 hello\r\n5 world\n", want: []string{"hello", "world"}, wantErr: io.EOF},
+		{name: "frame containing LF", input: "11 line1\nline2", want: []string{"line1\nline2"}, wantErr: io.EOF},
+		{name: "empty frame", input: "0 5 hello", want: []string{"", "hello"}, wantErr: io.EOF},
+		{name: "count at the max size", input: strconv.Itoa(maxFrameSize) + " " + strings.Repeat("x", maxFrameSize), want: []string{strings.Repeat("x", maxFrameSize)}, wantErr: io.EOF},
+		{name: "count over the max size", input: strconv.Itoa(maxFrameSize+1) + " x", wantErr: errFrameTooLarge},
+		{name: "very long count", input: "12345678901234567890 x", wantErr: errFrameTooLarge},
+		{name: "truncated count", input: "12", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated message", input: "10 short", wantErr: io.ErrUnexpectedEOF},
+		{name: "LF framed message", input: "<34>hello\n", errText: "invalid octet-counting frame header"},
+		{name: "count without space", input: "5\nhello", errText: "invalid octet-counting frame header"},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		var got []string
+		var err error
+		for {
+			var frame []byte
+			if frame, err = readOctetCountedFrame(r, maxFrameSize); err != nil {
+				break
+			}
+			got = append(got, string(frame))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("%s: got frames %q, want %q", tt.name, got, tt.want)
+		}
+		switch {
+		case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		case tt.errText != "" && (err == nil || !strings.Contains(err.Error(), tt.errText)):
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.errText)
+		}
+	}
+}
+
+// TestDetectedFramingReadsFrames reads a stream with the framing detected for it
+func TestDetectedFramingReadsFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{name: "octet counting", input: "5 hello6 world!", want: []string{"hello", "world!"}},
+		{name: "LF framing", input: "<1>a\r\n<2>b\x00<3>c", want: []string{"<1>a", "<2>b", "<3>c"}},
+		{name: "octet count over the max size", input: "70000 <34>", wantErr: errFrameTooLarge},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		framing, err := detectFraming(r)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var got []string
+		if framing == framingOctetCounting {
+			for {
+				frame, err := readOctetCountedFrame(r, maxFrameSize)
+				if err != nil {
+					if tt.wantErr != nil && !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != io.EOF {
+						t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+					}
+					break
+				}
+				got = append(got, string(frame))
+			}
+		} else {
+			scanner := bufio.NewScanner(r)
+			scanner.Split(scanSyslogLines)
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s (%s): got frames %q, want %q", tt.name, framing, got, tt.want)
+		}
+	}
+}
@@ -4,9 +4,8 @@ go 1.24.0
 
 toolchain go1.24.11
 
-require github.com/leodido/go-syslog/v4 v4.3.0
-
 require (
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
-	golang.org/x/crypto v0.46.0 // indirect
+	github.com/leodido/go-syslog/v4 v4.3.0
+	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/crypto v0.46.0
 )
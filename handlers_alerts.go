@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Alert-related API handlers
+
+func (s *Server) handleAlertsAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing alerts
+	if isSharedViewRequest(r) {
+		http.Error(w, "alert access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			limit = l
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			offset = o
+		}
+	}
+	ruleID := r.URL.Query().Get("rule_id")
+	unacknowledged := r.URL.Query().Get("unacknowledged") == "true"
+
+	alerts, err := s.db.GetAlerts(limit, offset, ruleID, unacknowledged)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// handleAlertAPI handles /api/alerts/{id} (GET, DELETE) and
+// /api/alerts/{id}/ack (POST)
+func (s *Server) handleAlertAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing alerts
+	if isSharedViewRequest(r) {
+		http.Error(w, "alert access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "invalid alert ID", http.StatusBadRequest)
+		return
+	}
+
+	alertID, err := strconv.ParseInt(pathParts[2], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid alert ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(pathParts) == 4 && pathParts[3] == "ack" {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.db.AcknowledgeAlert(alertID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "alert not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "acknowledged"})
+		return
+	}
+
+	if r.Method == "GET" {
+		alert, err := s.db.GetAlertByID(alertID)
+		if err != nil {
+			http.Error(w, "alert not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(alert)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if err := s.db.DeleteAlert(alertID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleAlertRulesAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing alert rules
+	if isSharedViewRequest(r) {
+		http.Error(w, "alert rule access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		if s.config.AlertRules == nil {
+			s.config.AlertRules = []AlertRule{}
+		}
+		json.NewEncoder(w).Encode(s.config.AlertRules)
+		return
+	}
+
+	if r.Method == "POST" {
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateAlertRule(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, existing := range s.config.AlertRules {
+			if existing.ID == rule.ID {
+				http.Error(w, "alert rule ID already exists", http.StatusConflict)
+				return
+			}
+		}
+
+		s.config.AlertRules = append(s.config.AlertRules, rule)
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.alerts.SetRules(s.config.AlertRules)
+
+		json.NewEncoder(w).Encode(rule)
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleAlertRuleAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing alert rules
+	if isSharedViewRequest(r) {
+		http.Error(w, "alert rule access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "invalid alert rule ID", http.StatusBadRequest)
+		return
+	}
+
+	ruleID := pathParts[2]
+
+	if r.Method == "PUT" {
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = ruleID
+		if err := validateAlertRule(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for i := range s.config.AlertRules {
+			if s.config.AlertRules[i].ID == ruleID {
+				s.config.AlertRules[i] = rule
+				if err := SaveConfig("config.json", s.config); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				s.alerts.SetRules(s.config.AlertRules)
+
+				json.NewEncoder(w).Encode(rule)
+				return
+			}
+		}
+
+		http.Error(w, "alert rule not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		newRules := []AlertRule{}
+		found := false
+		for _, rule := range s.config.AlertRules {
+			if rule.ID == ruleID {
+				found = true
+				continue
+			}
+			newRules = append(newRules, rule)
+		}
+
+		if !found {
+			http.Error(w, "alert rule not found", http.StatusNotFound)
+			return
+		}
+
+		s.config.AlertRules = newRules
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.alerts.SetRules(s.config.AlertRules)
+
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
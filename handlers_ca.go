@@ -0,0 +1,435 @@
+package main
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// handleCAAPI reports the internal CA (GET) or creates it (POST)
+func (s *Server) handleCAAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing the CA
+	if isSharedViewRequest(r) {
+		http.Error(w, "CA access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		response := map[string]interface{}{"created": false}
+		if ca := s.internalCA(); ca != nil {
+			response["created"] = true
+			response["ca"] = ca.Info()
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if r.Method == "POST" {
+		var request struct {
+			CommonName string `json:"common_name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.CommonName == "" {
+			request.CommonName = "qLog Internal CA"
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.ca != nil {
+			http.Error(w, "the internal CA already exists", http.StatusConflict)
+			return
+		}
+		ca, err := createInternalCA(internalCADir, request.CommonName, s.db)
+		if err != nil {
+			http.Error(w, "failed to create CA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.ca = ca
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"created": true, "ca": ca.Info()})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// handleCAResourceAPI serves the CA certificate and CRL, lists issued
+// certificates and revokes them:
+//
+//	GET  /api/ca/certificate
+//	GET  /api/ca/crl
+//	GET  /api/ca/issued[?device_id=]
+//	POST /api/ca/issued/{serial}/revoke
+func (s *Server) handleCAResourceAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing the CA
+	if isSharedViewRequest(r) {
+		http.Error(w, "CA access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	ca := s.internalCA()
+	if ca == nil {
+		http.Error(w, errNoInternalCA.Error(), http.StatusNotFound)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(pathParts) == 3 && pathParts[2] == "certificate":
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeDownload(w, "application/x-pem-file", "qlog-ca.pem", encodeCertificate(ca.Certificate()))
+
+	case len(pathParts) == 3 && pathParts[2] == "crl":
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		crl, err := os.ReadFile(ca.CRLFile())
+		if err != nil {
+			http.Error(w, "failed to read CRL: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDownload(w, "application/x-pem-file", "qlog-ca.crl", crl)
+
+	case len(pathParts) == 3 && pathParts[2] == "issued":
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		certs, err := s.db.GetIssuedCertificates(r.URL.Query().Get("device_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(certs)
+
+	case len(pathParts) == 5 && pathParts[2] == "issued" && pathParts[4] == "revoke":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serial := strings.ToLower(pathParts[3])
+		if err := ca.Revoke(serial); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "certificate not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadCRLListeners(ca)
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "serial": serial})
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// handleListenerCertificateIssue issues a server certificate for a TLS or
+// RELP listener from the internal CA and switches the listener to it
+// (POST /api/listeners/{id}/certificate). The certificate is valid for the
+// server's host name and IP addresses and any extra names in the request.
+// Unless client_ca is false, the listener also verifies client certificates
+// against the CA and its CRL, if it has no other CA configured.
+func (s *Server) handleListenerCertificateIssue(w http.ResponseWriter, r *http.Request, listenerID string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ca := s.internalCA()
+	if ca == nil {
+		http.Error(w, errNoInternalCA.Error(), http.StatusConflict)
+		return
+	}
+
+	var request struct {
+		DNSNames     []string `json:"dns_names"`
+		IPAddresses  []string `json:"ip_addresses"`
+		ValidityDays int      `json:"validity_days"`
+		ClientCA     *bool    `json:"client_ca"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var listener *ListenerConfig
+	for i := range s.config.Listeners {
+		if s.config.Listeners[i].ID == listenerID {
+			listener = &s.config.Listeners[i]
+			break
+		}
+	}
+	if listener == nil {
+		http.Error(w, "listener not found", http.StatusNotFound)
+		return
+	}
+	if listener.Protocol != "TLS" && listener.Protocol != "RELP" {
+		http.Error(w, "server certificates can only be issued for tls and relp listeners", http.StatusBadRequest)
+		return
+	}
+
+	// Names senders may use to reach the server
+	dnsNames := []string{}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	dnsNames = append(dnsNames, "localhost")
+	ipAddresses := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	for _, ip := range serverIPAddresses() {
+		ipAddresses = append(ipAddresses, net.ParseIP(ip))
+	}
+	for _, name := range request.DNSNames {
+		if name = strings.TrimSpace(name); name != "" {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	for _, value := range request.IPAddresses {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			http.Error(w, fmt.Sprintf("invalid IP address %q", value), http.StatusBadRequest)
+			return
+		}
+		ipAddresses = append(ipAddresses, ip)
+	}
+
+	cert, key, err := ca.IssueServerCertificate(*listener, uniqueStrings(dnsNames), ipAddresses, request.ValidityDays)
+	if err != nil {
+		http.Error(w, "failed to issue certificate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Saved next to uploaded certificates; the chain lets senders that only
+	// trust the CA verify the server
+	base := filepath.Join("certs", fmt.Sprintf("server-%s-%d", listenerID, time.Now().UnixNano()))
+	certFile, keyFile := base+".pem", base+".key"
+	if err := os.MkdirAll("certs", 0755); err != nil {
+		http.Error(w, "failed to create certs directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		http.Error(w, "failed to save key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	chain := append(encodeCertificate(cert), encodeCertificate(ca.Certificate())...)
+	if err := os.WriteFile(certFile, chain, 0644); err != nil {
+		http.Error(w, "failed to save certificate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	listener.CertFile = certFile
+	listener.KeyFile = keyFile
+	if (request.ClientCA == nil || *request.ClientCA) && (listener.CaCertFile == "" || listener.CaCertFile == ca.CertFile()) {
+		listener.CaCertFile = ca.CertFile()
+		listener.CRLFile = ca.CRLFile()
+	}
+	if err := SaveConfig("config.json", s.config); err != nil {
+		http.Error(w, "failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":       "success",
+		"serial":       cert.SerialNumber.Text(16),
+		"subject":      cert.Subject.String(),
+		"dns_names":    cert.DNSNames,
+		"ip_addresses": cert.IPAddresses,
+		"not_after":    cert.NotAfter,
+		"cert_file":    listener.CertFile,
+		"key_file":     listener.KeyFile,
+		"ca_cert_file": listener.CaCertFile,
+		"crl_file":     listener.CRLFile,
+	}
+
+	// A running listener switches certificates without dropping connections;
+	// an enabled listener that could not start without one is started now
+	if certs := s.listenerCertStore(listenerID); certs != nil {
+		certs.SetFiles(*listener)
+		response["certificate"] = certs.Info()
+	} else if listener.Enabled {
+		if err := s.startListener(*listener); err != nil {
+			response["start_error"] = err.Error()
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleDeviceCertificatesAPI lists a device's certificates from the
+// internal CA (GET) or issues a new one and returns it with its private key
+// (POST), as PEM or as a password protected PKCS#12 file. The key is not
+// kept, so a lost file means issuing a new certificate.
+func (s *Server) handleDeviceCertificatesAPI(w http.ResponseWriter, r *http.Request, deviceID string) {
+	device := s.findDevice(deviceID)
+	if device == nil || device.ID != deviceID {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "GET" {
+		certs, err := s.db.GetIssuedCertificates(deviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(certs)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ca := s.internalCA()
+	if ca == nil {
+		http.Error(w, errNoInternalCA.Error(), http.StatusConflict)
+		return
+	}
+
+	var request struct {
+		Format       string `json:"format"` // "pem" (default) or "pkcs12"
+		Password     string `json:"password"`
+		ValidityDays int    `json:"validity_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch request.Format {
+	case "", "pem":
+	case "pkcs12", "p12":
+		if request.Password == "" {
+			http.Error(w, "a password is required for pkcs12", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "format must be pem or pkcs12", http.StatusBadRequest)
+		return
+	}
+
+	cert, key, err := ca.IssueClientCertificate(*device, request.ValidityDays)
+	if err != nil {
+		http.Error(w, "failed to issue certificate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Bind the certificate to the device
+	uri := deviceCertificateURI(device.ID)
+	bound := false
+	for _, identity := range device.CertIdentities {
+		if strings.EqualFold(identity, uri) {
+			bound = true
+			break
+		}
+	}
+	if !bound {
+		device.CertIdentities = append(device.CertIdentities, uri)
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, "failed to save config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.devices.SetDevices(s.config.Devices)
+	}
+
+	if request.Format == "pkcs12" || request.Format == "p12" {
+		p12, err := encodePKCS12(key, cert, []*x509.Certificate{ca.Certificate()}, device.Name, request.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDownload(w, "application/x-pkcs12", "qlog-"+device.ID+".p12", p12)
+		return
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundle := append(encodeCertificate(cert), encodeCertificate(ca.Certificate())...)
+	writeDownload(w, "application/x-pem-file", "qlog-"+device.ID+".pem", append(bundle, keyPEM...))
+}
+
+// revokeDeviceCertificates revokes the certificates issued to a removed
+// device, so they can't be used to send as another device later
+func (s *Server) revokeDeviceCertificates(deviceID string) error {
+	ca := s.internalCA()
+	if ca == nil {
+		return nil
+	}
+	certs, err := s.db.GetIssuedCertificates(deviceID)
+	if err != nil {
+		return err
+	}
+	revoked := 0
+	for _, cert := range certs {
+		if cert.RevokedAt != nil || cert.Kind != "client" {
+			continue
+		}
+		if err := ca.Revoke(cert.Serial); err != nil {
+			return err
+		}
+		revoked++
+	}
+	if revoked > 0 {
+		s.reloadCRLListeners(ca)
+	}
+	return nil
+}
+
+// writeDownload sends data as a file attachment
+func writeDownload(w http.ResponseWriter, contentType, filename string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// uniqueStrings returns values without case-insensitive duplicates, in order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	unique := []string{}
+	for _, value := range values {
+		if key := strings.ToLower(value); !seen[key] {
+			seen[key] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
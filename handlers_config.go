@@ -126,6 +126,86 @@ func (s *Server) handleConfigLimitAPI(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 }
 
+func (s *Server) handleRetentionAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing config
+	if isSharedViewRequest(r) {
+		http.Error(w, "config access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		retention := s.config.Retention
+		if retention == nil {
+			retention = &RetentionConfig{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"limit":     s.config.Database.Limit,
+			"retention": retention,
+			"last_run":  s.retention.Last(),
+		})
+		return
+	}
+
+	if r.Method == "POST" {
+		var retention RetentionConfig
+		if err := json.NewDecoder(r.Body).Decode(&retention); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if retention.MaxAgeDays < 0 || retention.MaxSizeMB < 0 || retention.IntervalMinutes < 0 {
+			http.Error(w, "retention values must not be negative", http.StatusBadRequest)
+			return
+		}
+		for _, rule := range retention.Rules {
+			if rule.MaxAgeDays < 0 {
+				http.Error(w, "retention values must not be negative", http.StatusBadRequest)
+				return
+			}
+			for _, severity := range rule.Severities {
+				if severity < 0 || severity > 7 {
+					http.Error(w, "severity must be between 0 and 7", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		s.config.Retention = &retention
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(s.config.Retention)
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleRetentionRunAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from pruning logs
+	if isSharedViewRequest(r) {
+		http.Error(w, "retention not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.runRetention())
+}
+
 func (s *Server) handleConfigResetAPI(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
@@ -153,6 +233,10 @@ func (s *Server) handleConfigResetAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.devices.SetDevices(s.config.Devices)
+	s.alerts.SetRules(s.config.AlertRules)
+	s.notifier.SetChannels(s.config.Notifications)
+	s.forwarder.SetTargets(s.config.ForwardTargets)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
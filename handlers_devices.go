@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 
@@ -55,6 +56,12 @@ func (s *Server) handleDeviceTypesAPI(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(deviceTypes)
 }
 
+// deviceWithHealth is a device as listed by /api/devices
+type deviceWithHealth struct {
+	DeviceConfig
+	Health DeviceHealth `json:"health"`
+}
+
 func (s *Server) handleDevicesAPI(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
@@ -67,10 +74,12 @@ func (s *Server) handleDevicesAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "GET" {
-		if s.config.Devices == nil {
-			s.config.Devices = []DeviceConfig{}
+		// Each device is listed with its current health
+		devices := make([]deviceWithHealth, 0, len(s.config.Devices))
+		for _, device := range s.config.Devices {
+			devices = append(devices, deviceWithHealth{DeviceConfig: device, Health: s.deviceHealth(device)})
 		}
-		json.NewEncoder(w).Encode(s.config.Devices)
+		json.NewEncoder(w).Encode(devices)
 		return
 	}
 
@@ -81,6 +90,11 @@ func (s *Server) handleDevicesAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err := validateDevice(&device); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Initialize devices slice if nil
 		if s.config.Devices == nil {
 			s.config.Devices = []DeviceConfig{}
@@ -94,6 +108,7 @@ func (s *Server) handleDevicesAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.devices.SetDevices(s.config.Devices)
 
 		// If device has a listener and it's enabled, restart listeners
 		if device.ListenerID != "" {
@@ -126,6 +141,27 @@ func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 
 	deviceID := pathParts[2]
 
+	// GET /api/devices/{id}/health
+	if len(pathParts) == 4 && pathParts[3] == "health" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		device := s.findDevice(deviceID)
+		if device == nil || device.ID != deviceID {
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.deviceHealth(*device))
+		return
+	}
+
+	// GET/POST /api/devices/{id}/certificates
+	if len(pathParts) == 4 && pathParts[3] == "certificates" {
+		s.handleDeviceCertificatesAPI(w, r, deviceID)
+		return
+	}
+
 	if r.Method == "PUT" {
 		var device DeviceConfig
 		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
@@ -133,6 +169,13 @@ func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Stored logs reference the device by ID, so it can't change
+		device.ID = deviceID
+		if err := validateDevice(&device); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Find and update device
 		if s.config.Devices != nil {
 			for i := range s.config.Devices {
@@ -142,6 +185,7 @@ func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 						http.Error(w, err.Error(), http.StatusInternalServerError)
 						return
 					}
+					s.devices.SetDevices(s.config.Devices)
 
 					// Restart listeners if device has a listener
 					if device.ListenerID != "" {
@@ -187,6 +231,11 @@ func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.devices.SetDevices(s.config.Devices)
+
+		if err := s.revokeDeviceCertificates(deviceID); err != nil {
+			log.Printf("Failed to revoke certificates of deleted device %s: %v", deviceID, err)
+		}
 
 		// Restart listeners
 		go s.startListeners()
@@ -426,3 +475,28 @@ func (s *Server) handleModuleMetadataAPI(w http.ResponseWriter, r *http.Request)
 	allMetadata := registry.GetAllModuleMetadata()
 	json.NewEncoder(w).Encode(allMetadata)
 }
+
+// findDevice returns the configured device with the given ID or, failing
+// that, the given name (case-insensitive)
+func (s *Server) findDevice(ref string) *DeviceConfig {
+	for i := range s.config.Devices {
+		if s.config.Devices[i].ID == ref {
+			return &s.config.Devices[i]
+		}
+	}
+	for i := range s.config.Devices {
+		if strings.EqualFold(s.config.Devices[i].Name, ref) {
+			return &s.config.Devices[i]
+		}
+	}
+	return nil
+}
+
+// deviceNames maps configured device IDs to their current names
+func (s *Server) deviceNames() map[string]string {
+	names := make(map[string]string, len(s.config.Devices))
+	for _, device := range s.config.Devices {
+		names[device.ID] = device.Name
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Forwarding target API handlers
+
+func (s *Server) handleForwardTargetsAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing forwarding targets
+	if isSharedViewRequest(r) {
+		http.Error(w, "forwarding access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		if s.config.ForwardTargets == nil {
+			s.config.ForwardTargets = []ForwardTarget{}
+		}
+		json.NewEncoder(w).Encode(s.config.ForwardTargets)
+		return
+	}
+
+	if r.Method == "POST" {
+		var target ForwardTarget
+		if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateForwardTarget(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, existing := range s.config.ForwardTargets {
+			if existing.ID == target.ID {
+				http.Error(w, "forwarding target ID already exists", http.StatusConflict)
+				return
+			}
+		}
+
+		s.config.ForwardTargets = append(s.config.ForwardTargets, target)
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.forwarder.SetTargets(s.config.ForwardTargets)
+
+		json.NewEncoder(w).Encode(target)
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleForwardTargetAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing forwarding targets
+	if isSharedViewRequest(r) {
+		http.Error(w, "forwarding access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "invalid forwarding target ID", http.StatusBadRequest)
+		return
+	}
+
+	targetID := pathParts[2]
+
+	if r.Method == "PUT" {
+		var target ForwardTarget
+		if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target.ID = targetID
+		if err := validateForwardTarget(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for i := range s.config.ForwardTargets {
+			if s.config.ForwardTargets[i].ID == targetID {
+				s.config.ForwardTargets[i] = target
+				if err := SaveConfig("config.json", s.config); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				s.forwarder.SetTargets(s.config.ForwardTargets)
+
+				json.NewEncoder(w).Encode(target)
+				return
+			}
+		}
+
+		http.Error(w, "forwarding target not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		newTargets := []ForwardTarget{}
+		found := false
+		for _, target := range s.config.ForwardTargets {
+			if target.ID == targetID {
+				found = true
+				continue
+			}
+			newTargets = append(newTargets, target)
+		}
+
+		if !found {
+			http.Error(w, "forwarding target not found", http.StatusNotFound)
+			return
+		}
+
+		// Deleting a target also discards its queued logs
+		s.config.ForwardTargets = newTargets
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.forwarder.SetTargets(s.config.ForwardTargets)
+
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
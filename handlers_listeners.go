@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -44,9 +45,9 @@ func (s *Server) handleListenersAPI(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Validate port conflict - only check if another listener is actively using the port
-		if s.config.Listeners != nil {
+		if s.config.Listeners != nil && listener.Protocol != "UNIX" {
 			for _, existing := range s.config.Listeners {
-				if existing.Port == listener.Port && existing.ID != listener.ID {
+				if existing.Port == listener.Port && existing.ID != listener.ID && existing.Protocol != "UNIX" {
 					// Check if the existing listener is actually running
 					s.listenerMu.RLock()
 					_, isRunning := s.activeListeners[existing.ID]
@@ -61,6 +62,35 @@ func (s *Server) handleListenersAPI(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if err := validateListenerParser(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateUnixListener(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateListenerProxy(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateListenerAccess(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateListenerClientAuth(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateListenerFraming(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateListenerMultiline(&listener); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Generate ID if not provided
 		if listener.ID == "" {
 			listener.ID = fmt.Sprintf("listener-%d", time.Now().UnixNano())
@@ -109,9 +139,35 @@ func (s *Server) handleListenerAPI(w http.ResponseWriter, r *http.Request) {
 
 	listenerID := pathParts[2]
 
+	// GET /api/listeners/{id}/stats
+	if len(pathParts) == 4 && pathParts[3] == "stats" {
+		s.handleListenerStats(w, r, listenerID)
+		return
+	}
+
+	// POST /api/listeners/{id}/certificate
+	if len(pathParts) == 4 && pathParts[3] == "certificate" {
+		s.handleListenerCertificateIssue(w, r, listenerID)
+		return
+	}
+
 	if r.Method == "PUT" {
+		// Fields left out of the request are unchanged
 		var update struct {
-			Enabled bool `json:"enabled"`
+			Enabled            *bool           `json:"enabled"`
+			Parser             *string         `json:"parser"`
+			BestEffort         *bool           `json:"best_effort"`
+			ParserFallback     *string         `json:"parser_fallback"`
+			DeviceID           *string         `json:"device_id"`
+			ProxyProtocol      *bool           `json:"proxy_protocol"`
+			ProxyTrustedCIDRs  *[]string       `json:"proxy_trusted_cidrs"`
+			AllowCIDRs         *[]string       `json:"allow_cidrs"`
+			DenyCIDRs          *[]string       `json:"deny_cidrs"`
+			RateLimit          json.RawMessage `json:"rate_limit"` // null removes the limit
+			ClientAuth         *string         `json:"client_auth"`
+			CRLFile            *string         `json:"crl_file"`
+			ClientFingerprints *[]string       `json:"client_fingerprints"`
+			Multiline          json.RawMessage `json:"multiline"` // null turns multiline off
 		}
 		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -122,22 +178,115 @@ func (s *Server) handleListenerAPI(w http.ResponseWriter, r *http.Request) {
 		if s.config.Listeners != nil {
 			for i := range s.config.Listeners {
 				if s.config.Listeners[i].ID == listenerID {
+					updated := s.config.Listeners[i]
+					if update.Parser != nil {
+						updated.Parser = *update.Parser
+					}
+					if update.BestEffort != nil {
+						updated.BestEffort = update.BestEffort
+					}
+					if update.ParserFallback != nil {
+						updated.ParserFallback = *update.ParserFallback
+					}
+					if update.DeviceID != nil {
+						updated.DeviceID = *update.DeviceID
+					}
+					if update.ProxyProtocol != nil {
+						updated.ProxyProtocol = *update.ProxyProtocol
+					}
+					if update.ProxyTrustedCIDRs != nil {
+						updated.ProxyTrustedCIDRs = *update.ProxyTrustedCIDRs
+					}
+					if update.AllowCIDRs != nil {
+						updated.AllowCIDRs = *update.AllowCIDRs
+					}
+					if update.DenyCIDRs != nil {
+						updated.DenyCIDRs = *update.DenyCIDRs
+					}
+					if update.ClientAuth != nil {
+						updated.ClientAuth = *update.ClientAuth
+					}
+					if update.CRLFile != nil {
+						updated.CRLFile = *update.CRLFile
+					}
+					if update.ClientFingerprints != nil {
+						updated.ClientFingerprints = *update.ClientFingerprints
+					}
+					if update.RateLimit != nil {
+						updated.RateLimit = nil
+						if err := json.Unmarshal(update.RateLimit, &updated.RateLimit); err != nil {
+							http.Error(w, "invalid rate_limit: "+err.Error(), http.StatusBadRequest)
+							return
+						}
+					}
+					if update.Multiline != nil {
+						updated.Multiline = nil
+						if err := json.Unmarshal(update.Multiline, &updated.Multiline); err != nil {
+							http.Error(w, "invalid multiline: "+err.Error(), http.StatusBadRequest)
+							return
+						}
+					}
+					if err := validateListenerParser(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateUnixListener(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateListenerProxy(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateListenerAccess(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateListenerClientAuth(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateListenerMultiline(&updated); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					settingsChanged := update.Parser != nil || update.BestEffort != nil || update.ParserFallback != nil || update.DeviceID != nil ||
+						update.ProxyProtocol != nil || update.ProxyTrustedCIDRs != nil ||
+						update.AllowCIDRs != nil || update.DenyCIDRs != nil || update.RateLimit != nil ||
+						update.ClientAuth != nil || update.CRLFile != nil || update.ClientFingerprints != nil ||
+						update.Multiline != nil
+
 					oldEnabled := s.config.Listeners[i].Enabled
-					s.config.Listeners[i].Enabled = update.Enabled
+					enabled := oldEnabled
+					if update.Enabled != nil {
+						enabled = *update.Enabled
+					}
+					s.config.Listeners[i] = updated
+					s.config.Listeners[i].Enabled = enabled
 
 					// Start or stop the listener
-					if update.Enabled && !oldEnabled {
+					if enabled && !oldEnabled {
 						// Start the listener
 						if err := s.startListener(s.config.Listeners[i]); err != nil {
 							s.config.Listeners[i].Enabled = false // Revert on error
 							http.Error(w, fmt.Sprintf("failed to start listener: %v", err), http.StatusInternalServerError)
 							return
 						}
-					} else if !update.Enabled && oldEnabled {
+					} else if !enabled && oldEnabled {
 						// Stop the listener
 						if err := s.stopListener(listenerID); err != nil {
 							log.Printf("Warning: failed to stop listener %s: %v", listenerID, err)
 						}
+					} else if enabled && settingsChanged {
+						// Restart so the new parser, device, proxy, filtering or client certificate settings take effect
+						if err := s.stopListener(listenerID); err != nil {
+							log.Printf("Warning: failed to stop listener %s: %v", listenerID, err)
+						}
+						if err := s.startListener(s.config.Listeners[i]); err != nil {
+							s.config.Listeners[i].Enabled = false
+							http.Error(w, fmt.Sprintf("failed to restart listener: %v", err), http.StatusInternalServerError)
+							return
+						}
 					}
 
 					if err := SaveConfig("config.json", s.config); err != nil {
@@ -180,6 +329,7 @@ func (s *Server) handleListenerAPI(w http.ResponseWriter, r *http.Request) {
 		}
 
 		s.config.Listeners = newListeners
+		s.deleteListenerStats(listenerID)
 
 		// Remove devices bound to this listener
 		if s.config.Devices != nil {
@@ -197,6 +347,7 @@ func (s *Server) handleListenerAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.devices.SetDevices(s.config.Devices)
 
 		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
 		return
@@ -227,7 +378,7 @@ func (s *Server) handleCertUploadAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileType := r.FormValue("type") // "cert", "key", or "ca"
+	fileType := r.FormValue("type") // "cert", "key", "ca" or "crl"
 	if fileType == "" {
 		http.Error(w, "file type required", http.StatusBadRequest)
 		return
@@ -272,12 +423,38 @@ func (s *Server) handleCertUploadAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if fileType == "crl" {
+		// Checked against the listener's CA when the listener starts
+		if _, err := readCRLFile(filepath); err != nil {
+			os.Remove(filepath)
+			http.Error(w, "invalid CRL: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	json.NewEncoder(w).Encode(map[string]string{
+	response := map[string]interface{}{
 		"status": "success",
 		"path":   filepath,
 		"name":   header.Filename,
-	})
+	}
+
+	// With listener_id the file replaces the listener's current one, and a
+	// running listener switches to it without a restart
+	if listenerID := r.FormValue("listener_id"); listenerID != "" {
+		certs, err := s.setListenerCertFile(listenerID, fileType, filepath)
+		if errors.Is(err, errListenerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if certs != nil {
+			response["certificate"] = certs.Info()
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
 }
 
 func (s *Server) handleCertValidateAPI(w http.ResponseWriter, r *http.Request) {
@@ -370,6 +547,52 @@ func (s *Server) validateCertificate(certPath string) error {
 	return nil
 }
 
+// listenerStatsResponse is a listener's counters, ingest queue and open connections
+type listenerStatsResponse struct {
+	ListenerID string `json:"listener_id"`
+	Running    bool   `json:"running"`
+	ListenerCounters
+	Ingest          *IngestStats      `json:"ingest,omitempty"`
+	OpenConnections []ConnectionStats `json:"open_connections"`
+}
+
+func (s *Server) handleListenerStats(w http.ResponseWriter, r *http.Request, listenerID string) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	found := false
+	for _, listener := range s.config.Listeners {
+		if listener.ID == listenerID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "listener not found", http.StatusNotFound)
+		return
+	}
+
+	stats := s.listenerStatsFor(listenerID)
+	response := listenerStatsResponse{
+		ListenerID:       listenerID,
+		ListenerCounters: stats.Counters(),
+		OpenConnections:  stats.OpenConnections(),
+	}
+
+	s.listenerMu.RLock()
+	control, running := s.activeListeners[listenerID]
+	s.listenerMu.RUnlock()
+	response.Running = running
+	if running && control.Ingest != nil {
+		ingest := control.Ingest.Stats()
+		response.Ingest = &ingest
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
 func (s *Server) getCertificateInfo(certPath string) (map[string]interface{}, error) {
 	certPEM, err := os.ReadFile(certPath)
 	if err != nil {
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"qlog/modules"
 )
@@ -45,6 +47,8 @@ func (s *Server) handleLogsAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	device := r.URL.Query().Get("device")
+	deviceID := r.URL.Query().Get("device_id")
+	listenerID := r.URL.Query().Get("listener_id")
 	deviceType := r.URL.Query().Get("device_type")
 	eventType := r.URL.Query().Get("event_type")
 	dateRange := r.URL.Query().Get("date_range")
@@ -52,12 +56,21 @@ func (s *Server) handleLogsAPI(w http.ResponseWriter, r *http.Request) {
 	dateTo := r.URL.Query().Get("date_to")
 	search := r.URL.Query().Get("search")
 
+	// A configured device's ID or name selects its logs exactly; anything
+	// else is matched against hostname, address and parsed fields
+	if device != "" && deviceID == "" {
+		if matched := s.findDevice(device); matched != nil {
+			deviceID = matched.ID
+			device = ""
+		}
+	}
+
 	var logs []*LogEntry
 	var err error
 	if dateFrom != "" && dateTo != "" {
-		logs, err = s.db.GetLogsWithCustomDate(limit, offset, severity, device, deviceType, eventType, dateRange, dateFrom, dateTo, search)
+		logs, err = s.db.GetLogsWithCustomDate(limit, offset, severity, device, deviceID, listenerID, deviceType, eventType, dateRange, dateFrom, dateTo, search)
 	} else {
-		logs, err = s.db.GetLogs(limit, offset, severity, device, deviceType, eventType, dateRange, search)
+		logs, err = s.db.GetLogs(limit, offset, severity, device, deviceID, listenerID, deviceType, eventType, dateRange, search)
 	}
 	if err != nil {
 		log.Printf("Error fetching logs: %v", err)
@@ -65,12 +78,99 @@ func (s *Server) handleLogsAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	names := s.deviceNames()
+	for _, entry := range logs {
+		entry.DeviceName = names[entry.DeviceID]
+	}
+
 	log.Printf("API: Returning %d logs (limit=%d, offset=%d)", len(logs), limit, offset)
 	if err := json.NewEncoder(w).Encode(logs); err != nil {
 		log.Printf("Error encoding logs: %v", err)
 	}
 }
 
+// handleLogStreamAPI streams newly saved logs as server-sent events. Accepts
+// the same severity, device, device_id, listener_id, device_type, event_type
+// and search filters as /api/logs. Clients that can't keep up are sent an error event and disconnected.
+func (s *Server) handleLogStreamAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := LogFilter{
+		Device:     r.URL.Query().Get("device"),
+		DeviceID:   r.URL.Query().Get("device_id"),
+		ListenerID: r.URL.Query().Get("listener_id"),
+		DeviceType: r.URL.Query().Get("device_type"),
+		EventType:  r.URL.Query().Get("event_type"),
+		Search:     r.URL.Query().Get("search"),
+	}
+	if filter.Device != "" && filter.DeviceID == "" {
+		if matched := s.findDevice(filter.Device); matched != nil {
+			filter.DeviceID = matched.ID
+			filter.Device = ""
+		}
+	}
+	if sevStr := r.URL.Query().Get("severity"); sevStr != "" {
+		if sev, err := strconv.ParseUint(sevStr, 10, 8); err == nil {
+			sev8 := uint8(sev)
+			filter.Severity = &sev8
+		}
+	}
+	// Match search like /api/logs does at the time the stream starts
+	if s.db.searchIndexQuery(filter.Search) != "" {
+		filter.UseSearchIndex()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sub := s.liveTail.Subscribe(filter)
+	defer s.liveTail.Unsubscribe(sub)
+
+	// Tell the browser to retry after 3s if the connection drops
+	fmt.Fprint(w, "retry: 3000\n: connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-sub.Events:
+			if _, err := fmt.Fprint(w, formatSSE("log", event.ID, event.Data)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.Dropped:
+			log.Printf("Live tail: disconnecting slow client %s", r.RemoteAddr)
+			fmt.Fprint(w, formatSSE("error", 0, []byte(`{"error":"client too slow, disconnected"}`)))
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			// Comment line keeps proxies from closing an idle connection
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
 func (s *Server) handleLogDetailAPI(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
@@ -100,14 +200,16 @@ func (s *Server) handleLogDetailAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "log not found", http.StatusNotFound)
 		return
 	}
+	logEntry.DeviceName = s.deviceNames()[logEntry.DeviceID]
 
-	// Get display info from module system
+	// Get display info from module system. Modules work on the message body,
+	// not the full frame stored as raw_message.
 	parsed := &modules.ParsedLog{
 		DeviceType:    logEntry.DeviceType,
 		EventType:     logEntry.EventType,
 		EventCategory: logEntry.EventCategory,
 		Fields:        logEntry.ParsedFields,
-		RawMessage:    logEntry.RawMessage,
+		RawMessage:    logEntry.Body(),
 		Timestamp:     logEntry.Timestamp,
 		Severity:      logEntry.GetSeverityName(),
 		Priority:      int(logEntry.Priority),
@@ -118,6 +220,8 @@ func (s *Server) handleLogDetailAPI(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"log":          logEntry,
 		"display_info": displayInfo,
+		"frame":        logEntry.RawMessage,
+		"body":         logEntry.Body(),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -168,7 +272,21 @@ func (s *Server) handleSearchAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logs, err := s.db.GetLogs(100, 0, nil, "", "", "", "", search)
+	limit := 100
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			limit = l
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			offset = o
+		}
+	}
+
+	// Results are ranked by relevance when the full-text index is available
+	logs, err := s.db.SearchLogs(search, limit, offset)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
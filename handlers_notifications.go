@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification channel API handlers
+
+func (s *Server) handleNotificationChannelsAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing notification channels
+	if isSharedViewRequest(r) {
+		http.Error(w, "notification channel access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		channels := make([]NotificationChannel, len(s.config.Notifications))
+		for i, channel := range s.config.Notifications {
+			channels[i] = redactChannel(channel)
+		}
+		json.NewEncoder(w).Encode(channels)
+		return
+	}
+
+	if r.Method == "POST" {
+		var channel NotificationChannel
+		if err := json.NewDecoder(r.Body).Decode(&channel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateNotificationChannel(&channel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, existing := range s.config.Notifications {
+			if existing.ID == channel.ID {
+				http.Error(w, "notification channel ID already exists", http.StatusConflict)
+				return
+			}
+		}
+
+		s.config.Notifications = append(s.config.Notifications, channel)
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.notifier.SetChannels(s.config.Notifications)
+
+		json.NewEncoder(w).Encode(redactChannel(channel))
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleNotificationChannelAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing notification channels
+	if isSharedViewRequest(r) {
+		http.Error(w, "notification channel access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "invalid notification channel ID", http.StatusBadRequest)
+		return
+	}
+
+	channelID := pathParts[2]
+
+	if r.Method == "PUT" {
+		var channel NotificationChannel
+		if err := json.NewDecoder(r.Body).Decode(&channel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		channel.ID = channelID
+
+		for i := range s.config.Notifications {
+			if s.config.Notifications[i].ID == channelID {
+				restoreChannelSecrets(&channel, s.config.Notifications[i])
+				if err := validateNotificationChannel(&channel); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				s.config.Notifications[i] = channel
+				if err := SaveConfig("config.json", s.config); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				s.notifier.SetChannels(s.config.Notifications)
+
+				json.NewEncoder(w).Encode(redactChannel(channel))
+				return
+			}
+		}
+
+		http.Error(w, "notification channel not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		newChannels := []NotificationChannel{}
+		found := false
+		for _, channel := range s.config.Notifications {
+			if channel.ID == channelID {
+				found = true
+				continue
+			}
+			newChannels = append(newChannels, channel)
+		}
+
+		if !found {
+			http.Error(w, "notification channel not found", http.StatusNotFound)
+			return
+		}
+
+		s.config.Notifications = newChannels
+		if err := SaveConfig("config.json", s.config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.notifier.SetChannels(s.config.Notifications)
+
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// handleNotificationTestAPI sends a test alert through a channel and reports
+// the result. The body is either {"id": "..."} for a saved channel or a full
+// (possibly unsaved) channel definition.
+func (s *Server) handleNotificationTestAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from sending notifications
+	if isSharedViewRequest(r) {
+		http.Error(w, "notification testing not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var channel NotificationChannel
+	if err := json.NewDecoder(r.Body).Decode(&channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, existing := range s.config.Notifications {
+		if existing.ID != "" && existing.ID == channel.ID {
+			if channel.Type == "" {
+				channel = existing
+			} else {
+				restoreChannelSecrets(&channel, existing)
+			}
+			break
+		}
+	}
+
+	if err := validateNotificationChannel(&channel); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	alert := &Alert{
+		RuleID:   "test",
+		RuleName: "Test notification",
+		Count:    1,
+		Message:  "Test notification from qLog",
+		LogIDs:   []int64{},
+		FiredAt:  time.Now(),
+	}
+
+	// Single attempt so the result is immediate
+	start := time.Now()
+	if err := sendNotification(&channel, alert); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+func (s *Server) handleNotificationDeliveriesAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing the delivery log
+	if isSharedViewRequest(r) {
+		http.Error(w, "notification access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			limit = l
+		}
+	}
+	var alertID int64
+	if alertStr := r.URL.Query().Get("alert_id"); alertStr != "" {
+		if id, err := strconv.ParseInt(alertStr, 10, 64); err == nil {
+			alertID = id
+		}
+	}
+
+	deliveries, err := s.db.GetNotificationDeliveries(limit, r.URL.Query().Get("channel_id"), alertID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+}
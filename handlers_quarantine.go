@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Unknown sender (quarantine) API handlers
+
+func (s *Server) handleUnknownSendersAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing quarantined messages
+	if isSharedViewRequest(r) {
+		http.Error(w, "unknown sender access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	senders, err := s.db.GetUnknownSenders(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(senders)
+}
+
+// handleUnknownSenderAPI serves /api/unknown-senders/{ip}: GET returns the
+// sender and its quarantined messages, DELETE discards them, and
+// POST /api/unknown-senders/{ip}/adopt creates a device for the sender
+func (s *Server) handleUnknownSenderAPI(w http.ResponseWriter, r *http.Request) {
+	addSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Block shared views from accessing quarantined messages
+	if isSharedViewRequest(r) {
+		http.Error(w, "unknown sender access not allowed in shared view mode", http.StatusForbidden)
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 3 {
+		http.Error(w, "invalid source IP", http.StatusBadRequest)
+		return
+	}
+
+	sourceIP, err := url.PathUnescape(pathParts[2])
+	if err != nil {
+		http.Error(w, "invalid source IP", http.StatusBadRequest)
+		return
+	}
+
+	if len(pathParts) >= 4 && pathParts[3] == "adopt" {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request struct {
+			DeviceConfig
+			Backfill bool `json:"backfill"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		device, backfilled, err := s.adoptUnknownSender(sourceIP, request.DeviceConfig, request.Backfill)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "unknown sender not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device":     device,
+			"backfilled": backfilled,
+		})
+		return
+	}
+
+	if r.Method == "GET" {
+		sender, err := s.db.GetUnknownSender(sourceIP)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "unknown sender not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		messages, err := s.db.GetQuarantinedLogs(sourceIP, quarantinePerSource)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sender":   sender,
+			"messages": messages,
+		})
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if err := s.db.DeleteUnknownSender(sourceIP); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "unknown sender not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"time"
 )
 
 // Query, aggregation, and server info API handlers
@@ -27,8 +30,25 @@ func (s *Server) handleQueryAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Raw SQL fragments are no longer accepted - queries use the query language
+	for _, key := range []string{"select", "from", "where", "groupBy", "orderBy"} {
+		if _, ok := queryConfig[key]; ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "raw SQL fields are not supported, send a query language string in \"query\"",
+			})
+			return
+		}
+	}
+
 	result, err := s.db.ExecuteQuery(queryConfig)
 	if err != nil {
+		var queryErr *QueryError
+		if errors.As(err, &queryErr) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(queryErr)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -58,11 +78,13 @@ func (s *Server) handleAggregateAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.resolveDeviceFilter(aggregation)
 	result, err := s.db.GetAggregatedData(aggregation)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.addDeviceNames(aggregation, result, "group_value")
 
 	json.NewEncoder(w).Encode(result)
 }
@@ -89,15 +111,61 @@ func (s *Server) handleTimeSeriesAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.resolveDeviceFilter(config)
 	result, err := s.db.GetTimeSeriesData(config)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.addDeviceNames(config, result, "series")
 
 	json.NewEncoder(w).Encode(result)
 }
 
+// resolveDeviceFilter turns a "device" filter (a configured device's ID or
+// name) into an exact device_id filter
+func (s *Server) resolveDeviceFilter(request map[string]interface{}) {
+	filters := getMap(request, "filters")
+	device := getString(filters, "device", "")
+	if device == "" || getString(filters, "device_id", "") != "" {
+		return
+	}
+	if matched := s.findDevice(device); matched != nil {
+		filters["device_id"] = matched.ID
+	} else {
+		// Unknown now, but may be the ID of a since-deleted device
+		filters["device_id"] = device
+	}
+}
+
+// addDeviceNames adds each row's current device name when results are
+// grouped by device
+func (s *Server) addDeviceNames(request map[string]interface{}, result *QueryResult, column string) {
+	if groupBy := getString(request, "groupBy", ""); groupBy != "device" && groupBy != "device_id" {
+		return
+	}
+	names := s.deviceNames()
+	result.Columns = append(result.Columns, "device_name")
+	for _, row := range result.Rows {
+		id, _ := row[column].(string)
+		row["device_name"] = names[id]
+	}
+}
+
+// serverIPAddresses returns the server's non-loopback IPv4 addresses
+func serverIPAddresses() []string {
+	addrs, _ := net.InterfaceAddrs()
+	ipAddresses := []string{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			if ipNet.IP.To4() != nil {
+				ipAddresses = append(ipAddresses, ipNet.IP.String())
+			}
+		}
+	}
+	return ipAddresses
+}
+
 func (s *Server) handleServerInfoAPI(w http.ResponseWriter, r *http.Request) {
 	addSecurityHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
@@ -115,19 +183,12 @@ func (s *Server) handleServerInfoAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get server IP addresses
-	addrs, _ := net.InterfaceAddrs()
-	ipAddresses := []string{}
-	for _, addr := range addrs {
-		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
-			if ipNet.IP.To4() != nil {
-				ipAddresses = append(ipAddresses, ipNet.IP.String())
-			}
-		}
-	}
+	ipAddresses := serverIPAddresses()
 
 	// Get active listeners
 	s.listenerMu.RLock()
 	activeListeners := []map[string]interface{}{}
+	warnings := []string{}
 	for id, control := range s.activeListeners {
 		// Find listener config
 		var listenerConfig *ListenerConfig
@@ -140,13 +201,39 @@ func (s *Server) handleServerInfoAPI(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if listenerConfig != nil {
-			activeListeners = append(activeListeners, map[string]interface{}{
+			listenerInfo := map[string]interface{}{
 				"id":       id,
 				"name":     listenerConfig.Name,
 				"protocol": listenerConfig.Protocol,
 				"port":     listenerConfig.Port,
 				"type":     control.Type,
-			})
+			}
+			if listenerConfig.Protocol == "UNIX" {
+				listenerInfo["socket_path"] = listenerConfig.SocketPath
+				listenerInfo["socket_type"] = listenerConfig.SocketType
+				if listenerConfig.SocketType == "" {
+					listenerInfo["socket_type"] = unixSocketDatagram
+				}
+			}
+			if control.Ingest != nil {
+				listenerInfo["ingest"] = control.Ingest.Stats()
+			}
+			if counts := control.Stats.ConnectionsByFraming(); len(counts) > 0 {
+				listenerInfo["connections_by_framing"] = counts
+			}
+			if control.Certs != nil {
+				certificate := control.Certs.Info()
+				listenerInfo["certificate"] = certificate
+				if certificate.NotAfter.Before(time.Now()) {
+					warnings = append(warnings, fmt.Sprintf("TLS certificate of listener %s expired on %s", listenerConfig.Name, certificate.NotAfter.Format("2006-01-02")))
+				} else if certificate.Expiring {
+					warnings = append(warnings, fmt.Sprintf("TLS certificate of listener %s expires in %d days (%s)", listenerConfig.Name, certificate.DaysRemaining, certificate.NotAfter.Format("2006-01-02")))
+				}
+				if certificate.ReloadError != "" {
+					warnings = append(warnings, fmt.Sprintf("TLS certificate of listener %s could not be reloaded: %s", listenerConfig.Name, certificate.ReloadError))
+				}
+			}
+			activeListeners = append(activeListeners, listenerInfo)
 		}
 	}
 	s.listenerMu.RUnlock()
@@ -191,13 +278,18 @@ func (s *Server) handleServerInfoAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	info := map[string]interface{}{
-		"ip_addresses":     ipAddresses,
-		"web_port":         s.config.Web.Port,
-		"database_path":    s.config.Database.Path,
-		"message_limit":    s.config.Database.Limit,
-		"active_listeners": activeListeners,
-		"device_summary":   deviceSummary,
-		"listener_summary": listenerSummary,
+		"ip_addresses":       ipAddresses,
+		"web_port":           s.config.Web.Port,
+		"database_path":      s.config.Database.Path,
+		"message_limit":      s.config.Database.Limit,
+		"active_listeners":   activeListeners,
+		"device_summary":     deviceSummary,
+		"listener_summary":   listenerSummary,
+		"retention":          s.retention.Last(),
+		"live_tail_clients":  s.liveTail.Count(),
+		"forwarding":         s.forwarder.Stats(),
+		"quarantine_dropped": s.quarantine.Dropped(),
+		"warnings":           warnings,
 	}
 
 	json.NewEncoder(w).Encode(info)
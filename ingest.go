@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ingest stage: bounded per-listener queue feeding a fixed worker pool
+
+const (
+	defaultIngestQueueSize = 10000
+	ingestDropLogInterval  = 10 * time.Second
+)
+
+// ingestMessage is a received payload waiting to be processed.
+// data must be owned by the message (never a slice of a reused read buffer).
+type ingestMessage struct {
+	data           []byte
+	remoteAddr     string
+	protocol       string
+	parsers        *ParserChain
+	listenerID     string
+	deviceID       string           // Device the message belongs to, if known from the listener or client certificate
+	clientIdentity string           // Client certificate identity of the connection, if any
+	connection     *ConnectionStats // Stream connection the message arrived on, if any
+	filter         *SourceFilter    // Applied by processMessage; datagram listeners apply it before queueing instead
+	receivedAt     time.Time
+	onSaved        func(err error)    // Optional, called once the message is stored or discarded
+	continuation   []continuationLine // Following frames joined to this one by multiline rules
+}
+
+// size returns the number of bytes received for the message
+func (m ingestMessage) size() int {
+	size := len(m.data)
+	for _, line := range m.continuation {
+		size += len(line.raw)
+	}
+	return size
+}
+
+// IngestStats is a snapshot of an ingest queue's counters
+type IngestStats struct {
+	QueueSize int   `json:"queue_size"`
+	Queued    int   `json:"queued"`
+	Workers   int   `json:"workers"`
+	Received  int64 `json:"received"`
+	Processed int64 `json:"processed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+// IngestQueue buffers messages for a listener and processes them with a
+// fixed number of workers. When the queue is full new messages are dropped
+// and counted instead of spawning more goroutines.
+type IngestQueue struct {
+	name    string
+	queue   chan ingestMessage
+	workers int
+	handler func(ingestMessage)
+	stats   *ListenerStats
+	wg      sync.WaitGroup
+
+	received  atomic.Int64
+	processed atomic.Int64
+	dropped   atomic.Int64
+
+	// Drops since the last "queue full" warning, to avoid flooding the log
+	droppedSinceLog atomic.Int64
+	lastDropLog     atomic.Int64 // unix nanos
+}
+
+// NewIngestQueue creates a queue and starts its workers. Drops are also
+// counted in stats. size and workers fall back to defaults when <= 0.
+func NewIngestQueue(name string, size, workers int, handler func(ingestMessage), stats *ListenerStats) *IngestQueue {
+	if size <= 0 {
+		size = defaultIngestQueueSize
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	q := &IngestQueue{
+		name:    name,
+		queue:   make(chan ingestMessage, size),
+		workers: workers,
+		handler: handler,
+		stats:   stats,
+	}
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+
+	return q
+}
+
+func (q *IngestQueue) worker() {
+	defer q.wg.Done()
+	for msg := range q.queue {
+		q.handler(msg)
+		q.processed.Add(1)
+	}
+}
+
+// Enqueue adds a message without blocking. It returns false (and counts a
+// drop) if the queue is full.
+func (q *IngestQueue) Enqueue(msg ingestMessage) bool {
+	q.received.Add(1)
+	select {
+	case q.queue <- msg:
+		return true
+	default:
+		q.dropped.Add(1)
+		q.stats.RecordQueueDrop(len(msg.data))
+		q.droppedSinceLog.Add(1)
+		q.logDrops()
+		return false
+	}
+}
+
+// logDrops reports queue overflow at most once per ingestDropLogInterval
+func (q *IngestQueue) logDrops() {
+	now := time.Now().UnixNano()
+	last := q.lastDropLog.Load()
+	if now-last < int64(ingestDropLogInterval) {
+		return
+	}
+	if !q.lastDropLog.CompareAndSwap(last, now) {
+		return
+	}
+	n := q.droppedSinceLog.Swap(0)
+	log.Printf("Ingest queue full for listener %s: dropped %d messages (total dropped: %d)", q.name, n, q.dropped.Load())
+}
+
+// Close stops accepting messages and waits for queued messages to be processed.
+// The caller must ensure Enqueue is no longer called.
+func (q *IngestQueue) Close() {
+	close(q.queue)
+	q.wg.Wait()
+}
+
+// Stats returns a snapshot of the queue counters
+func (q *IngestQueue) Stats() IngestStats {
+	return IngestStats{
+		QueueSize: cap(q.queue),
+		Queued:    len(q.queue),
+		Workers:   q.workers,
+		Received:  q.received.Load(),
+		Processed: q.processed.Load(),
+		Dropped:   q.dropped.Load(),
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Built-in certificate authority. It issues server certificates for TLS and
+// RELP listeners and client certificates for devices, and keeps a CRL of
+// the certificates it has revoked that listeners verify clients against.
+// The CA key never leaves the server; issued keys are not stored.
+
+const (
+	internalCADir           = "certs/ca"
+	caValidity              = 10 * 365 * 24 * time.Hour
+	defaultIssuedValidity   = 365 // Days
+	maxIssuedValidity       = 825 // Days; longer server certificates are rejected by browsers and some TLS stacks
+	crlValidity             = 7 * 24 * time.Hour
+	crlRefreshInterval      = 24 * time.Hour
+	clientCertificateURIFmt = "urn:qlog:device:%s" // URI SAN identifying the device a client certificate was issued to
+)
+
+var errNoInternalCA = errors.New("the internal CA has not been created")
+
+// CAInfo describes the internal CA
+type CAInfo struct {
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	CertFile    string    `json:"cert_file"`
+	CRLFile     string    `json:"crl_file"`
+}
+
+// InternalCA signs certificates with a key kept in internalCADir
+type InternalCA struct {
+	mu   sync.Mutex // Serializes CRL updates
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+	db   *Database
+}
+
+// CertFile is the CA certificate, used as a listener's ca_cert_file
+func (ca *InternalCA) CertFile() string { return filepath.Join(ca.dir, "ca.pem") }
+
+// CRLFile lists the revoked certificates, used as a listener's crl_file
+func (ca *InternalCA) CRLFile() string { return filepath.Join(ca.dir, "crl.pem") }
+
+func (ca *InternalCA) keyFile() string { return filepath.Join(ca.dir, "ca.key") }
+
+// loadInternalCA loads the CA from dir. It returns nil without an error if
+// the CA has not been created.
+func loadInternalCA(dir string, db *Database) (*InternalCA, error) {
+	ca := &InternalCA{dir: dir, db: db}
+	certPEM, err := os.ReadFile(ca.CertFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(ca.keyFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, errors.New("CA certificate or key is not PEM encoded")
+	}
+	if ca.cert, err = x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	ca.key = signer
+
+	// The CRL expires, so it is reissued on every start
+	if err := ca.writeCRL(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// createInternalCA generates a CA key and self-signed certificate in dir
+func createInternalCA(dir, commonName string, db *Database) (*InternalCA, error) {
+	if _, err := os.Stat(filepath.Join(dir, "ca.pem")); err == nil {
+		return nil, errors.New("the internal CA already exists")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"qLog"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &InternalCA{dir: dir, cert: cert, key: key, db: db}
+	if err := os.WriteFile(ca.keyFile(), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save CA key: %w", err)
+	}
+	if err := os.WriteFile(ca.CertFile(), encodeCertificate(cert), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save CA certificate: %w", err)
+	}
+	if err := ca.writeCRL(); err != nil {
+		return nil, err
+	}
+	log.Printf("Created internal CA %s", cert.Subject)
+	return ca, nil
+}
+
+// Info describes the CA
+func (ca *InternalCA) Info() CAInfo {
+	return CAInfo{
+		Subject:     ca.cert.Subject.String(),
+		Fingerprint: certificateFingerprint(ca.cert.Raw),
+		NotBefore:   ca.cert.NotBefore,
+		NotAfter:    ca.cert.NotAfter,
+		CertFile:    ca.CertFile(),
+		CRLFile:     ca.CRLFile(),
+	}
+}
+
+// Certificate returns the CA certificate
+func (ca *InternalCA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// IssueServerCertificate issues a certificate for a listener, valid for the
+// given host names and addresses
+func (ca *InternalCA) IssueServerCertificate(listener ListenerConfig, dnsNames []string, ipAddresses []net.IP, validityDays int) (*x509.Certificate, crypto.Signer, error) {
+	commonName := listener.Name
+	if len(dnsNames) > 0 {
+		commonName = dnsNames[0]
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName, Organization: []string{"qLog"}},
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return ca.issue(template, validityDays, IssuedCertificate{Kind: "server", ListenerID: listener.ID})
+}
+
+// IssueClientCertificate issues a certificate identifying a device. Besides
+// its name, the certificate carries the device's URI (see deviceCertificateURI)
+// so it still matches the device after a rename.
+func (ca *InternalCA) IssueClientCertificate(device DeviceConfig, validityDays int) (*x509.Certificate, crypto.Signer, error) {
+	uri, err := url.Parse(deviceCertificateURI(device.ID))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: device.Name, Organization: []string{"qLog"}},
+		URIs:        []*url.URL{uri},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return ca.issue(template, validityDays, IssuedCertificate{Kind: "client", DeviceID: device.ID})
+}
+
+// issue generates a key, signs a certificate for it from template and
+// records the certificate
+func (ca *InternalCA) issue(template *x509.Certificate, validityDays int, record IssuedCertificate) (*x509.Certificate, crypto.Signer, error) {
+	if validityDays <= 0 {
+		validityDays = defaultIssuedValidity
+	}
+	if validityDays > maxIssuedValidity {
+		return nil, nil, fmt.Errorf("validity_days must be at most %d", maxIssuedValidity)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template.SerialNumber, err = randomSerial(); err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template.NotBefore = now.Add(-time.Hour) // Tolerate senders with a slow clock
+	template.NotAfter = now.AddDate(0, 0, validityDays)
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record.Serial = cert.SerialNumber.Text(16)
+	record.Subject = cert.Subject.String()
+	record.Fingerprint = certificateFingerprint(cert.Raw)
+	record.NotBefore = cert.NotBefore
+	record.NotAfter = cert.NotAfter
+	record.IssuedAt = now
+	if err := ca.db.InsertIssuedCertificate(&record); err != nil {
+		return nil, nil, fmt.Errorf("failed to record certificate: %w", err)
+	}
+	log.Printf("Internal CA issued %s certificate %s (serial %s)", record.Kind, record.Subject, record.Serial)
+	return cert, key, nil
+}
+
+// Revoke revokes an issued certificate and reissues the CRL. Returns
+// sql.ErrNoRows for an unknown serial.
+func (ca *InternalCA) Revoke(serial string) error {
+	if err := ca.db.RevokeIssuedCertificate(serial, time.Now()); err != nil {
+		return err
+	}
+	log.Printf("Internal CA revoked certificate %s", serial)
+	return ca.writeCRL()
+}
+
+// writeCRL writes the CRL listing every revoked certificate
+func (ca *InternalCA) writeCRL() error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	issued, err := ca.db.GetIssuedCertificates("")
+	if err != nil {
+		return fmt.Errorf("failed to list issued certificates: %w", err)
+	}
+	var revoked []x509.RevocationListEntry
+	for _, cert := range issued {
+		if cert.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(cert.Serial, 16)
+		if !ok {
+			continue
+		}
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: *cert.RevokedAt})
+	}
+
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()), // Increases with every CRL
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		return fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	// Replaced atomically, so a listener reloading it never reads half a file
+	tmp := ca.CRLFile() + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to save CRL: %w", err)
+	}
+	if err := os.Rename(tmp, ca.CRLFile()); err != nil {
+		return fmt.Errorf("failed to save CRL: %w", err)
+	}
+	return nil
+}
+
+// refreshCRL reissues the CRL before it expires
+func (s *Server) refreshCRL() {
+	ticker := time.NewTicker(crlRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if ca := s.internalCA(); ca != nil {
+			if err := ca.writeCRL(); err != nil {
+				log.Printf("Error reissuing internal CA CRL: %v", err)
+			}
+		}
+	}
+}
+
+// internalCA returns the internal CA, or nil if it has not been created
+func (s *Server) internalCA() *InternalCA {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ca
+}
+
+// reloadCRLListeners reloads the certificates of running listeners that
+// verify clients against the internal CA's CRL, so a revocation applies to
+// new connections immediately instead of at the next file check
+func (s *Server) reloadCRLListeners(ca *InternalCA) {
+	for _, listener := range s.config.Listeners {
+		if listener.CRLFile != ca.CRLFile() {
+			continue
+		}
+		if certs := s.listenerCertStore(listener.ID); certs != nil {
+			certs.reload()
+		}
+	}
+}
+
+// deviceCertificateURI returns the URI SAN of client certificates issued to a device
+func deviceCertificateURI(deviceID string) string {
+	return fmt.Sprintf(clientCertificateURIFmt, deviceID)
+}
+
+// randomSerial returns a random positive serial number of up to 128 bits
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// encodeCertificate returns a certificate in PEM
+func encodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// encodePrivateKey returns a private key in PKCS#8 PEM
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
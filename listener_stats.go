@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Per-listener runtime statistics. Counters are saved periodically so totals
+// survive restarts; open connections only exist while the listener runs.
+
+const listenerStatsPersistInterval = time.Minute
+
+// ListenerCounters are a listener's cumulative totals
+type ListenerCounters struct {
+	MessagesReceived     int64            `json:"messages_received"`
+	BytesReceived        int64            `json:"bytes_received"`
+	ParseFailures        int64            `json:"parse_failures"`      // Frames no parser accepted (stored unparsed or dropped)
+	Rejected             int64            `json:"rejected"`            // From unknown senders (quarantined)
+	Oversized            int64            `json:"oversized"`           // Frames over the size limit or truncated datagrams
+	QueueDrops           int64            `json:"queue_drops"`         // Dropped because the ingest queue was full
+	Denied               int64            `json:"denied"`              // Dropped by allow_cidrs/deny_cidrs
+	RateLimited          int64            `json:"rate_limited"`        // Dropped by the rate limit
+	Sampled              int64            `json:"sampled"`             // Over the rate limit but kept by sampling
+	Connections          int64            `json:"connections"`         // Stream connections accepted
+	RefusedConnections   int64            `json:"refused_connections"` // Stream connections refused by allow_cidrs/deny_cidrs
+	ConnectionsByFraming map[string]int64 `json:"connections_by_framing,omitempty"`
+	Since                time.Time        `json:"since"` // When counting started
+}
+
+// ConnectionStats describes an open stream connection
+type ConnectionStats struct {
+	RemoteAddr    string     `json:"remote_addr"`
+	Protocol      string     `json:"protocol"`
+	ConnectedAt   time.Time  `json:"connected_at"`
+	TLSVersion    string     `json:"tls_version,omitempty"`
+	ClientSubject string     `json:"client_subject,omitempty"` // Subject of the verified client certificate
+	Messages      int64      `json:"messages"`
+	Bytes         int64      `json:"bytes"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+
+	listener *ListenerStats
+}
+
+// ListenerStats counts activity on a listener. Methods are safe to call on a
+// nil *ListenerStats, which counts nothing.
+type ListenerStats struct {
+	mu          sync.Mutex
+	counters    ListenerCounters
+	connections map[*ConnectionStats]struct{}
+}
+
+func NewListenerStats() *ListenerStats {
+	return newListenerStatsFrom(ListenerCounters{Since: time.Now()})
+}
+
+// newListenerStatsFrom continues counting from persisted totals
+func newListenerStatsFrom(counters ListenerCounters) *ListenerStats {
+	if counters.ConnectionsByFraming == nil {
+		counters.ConnectionsByFraming = make(map[string]int64)
+	}
+	return &ListenerStats{
+		counters:    counters,
+		connections: make(map[*ConnectionStats]struct{}),
+	}
+}
+
+// RecordMessage counts a received message of size bytes, on connection if
+// it came from a stream
+func (l *ListenerStats) RecordMessage(connection *ConnectionStats, size int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counters.MessagesReceived++
+	l.counters.BytesReceived += int64(size)
+	if connection != nil {
+		now := time.Now()
+		connection.Messages++
+		connection.Bytes += int64(size)
+		connection.LastMessageAt = &now
+	}
+}
+
+// RecordParseFailure counts a frame no parser accepted
+func (l *ListenerStats) RecordParseFailure() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.ParseFailures++
+	l.mu.Unlock()
+}
+
+// RecordRejected counts a message from an unknown sender
+func (l *ListenerStats) RecordRejected() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.Rejected++
+	l.mu.Unlock()
+}
+
+// RecordOversized counts a frame that was too large or a truncated datagram
+func (l *ListenerStats) RecordOversized() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.Oversized++
+	l.mu.Unlock()
+}
+
+// RecordQueueDrop counts a message of size bytes that was received but
+// dropped because the ingest queue was full
+func (l *ListenerStats) RecordQueueDrop(size int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.MessagesReceived++
+	l.counters.BytesReceived += int64(size)
+	l.counters.QueueDrops++
+	l.mu.Unlock()
+}
+
+// RecordFiltered counts a message of size bytes that was received but
+// dropped by the access lists (denied) or the rate limit
+func (l *ListenerStats) RecordFiltered(connection *ConnectionStats, size int, denied bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counters.MessagesReceived++
+	l.counters.BytesReceived += int64(size)
+	if denied {
+		l.counters.Denied++
+	} else {
+		l.counters.RateLimited++
+	}
+	if connection != nil {
+		connection.Messages++
+		connection.Bytes += int64(size)
+	}
+}
+
+// RecordSampled counts a message over the rate limit that was kept
+func (l *ListenerStats) RecordSampled() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.Sampled++
+	l.mu.Unlock()
+}
+
+// RecordRefusedConnection counts a stream connection refused by the access lists
+func (l *ListenerStats) RecordRefusedConnection() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.RefusedConnections++
+	l.mu.Unlock()
+}
+
+// RecordFraming counts a stream connection that used framing
+func (l *ListenerStats) RecordFraming(framing string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.counters.ConnectionsByFraming[framing]++
+	l.mu.Unlock()
+}
+
+// OpenConnection counts an accepted stream connection and tracks it until
+// CloseConnection is called
+func (l *ListenerStats) OpenConnection(remoteAddr, protocol string) *ConnectionStats {
+	connection := &ConnectionStats{
+		RemoteAddr:  remoteAddr,
+		Protocol:    protocol,
+		ConnectedAt: time.Now(),
+		listener:    l,
+	}
+	if l == nil {
+		return connection
+	}
+	l.mu.Lock()
+	l.counters.Connections++
+	l.connections[connection] = struct{}{}
+	l.mu.Unlock()
+	return connection
+}
+
+// CloseConnection stops tracking a connection
+func (l *ListenerStats) CloseConnection(connection *ConnectionStats) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.connections, connection)
+	l.mu.Unlock()
+}
+
+// Counters returns a copy of the listener's totals
+func (l *ListenerStats) Counters() ListenerCounters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counters := l.counters
+	counters.ConnectionsByFraming = make(map[string]int64, len(l.counters.ConnectionsByFraming))
+	for framing, count := range l.counters.ConnectionsByFraming {
+		counters.ConnectionsByFraming[framing] = count
+	}
+	return counters
+}
+
+// ConnectionsByFraming returns a copy of the per-framing connection counts
+func (l *ListenerStats) ConnectionsByFraming() map[string]int64 {
+	return l.Counters().ConnectionsByFraming
+}
+
+// OpenConnections returns the open connections, oldest first
+func (l *ListenerStats) OpenConnections() []ConnectionStats {
+	l.mu.Lock()
+	connections := make([]ConnectionStats, 0, len(l.connections))
+	for connection := range l.connections {
+		connections = append(connections, *connection)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt.Before(connections[j].ConnectedAt)
+	})
+	return connections
+}
+
+// SetTLSState records the negotiated TLS version and the client certificate
+func (c *ConnectionStats) SetTLSState(state tls.ConnectionState) {
+	c.lock()
+	defer c.unlock()
+
+	c.TLSVersion = tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		c.ClientSubject = state.PeerCertificates[0].Subject.String()
+	}
+}
+
+// RecordReadError counts frames that ended a connection by exceeding the
+// size limit
+func (c *ConnectionStats) RecordReadError(err error) {
+	if errors.Is(err, errFrameTooLarge) || errors.Is(err, bufio.ErrTooLong) {
+		c.listener.RecordOversized()
+	}
+}
+
+func (c *ConnectionStats) lock() {
+	if c.listener != nil {
+		c.listener.mu.Lock()
+	}
+}
+
+func (c *ConnectionStats) unlock() {
+	if c.listener != nil {
+		c.listener.mu.Unlock()
+	}
+}
+
+// listenerStatsFor returns the statistics of a listener, creating them on
+// first use. It returns nil for messages that didn't come from a listener.
+func (s *Server) listenerStatsFor(listenerID string) *ListenerStats {
+	if listenerID == "" {
+		return nil
+	}
+
+	s.listenerStatsMu.RLock()
+	stats := s.listenerStats[listenerID]
+	s.listenerStatsMu.RUnlock()
+	if stats != nil {
+		return stats
+	}
+
+	s.listenerStatsMu.Lock()
+	defer s.listenerStatsMu.Unlock()
+	if stats = s.listenerStats[listenerID]; stats == nil {
+		stats = NewListenerStats()
+		s.listenerStats[listenerID] = stats
+	}
+	return stats
+}
+
+// loadListenerStats restores the totals saved by a previous run
+func (s *Server) loadListenerStats() {
+	saved, err := s.db.GetListenerStats()
+	if err != nil {
+		log.Printf("Failed to load listener statistics: %v", err)
+		return
+	}
+
+	s.listenerStatsMu.Lock()
+	defer s.listenerStatsMu.Unlock()
+	for listenerID, counters := range saved {
+		s.listenerStats[listenerID] = newListenerStatsFrom(counters)
+	}
+}
+
+// saveListenerStats persists the totals of every listener
+func (s *Server) saveListenerStats() {
+	s.listenerStatsMu.RLock()
+	counters := make(map[string]ListenerCounters, len(s.listenerStats))
+	for listenerID, stats := range s.listenerStats {
+		counters[listenerID] = stats.Counters()
+	}
+	s.listenerStatsMu.RUnlock()
+
+	if err := s.db.SaveListenerStats(counters); err != nil {
+		log.Printf("Failed to save listener statistics: %v", err)
+	}
+}
+
+// persistListenerStats saves listener totals periodically
+func (s *Server) persistListenerStats() {
+	ticker := time.NewTicker(listenerStatsPersistInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.saveListenerStats()
+	}
+}
+
+// deleteListenerStats forgets the statistics of a deleted listener
+func (s *Server) deleteListenerStats(listenerID string) {
+	s.listenerStatsMu.Lock()
+	delete(s.listenerStats, listenerID)
+	s.listenerStatsMu.Unlock()
+
+	if err := s.db.DeleteListenerStats(listenerID); err != nil {
+		log.Printf("Failed to delete statistics of listener %s: %v", listenerID, err)
+	}
+}
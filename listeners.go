@@ -3,20 +3,20 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"os"
 	"strings"
 	"time"
-
-	"github.com/leodido/go-syslog/v4/octetcounting"
 )
 
 // Listener management functions
 
+// tlsHandshakeTimeout bounds how long a client may take to complete the TLS handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
 func (s *Server) startListeners() error {
 	if s.config.Listeners == nil {
 		return nil
@@ -43,35 +43,66 @@ func (s *Server) startListener(listener ListenerConfig) error {
 		return fmt.Errorf("listener %s is already running", listener.ID)
 	}
 
-	// Check if port is available
-	if !s.isPortAvailable(listener.Protocol, listener.Port) {
+	// Check if port is available (UNIX listeners bind a socket path instead)
+	if listener.Protocol != "UNIX" && !s.isPortAvailable(listener.Protocol, listener.Port) {
 		return fmt.Errorf("port %d is already in use", listener.Port)
 	}
 
 	var stopFunc func()
+	var ingest *IngestQueue
 	var err error
 
+	parsers := NewParserChain(listener, s.config.Parsing)
+	stats := s.listenerStatsFor(listener.ID)
+	filter, err := newSourceFilter(listener, s.devices, stats)
+	if err != nil {
+		return err
+	}
+
+	// RELP uses TLS when the listener has a certificate and key uploaded
+	var certs *certStore
+	if listener.Protocol == "TLS" || (listener.Protocol == "RELP" && (listener.CertFile != "" || listener.KeyFile != "")) {
+		if certs, err = newCertStore(listener); err != nil {
+			return err
+		}
+	}
+
 	switch listener.Protocol {
 	case "UDP":
-		stopFunc, err = s.startUDPListener(listener)
+		stopFunc, ingest, err = s.startUDPListener(listener, parsers, stats, filter)
 	case "TCP":
-		stopFunc, err = s.startTCPListener(listener)
+		stopFunc, err = s.startTCPListener(listener, parsers, stats, filter)
 	case "TLS":
-		stopFunc, err = s.startTLSListener(listener)
+		stopFunc, err = s.startTLSListener(listener, parsers, stats, filter, certs)
+	case "RELP":
+		stopFunc, err = s.startRELPListener(listener, parsers, stats, filter, certs)
+	case "UNIX":
+		stopFunc, ingest, err = s.startUnixListener(listener, parsers, stats, filter)
 	default:
 		return fmt.Errorf("unknown protocol: %s", listener.Protocol)
 	}
 
 	if err != nil {
+		certs.Close()
 		return err
 	}
 
 	s.activeListeners[listener.ID] = ListenerControl{
-		Stop: stopFunc,
-		Type: strings.ToLower(listener.Protocol),
+		Stop: func() {
+			stopFunc()
+			certs.Close()
+		},
+		Type:   strings.ToLower(listener.Protocol),
+		Ingest: ingest,
+		Stats:  stats,
+		Certs:  certs,
 	}
 
-	log.Printf("Started listener %s (ID: %s) on port %d", listener.Name, listener.ID, listener.Port)
+	if listener.Protocol == "UNIX" {
+		log.Printf("Started listener %s (ID: %s) on %s", listener.Name, listener.ID, listener.SocketPath)
+	} else {
+		log.Printf("Started listener %s (ID: %s) on port %d", listener.Name, listener.ID, listener.Port)
+	}
 	return nil
 }
 
@@ -89,7 +120,7 @@ func (s *Server) isPortAvailable(protocol string, port int) bool {
 		}
 		conn.Close()
 		return true
-	case "TCP", "TLS":
+	case "TCP", "TLS", "RELP":
 		addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port))
 		if err != nil {
 			return false
@@ -121,18 +152,22 @@ func (s *Server) stopListener(listenerID string) error {
 	return nil
 }
 
-func (s *Server) startUDPListener(listener ListenerConfig) (func(), error) {
+func (s *Server) startUDPListener(listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter) (func(), *IngestQueue, error) {
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", listener.Port))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	log.Printf("UDP syslog listener '%s' listening on port %d", listener.Name, listener.Port)
+	// Datagrams are handed to a bounded worker pool instead of a goroutine per packet
+	ingest := NewIngestQueue(listener.ID, listener.QueueSize, listener.Workers, s.processMessage, stats)
+
+	log.Printf("UDP syslog listener '%s' listening on port %d (queue: %d, workers: %d)",
+		listener.Name, listener.Port, cap(ingest.queue), ingest.workers)
 
 	stopChan := make(chan struct{})
 	done := make(chan struct{})
@@ -149,6 +184,7 @@ func (s *Server) startUDPListener(listener ListenerConfig) (func(), error) {
 			default:
 				conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
 				n, remoteAddr, err := conn.ReadFromUDP(buffer)
+				receivedAt := time.Now()
 				if err != nil {
 					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 						continue
@@ -159,7 +195,27 @@ func (s *Server) startUDPListener(listener ListenerConfig) (func(), error) {
 					continue
 				}
 
-				go s.processMessage(buffer[:n], remoteAddr.String(), "UDP", listener.Parser)
+				// A datagram that fills the buffer was truncated
+				if n == len(buffer) {
+					stats.RecordOversized()
+				}
+
+				// Copy the payload - buffer is reused by the next read
+				data := make([]byte, n)
+				copy(data, buffer[:n])
+
+				msg := ingestMessage{
+					data:       data,
+					remoteAddr: remoteAddr.String(),
+					protocol:   "UDP",
+					parsers:    parsers,
+					listenerID: listener.ID,
+					receivedAt: receivedAt,
+				}
+				// Filter before queueing so a flooding source can't crowd out others
+				if filter.Admit(msg) {
+					ingest.Enqueue(msg)
+				}
 			}
 		}
 	}()
@@ -168,12 +224,25 @@ func (s *Server) startUDPListener(listener ListenerConfig) (func(), error) {
 		close(stopChan)
 		conn.Close()
 		<-done
+		// Drain messages already accepted before returning
+		ingest.Close()
 	}
 
-	return stopFunc, nil
+	return stopFunc, ingest, nil
 }
 
-func (s *Server) startTCPListener(listener ListenerConfig) (func(), error) {
+func (s *Server) startTCPListener(listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter) (func(), error) {
+	if err := validateListenerFraming(&listener); err != nil {
+		return nil, err
+	}
+	if err := validateListenerMultiline(&listener); err != nil {
+		return nil, err
+	}
+	proxy, err := newProxyPolicy(listener)
+	if err != nil {
+		return nil, err
+	}
+
 	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", listener.Port))
 	if err != nil {
 		return nil, err
@@ -186,11 +255,11 @@ func (s *Server) startTCPListener(listener ListenerConfig) (func(), error) {
 
 	framing := listener.Framing
 	if framing == "" {
-		framing = "non-transparent"
+		framing = framingNonTransparent
 	}
 
-	log.Printf("TCP syslog listener '%s' listening on port %d (framing: %s, parser: %s)",
-		listener.Name, listener.Port, framing, listener.Parser)
+	log.Printf("TCP syslog listener '%s' listening on port %d (framing: %s, parser: %s, proxy protocol: %t)",
+		listener.Name, listener.Port, framing, listener.Parser, proxy != nil)
 
 	stopChan := make(chan struct{})
 	done := make(chan struct{})
@@ -216,7 +285,7 @@ func (s *Server) startTCPListener(listener ListenerConfig) (func(), error) {
 					continue
 				}
 
-				go s.handleTCPConnectionWithConfig(conn, listener)
+				go s.handleTCPConnectionWithConfig(conn, listener, parsers, proxy, stats, filter)
 			}
 		}
 	}()
@@ -230,42 +299,92 @@ func (s *Server) startTCPListener(listener ListenerConfig) (func(), error) {
 	return stopFunc, nil
 }
 
-func (s *Server) handleTCPConnectionWithConfig(conn *net.TCPConn, listener ListenerConfig) {
-	defer conn.Close()
+func (s *Server) handleTCPConnectionWithConfig(tcpConn *net.TCPConn, listener ListenerConfig, parsers *ParserChain, proxy *proxyPolicy, stats *ListenerStats, filter *SourceFilter) {
+	defer tcpConn.Close()
+
+	conn, err := proxy.wrap(tcpConn)
+	if err != nil {
+		log.Printf("TCP connection error: %v", err)
+		return
+	}
 	remoteAddr := conn.RemoteAddr().String()
+	if !filter.Permits(remoteAddr) {
+		stats.RecordRefusedConnection()
+		log.Printf("Refused TCP connection from %s: not allowed by the listener's access lists", remoteAddr)
+		return
+	}
+	reader := bufio.NewReader(conn)
 
-	if listener.Framing == "octet-counting" {
-		// Use octet counting scanner
-		scanner := octetcounting.NewScanner(conn, 65535)
-		defer scanner.Release()
+	connection := stats.OpenConnection(remoteAddr, "TCP")
+	defer stats.CloseConnection(connection)
 
-		for {
-			token := scanner.Scan()
-			tokenStr := token.String()
-			if tokenStr == "" {
-				break
+	framing := listener.Framing
+	split := bufio.ScanLines
+	switch framing {
+	case "":
+		framing = framingNonTransparent
+	case framingAuto:
+		if framing, err = detectFraming(reader); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("TCP connection error from %s: %v", remoteAddr, err)
 			}
-			s.processMessage([]byte(tokenStr), remoteAddr, "TCP", listener.Parser)
+			return
 		}
+		// Also accept NUL and CRLF terminated messages from senders we know less about
+		split = scanSyslogLines
+	}
+	stats.RecordFraming(framing)
+
+	// Frames continuing a message are joined to it before processing
+	process := s.processMessage
+	if rules := s.multilineRulesFor(listener, remoteAddr); rules != nil {
+		joiner := newMultilineJoiner(rules, s.processMessage)
+		defer joiner.Close()
+		process = joiner.Add
+	}
+
+	if framing == framingOctetCounting {
+		s.readOctetCountedFrames(reader, ingestMessage{
+			remoteAddr: remoteAddr,
+			protocol:   "TCP",
+			parsers:    parsers,
+			listenerID: listener.ID,
+			connection: connection,
+			filter:     filter,
+		}, process)
 	} else {
 		// Use non-transparent framing (default)
-		scanner := bufio.NewScanner(conn)
+		scanner := bufio.NewScanner(reader)
 		scanner.Buffer(make([]byte, 65535), 65535)
+		scanner.Split(split)
 
 		for scanner.Scan() {
 			data := scanner.Bytes()
 			if len(data) > 0 {
-				s.processMessage(data, remoteAddr, "TCP", listener.Parser)
+				process(ingestMessage{
+					data:       data,
+					remoteAddr: remoteAddr,
+					protocol:   "TCP",
+					parsers:    parsers,
+					listenerID: listener.ID,
+					connection: connection,
+					filter:     filter,
+					receivedAt: time.Now(),
+				})
 			}
 		}
 
 		if err := scanner.Err(); err != nil && err != io.EOF {
+			connection.RecordReadError(err)
 			log.Printf("TCP connection error: %v", err)
 		}
 	}
 }
 
-func (s *Server) startTLSListener(listener ListenerConfig) (func(), error) {
+// listenerTLSConfig builds the server TLS configuration from a listener's
+// uploaded certificate, key and optional CA certificate. Listeners serve it
+// through a certStore, which calls this again when the files change.
+func listenerTLSConfig(listener ListenerConfig) (*tls.Config, error) {
 	if listener.CertFile == "" || listener.KeyFile == "" {
 		return nil, fmt.Errorf("tls listener requires cert_file and key_file")
 	}
@@ -277,26 +396,23 @@ func (s *Server) startTLSListener(listener ListenerConfig) (func(), error) {
 
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
-		// Default: don't require client certificates (most syslog clients don't use them)
-		ClientAuth: tls.NoClientCert,
 	}
 
-	// If CA cert is provided, use it for client certificate validation (optional)
-	// This enables mutual TLS verification if clients present certificates
-	if listener.CaCertFile != "" {
-		caCert, err := os.ReadFile(listener.CaCertFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
-		}
+	// Client certificates are not requested unless a CA or fingerprints are
+	// configured (most syslog clients don't use them), and are optional
+	// unless client_auth is require
+	if err := configureClientAuth(tlsConfig, listener); err != nil {
+		return nil, err
+	}
 
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to parse CA certificate")
-		}
+	return tlsConfig, nil
+}
 
-		tlsConfig.ClientCAs = caCertPool
-		// Verify client certificates if provided, but don't require them
-		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+func (s *Server) startTLSListener(listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter, certs *certStore) (func(), error) {
+	tlsConfig := certs.TLSConfig()
+	proxy, err := newProxyPolicy(listener)
+	if err != nil {
+		return nil, err
 	}
 
 	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", listener.Port))
@@ -304,19 +420,21 @@ func (s *Server) startTLSListener(listener ListenerConfig) (func(), error) {
 		return nil, err
 	}
 
-	tlsListener, err := tls.Listen("tcp", addr.String(), tlsConfig)
+	// TLS is layered on each accepted connection so a PROXY header, which
+	// precedes the handshake, can be read first
+	tcpListener, err := net.ListenTCP("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("TLS syslog listener '%s' listening on port %d (framing: %s, parser: %s)",
-		listener.Name, listener.Port, listener.Framing, listener.Parser)
+	log.Printf("TLS syslog listener '%s' listening on port %d (framing: %s, parser: %s, proxy protocol: %t)",
+		listener.Name, listener.Port, listener.Framing, listener.Parser, proxy != nil)
 
 	stopChan := make(chan struct{})
 	done := make(chan struct{})
 
 	go func() {
-		defer tlsListener.Close()
+		defer tcpListener.Close()
 		defer close(done)
 
 		for {
@@ -325,10 +443,8 @@ func (s *Server) startTLSListener(listener ListenerConfig) (func(), error) {
 				return
 			default:
 				// Set deadline for accept to allow checking stopChan
-				if tcpListener, ok := tlsListener.(*net.TCPListener); ok {
-					tcpListener.SetDeadline(time.Now().Add(100 * time.Millisecond))
-				}
-				conn, err := tlsListener.Accept()
+				tcpListener.SetDeadline(time.Now().Add(100 * time.Millisecond))
+				conn, err := tcpListener.AcceptTCP()
 				if err != nil {
 					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 						continue
@@ -339,37 +455,88 @@ func (s *Server) startTLSListener(listener ListenerConfig) (func(), error) {
 					continue
 				}
 
-				go s.handleTLSConnectionWithConfig(conn, listener)
+				go s.handleTLSConnectionWithConfig(conn, listener, parsers, tlsConfig, proxy, stats, filter)
 			}
 		}
 	}()
 
 	stopFunc := func() {
 		close(stopChan)
-		tlsListener.Close()
+		tcpListener.Close()
 		<-done
 	}
 
 	return stopFunc, nil
 }
 
-func (s *Server) handleTLSConnectionWithConfig(conn net.Conn, listener ListenerConfig) {
-	defer conn.Close()
-	remoteAddr := conn.RemoteAddr().String()
+func (s *Server) handleTLSConnectionWithConfig(tcpConn *net.TCPConn, listener ListenerConfig, parsers *ParserChain, tlsConfig *tls.Config, proxy *proxyPolicy, stats *ListenerStats, filter *SourceFilter) {
+	defer tcpConn.Close()
+
+	conn, err := proxy.wrap(tcpConn)
+	if err != nil {
+		log.Printf("TLS connection error: %v", err)
+		return
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	defer tlsConn.Close()
+	remoteAddr := tlsConn.RemoteAddr().String()
+	if !filter.Permits(remoteAddr) {
+		stats.RecordRefusedConnection()
+		log.Printf("Refused TLS connection from %s: not allowed by the listener's access lists", remoteAddr)
+		return
+	}
+
+	connection := stats.OpenConnection(remoteAddr, "TLS")
+	defer stats.CloseConnection(connection)
+	if err := tlsHandshake(tlsConn, connection); err != nil {
+		log.Printf("TLS handshake error from %s: %v", remoteAddr, err)
+		return
+	}
+	identity, deviceID := s.clientIdentity(tlsConn)
 
 	// TLS always uses octet counting (RFC5425)
-	scanner := octetcounting.NewScanner(conn, 65535)
-	defer scanner.Release()
+	s.readOctetCountedFrames(tlsConn, ingestMessage{
+		remoteAddr:     remoteAddr,
+		protocol:       "TLS",
+		parsers:        parsers,
+		listenerID:     listener.ID,
+		deviceID:       deviceID,
+		clientIdentity: identity,
+		connection:     connection,
+		filter:         filter,
+	}, s.processMessage)
+}
 
-	for {
-		token := scanner.Scan()
-		tokenStr := token.String()
+// tlsHandshake completes the handshake of a server connection and records
+// the negotiated version and client certificate on connection
+func tlsHandshake(conn *tls.Conn, connection *ConnectionStats) error {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err := conn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	connection.SetTLSState(conn.ConnectionState())
+	return nil
+}
 
-		// Check if we got a valid token (empty string means EOF or error)
-		if tokenStr == "" {
-			break
+// readOctetCountedFrames processes RFC 6587 octet-counted frames from r
+// until it is closed or sends a malformed frame. Each frame is processed as
+// a copy of template.
+func (s *Server) readOctetCountedFrames(r io.Reader, template ingestMessage, process func(ingestMessage)) {
+	reader := bufio.NewReader(r)
+	for {
+		data, err := readOctetCountedFrame(reader, maxFrameSize)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				template.connection.RecordReadError(err)
+				log.Printf("%s connection error from %s: %v", template.protocol, template.remoteAddr, err)
+			}
+			return
 		}
-
-		s.processMessage([]byte(tokenStr), remoteAddr, "TLS", listener.Parser)
+		msg := template
+		msg.data = data
+		msg.receivedAt = time.Now()
+		process(msg)
 	}
 }
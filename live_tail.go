@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Live tail: fan-out of newly saved logs to streaming subscribers
+
+// liveTailBufferSize is the number of logs buffered per subscriber before
+// it is considered too slow and disconnected
+const liveTailBufferSize = 256
+
+// LogFilter holds the /api/logs filters that can be evaluated in memory
+type LogFilter struct {
+	Severity   *uint8
+	Device     string
+	DeviceID   string
+	ListenerID string
+	DeviceType string
+	EventType  string
+	Search     string
+
+	indexedSearch *searchNode // Set by UseSearchIndex
+}
+
+// UseSearchIndex makes Search match the way the full-text index does
+// (phrases, prefixes and operators) instead of as a substring. Call it when
+// /api/logs would search the index for the same filter.
+func (f *LogFilter) UseSearchIndex() {
+	f.indexedSearch = compileSearch(f.Search)
+}
+
+// Matches reports whether entry passes the filter. Device matches a
+// case-insensitive substring, like the LIKE filters in GetLogsWithCustomDate;
+// search does too unless UseSearchIndex was called.
+func (f *LogFilter) Matches(entry *LogEntry) bool {
+	if f.Severity != nil && entry.Severity != *f.Severity {
+		return false
+	}
+	if f.DeviceID != "" && entry.DeviceID != f.DeviceID {
+		return false
+	}
+	if f.ListenerID != "" && entry.ListenerID != f.ListenerID {
+		return false
+	}
+	if f.DeviceType != "" && entry.DeviceType != f.DeviceType {
+		return false
+	}
+	if f.EventType != "" && entry.EventType != f.EventType {
+		return false
+	}
+	if f.Device != "" && !containsFold(f.Device, entry.Hostname, entry.RemoteAddr, parsedFieldsText(entry)) {
+		return false
+	}
+	if f.Search != "" {
+		values := []string{entry.RawMessage, entry.Message, entry.Hostname, entry.AppName,
+			entry.DeviceType, entry.EventType, entry.EventCategory, parsedFieldsText(entry)}
+		if f.indexedSearch != nil && !f.indexedSearch.Matches(values...) {
+			return false
+		}
+		if f.indexedSearch == nil && !containsFold(f.Search, values...) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether any of values contains substr, ignoring case
+func containsFold(substr string, values ...string) bool {
+	substr = strings.ToLower(substr)
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsedFieldsText renders parsed fields the way they are stored in the database
+func parsedFieldsText(entry *LogEntry) string {
+	if len(entry.ParsedFields) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(entry.ParsedFields)
+	return string(data)
+}
+
+// liveTailEvent is a log encoded once and shared by all subscribers
+type liveTailEvent struct {
+	ID   int64
+	Data []byte
+}
+
+// LogSubscriber receives logs matching its filter on Events. Dropped is
+// closed if the subscriber falls behind and has been removed.
+type LogSubscriber struct {
+	filter  LogFilter
+	Events  chan liveTailEvent
+	Dropped chan struct{}
+}
+
+// LogBroadcaster delivers saved logs to live tail subscribers
+type LogBroadcaster struct {
+	mu          sync.RWMutex
+	subscribers map[*LogSubscriber]struct{}
+}
+
+func NewLogBroadcaster() *LogBroadcaster {
+	return &LogBroadcaster{
+		subscribers: make(map[*LogSubscriber]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber for logs matching filter
+func (b *LogBroadcaster) Subscribe(filter LogFilter) *LogSubscriber {
+	sub := &LogSubscriber{
+		filter:  filter,
+		Events:  make(chan liveTailEvent, liveTailBufferSize),
+		Dropped: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber. Safe to call after it was dropped.
+func (b *LogBroadcaster) Unsubscribe(sub *LogSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// Count returns the number of connected subscribers
+func (b *LogBroadcaster) Count() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// Publish sends committed logs to matching subscribers without blocking. A
+// subscriber whose buffer is full is dropped rather than slowing ingest.
+func (b *LogBroadcaster) Publish(batch []PendingLog) {
+	b.mu.RLock()
+	if len(b.subscribers) == 0 {
+		b.mu.RUnlock()
+		return
+	}
+
+	var slow []*LogSubscriber
+	for _, pending := range batch {
+		entry := pending.Entry
+		var event *liveTailEvent
+		for sub := range b.subscribers {
+			if !sub.filter.Matches(entry) {
+				continue
+			}
+			if event == nil {
+				data, err := json.Marshal(entry)
+				if err != nil {
+					break
+				}
+				event = &liveTailEvent{ID: entry.ID, Data: data}
+			}
+
+			select {
+			case sub.Events <- *event:
+			default:
+				slow = append(slow, sub)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	for _, sub := range slow {
+		// A subscriber can appear more than once in slow
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub.Dropped)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// formatSSE formats a server-sent event
+func formatSSE(event string, id int64, data []byte) string {
+	if id > 0 {
+		return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+}
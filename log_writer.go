@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Batched log writer: a single goroutine that commits logs in transactions
+
+const (
+	defaultWriteBatchSize     = 500
+	defaultWriteFlushInterval = 250 * time.Millisecond
+	writeMaxAttempts          = 3
+)
+
+// PendingLog is a log entry waiting to be written to the database
+type PendingLog struct {
+	Entry     *LogEntry
+	Protocol  string
+	RFCFormat string
+}
+
+// LogWriter collects log entries and writes them in batches, flushing when
+// the batch is full or the flush interval elapses, whichever comes first.
+type LogWriter struct {
+	db            *Database
+	entries       chan PendingLog
+	batchSize     int
+	flushInterval time.Duration
+	onCommit      func([]PendingLog)
+	done          chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewLogWriter creates a writer and starts its goroutine. onCommit (optional)
+// is called from the writer goroutine with each batch after it is committed.
+func NewLogWriter(db *Database, batchSize int, flushInterval time.Duration, onCommit func([]PendingLog)) *LogWriter {
+	if batchSize <= 0 {
+		batchSize = defaultWriteBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultWriteFlushInterval
+	}
+
+	w := &LogWriter{
+		db: db,
+		// Room for a few batches so producers rarely block while a commit is in progress
+		entries:       make(chan PendingLog, batchSize*4),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		onCommit:      onCommit,
+		done:          make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+// Enqueue queues an entry for writing. It blocks while the queue is full,
+// which pushes back on the ingest stage instead of growing memory.
+func (w *LogWriter) Enqueue(entry *LogEntry, protocol, rfcFormat string) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return fmt.Errorf("log writer is closed")
+	}
+
+	w.entries <- PendingLog{Entry: entry, Protocol: protocol, RFCFormat: rfcFormat}
+	return nil
+}
+
+// Close flushes any pending entries and stops the writer. Safe to call more than once.
+func (w *LogWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+func (w *LogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]PendingLog, 0, w.batchSize)
+	for {
+		select {
+		case pending, ok := <-w.entries:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = make([]PendingLog, 0, w.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = make([]PendingLog, 0, w.batchSize)
+			}
+		}
+	}
+}
+
+// flush writes a batch, retrying transient failures (e.g. a locked database)
+func (w *LogWriter) flush(batch []PendingLog) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	for attempt := 1; attempt <= writeMaxAttempts; attempt++ {
+		if err = w.db.InsertLogBatch(batch); err == nil {
+			break
+		}
+		if attempt < writeMaxAttempts {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+	for _, pending := range batch {
+		pending.Entry.saved(err)
+	}
+	if err != nil {
+		log.Printf("Failed to save %d logs: %v", len(batch), err)
+		return
+	}
+
+	if w.onCommit != nil {
+		w.onCommit(batch)
+	}
+}
@@ -14,41 +14,55 @@ import (
 
 // Message processing functions
 
-func (s *Server) processMessage(data []byte, remoteAddr, protocol, rfcFormat string) {
+// processMessage parses a received frame and saves it. The frame is kept
+// verbatim as the log's raw message; device modules see the message body.
+func (s *Server) processMessage(msg ingestMessage) {
+	data, remoteAddr, protocol := msg.data, msg.remoteAddr, msg.protocol
+
+	// Access lists and rate limits are checked before parsing
+	if !msg.filter.Admit(msg) {
+		// Discarding is the configured outcome, not a failure to store
+		if msg.onSaved != nil {
+			msg.onSaved(nil)
+		}
+		return
+	}
+
 	// Log received message for debugging
-	log.Printf("Received message from %s (%d bytes): %s", remoteAddr, len(data), string(data)[:min(len(data), 100)])
+	log.Printf("Received message from %s (%d bytes): %s", remoteAddr, msg.size(), string(data)[:min(len(data), 100)])
+
+	stats := s.listenerStatsFor(msg.listenerID)
+	stats.RecordMessage(msg.connection, msg.size())
 
-	// Try RFC5424 first
-	if s.rfc5424Parser != nil {
-		parser, ok := s.rfc5424Parser.(interface {
-			Parse([]byte) (interface{}, error)
-		})
+	if msg.parsers != nil {
+		parsed, format, ok := msg.parsers.Parse(data)
 		if ok {
-			msg, err := parser.Parse(data)
-			if err == nil {
-				if rfc5424Msg, ok := msg.(*rfc5424.SyslogMessage); ok && rfc5424Msg != nil {
-					entry := s.messageToEntry(rfc5424Msg, remoteAddr, protocol, "RFC5424")
-					s.saveLog(entry, protocol, "RFC5424")
-					return
-				}
+			var entry *LogEntry
+			switch m := parsed.(type) {
+			case *rfc5424.SyslogMessage:
+				entry = s.messageToEntry(m, remoteAddr, protocol, format)
+			case *rfc3164.SyslogMessage:
+				entry = s.rfc3164ToEntry(m, remoteAddr, protocol)
+			}
+			if entry != nil {
+				entry.Message = joinContinuation(entry.Message, msg.continuation)
+				setReceiveInfo(entry, msg)
+				s.saveLog(entry, protocol, format)
+				return
 			}
 		}
-	}
 
-	// Try RFC3164
-	if s.rfc3164Parser != nil {
-		parser, ok := s.rfc3164Parser.(interface {
-			Parse([]byte) (interface{}, error)
-		})
-		if ok {
-			msg, err := parser.Parse(data)
-			if err == nil {
-				if rfc3164Msg, ok := msg.(*rfc3164.SyslogMessage); ok && rfc3164Msg != nil {
-					entry := s.rfc3164ToEntry(rfc3164Msg, remoteAddr, protocol)
-					s.saveLog(entry, protocol, "RFC3164")
-					return
-				}
+		if msg.parsers.HasParsers() {
+			stats.RecordParseFailure()
+		}
+
+		if msg.parsers.DropUnparsed() {
+			log.Printf("Dropped message from %s: not accepted by the listener's parser", remoteAddr)
+			// Discarding is the configured outcome, not a failure to store
+			if msg.onSaved != nil {
+				msg.onSaved(nil)
 			}
+			return
 		}
 	}
 
@@ -56,13 +70,13 @@ func (s *Server) processMessage(data []byte, remoteAddr, protocol, rfcFormat str
 	entry := &LogEntry{
 		Timestamp:      time.Now(),
 		RemoteAddr:     remoteAddr,
-		RawMessage:     string(data),
 		StructuredData: make(map[string]map[string]string),
 		ParsedFields:   make(map[string]interface{}),
 		Severity:       6,   // Default to Informational if not parsed
 		Priority:       165, // Default priority (local0.notice)
 		Facility:       20,  // local0
 	}
+	setReceiveInfo(entry, msg)
 
 	// Try to extract priority from raw message if it starts with <PRI>
 	if len(data) > 0 && data[0] == '<' {
@@ -89,11 +103,27 @@ func (s *Server) processMessage(data []byte, remoteAddr, protocol, rfcFormat str
 	s.saveLog(entry, protocol, "UNKNOWN")
 }
 
+// setReceiveInfo records the original frame and where and when it arrived.
+// Frames joined by multiline rules follow it, one per line.
+func setReceiveInfo(entry *LogEntry, msg ingestMessage) {
+	entry.RawMessage = string(msg.data)
+	for _, line := range msg.continuation {
+		entry.RawMessage += "\n" + string(line.raw)
+	}
+	entry.ListenerID = msg.listenerID
+	entry.DeviceID = msg.deviceID
+	entry.ClientIdentity = msg.clientIdentity
+	entry.ReceivedAt = msg.receivedAt
+	entry.onSaved = msg.onSaved
+	if entry.ReceivedAt.IsZero() {
+		entry.ReceivedAt = time.Now()
+	}
+}
+
 func (s *Server) messageToEntry(message *rfc5424.SyslogMessage, remoteAddr, protocol, rfcFormat string) *LogEntry {
 	entry := &LogEntry{
 		Timestamp:      time.Now(),
 		RemoteAddr:     remoteAddr,
-		RawMessage:     "",
 		StructuredData: make(map[string]map[string]string),
 		ParsedFields:   make(map[string]interface{}),
 	}
@@ -127,15 +157,8 @@ func (s *Server) messageToEntry(message *rfc5424.SyslogMessage, remoteAddr, prot
 		entry.Timestamp = *message.Timestamp
 	}
 
-	// Reconstruct raw message for device matching and parsing
-	if message.Message != nil {
-		entry.RawMessage = *message.Message
-	} else {
-		entry.RawMessage = ""
-	}
-
-	// Try to parse with device modules (but this will be overridden in saveLog if device IP matches)
-	parsed := modules.GetRegistry().ParseLog(entry.RawMessage, entry.Timestamp, entry.Severity, entry.Priority)
+	// Try to parse the message body with device modules (but this will be overridden in saveLog if device IP matches)
+	parsed := modules.GetRegistry().ParseLog(entry.Message, entry.Timestamp, entry.Severity, entry.Priority)
 	if parsed.DeviceType != "unknown" {
 		entry.DeviceType = parsed.DeviceType
 		entry.EventType = parsed.EventType
@@ -150,7 +173,6 @@ func (s *Server) rfc3164ToEntry(message *rfc3164.SyslogMessage, remoteAddr, prot
 	entry := &LogEntry{
 		Timestamp:      time.Now(),
 		RemoteAddr:     remoteAddr,
-		RawMessage:     "",
 		StructuredData: make(map[string]map[string]string),
 		Version:        0, // RFC3164 doesn't have version
 	}
@@ -177,17 +199,10 @@ func (s *Server) rfc3164ToEntry(message *rfc3164.SyslogMessage, remoteAddr, prot
 		entry.Timestamp = *message.Timestamp
 	}
 
-	// Reconstruct raw message for device matching and parsing
-	if message.Message != nil {
-		entry.RawMessage = *message.Message
-	} else {
-		entry.RawMessage = ""
-	}
-
 	entry.ParsedFields = make(map[string]interface{})
 
-	// Try to parse with device modules (but this will be overridden in saveLog if device IP matches)
-	parsed := modules.GetRegistry().ParseLog(entry.RawMessage, entry.Timestamp, entry.Severity, entry.Priority)
+	// Try to parse the message body with device modules (but this will be overridden in saveLog if device IP matches)
+	parsed := modules.GetRegistry().ParseLog(entry.Message, entry.Timestamp, entry.Severity, entry.Priority)
 	if parsed.DeviceType != "unknown" {
 		entry.DeviceType = parsed.DeviceType
 		entry.EventType = parsed.EventType
@@ -199,56 +214,53 @@ func (s *Server) rfc3164ToEntry(message *rfc3164.SyslogMessage, remoteAddr, prot
 }
 
 func (s *Server) saveLog(entry *LogEntry, protocol, rfcFormat string) {
-	// Only accept messages from configured devices
-	if s.config.Devices == nil || len(s.config.Devices) == 0 {
-		// No devices configured - reject all messages
-		log.Printf("Rejected message from %s: No devices configured", entry.RemoteAddr)
-		return
-	}
-
 	if entry.RemoteAddr == "" {
 		log.Printf("Warning: message has no remote address, skipping")
+		entry.saved(nil)
 		return
 	}
 
-	// Extract IP from remote address (format: "ip:port")
-	remoteIP := strings.Split(entry.RemoteAddr, ":")[0]
-
-	// Find device that matches this IP and has an assigned listener
+	// Find the device assigned to this sender (IP/CIDR, then hostname).
+	// Listeners bound to one device (UNIX sockets) set it up front.
 	var matchedDevice *DeviceConfig
-	if s.config.Devices != nil {
-		for i := range s.config.Devices {
-			device := &s.config.Devices[i]
-			// Check if device has an assigned listener and its IP matches
-			if device.ListenerID != "" {
-				for _, deviceIP := range device.IPAddresses {
-					if deviceIP == remoteIP {
-						matchedDevice = device
-						break
-					}
-				}
-			}
-			if matchedDevice != nil {
-				break
-			}
-		}
+	if entry.DeviceID != "" {
+		matchedDevice = s.devices.ByID(entry.DeviceID)
+	} else {
+		matchedDevice = s.devices.Match(entry.RemoteAddr, entry.Hostname)
 	}
 
-	// If no device is configured, or no matching device with an assigned listener, reject the message
+	// Messages from unknown senders are held in quarantine until the sender is adopted as a device
 	if matchedDevice == nil {
-		log.Printf("Rejected message from %s: No configured device with matching IP and active listener. Raw: %s", entry.RemoteAddr, entry.RawMessage[:min(len(entry.RawMessage), 100)])
-		return // Do not save the log
+		log.Printf("Quarantined message from %s: No configured device with matching address or hostname and active listener. Raw: %s", entry.RemoteAddr, entry.RawMessage[:min(len(entry.RawMessage), 100)])
+		s.listenerStatsFor(entry.ListenerID).RecordRejected()
+		s.quarantine.Add(entry, protocol, rfcFormat)
+		return
 	}
 
-	// If device found, use its configured device type
-	configuredDeviceType := matchedDevice.DeviceType
+	s.applyDevice(entry, matchedDevice)
+
+	if err := s.writer.Enqueue(entry, protocol, rfcFormat); err != nil {
+		log.Printf("Failed to save log: %v", err)
+		entry.saved(err)
+		return
+	}
+	s.health.Record(entry, protocol, rfcFormat)
+}
+
+// applyDevice attributes the entry to the device it came from, sets its
+// device type and module fields and applies severity overrides
+func (s *Server) applyDevice(entry *LogEntry, device *DeviceConfig) {
+	entry.DeviceID = device.ID
+
+	// Use the device's configured device type
+	configuredDeviceType := device.DeviceType
 	entry.DeviceType = configuredDeviceType
 
 	// Only re-parse with device modules if device type is "generic"
 	// This allows generic devices to benefit from module parsing
 	// but prevents modules from overriding configured device types
 	if configuredDeviceType == "generic" {
-		parsed := modules.GetRegistry().ParseLog(entry.RawMessage, entry.Timestamp, entry.Severity, entry.Priority)
+		parsed := modules.GetRegistry().ParseLog(entry.Body(), entry.Timestamp, entry.Severity, entry.Priority)
 		if parsed.DeviceType != "unknown" {
 			entry.DeviceType = parsed.DeviceType
 			entry.EventType = parsed.EventType
@@ -269,20 +281,25 @@ func (s *Server) saveLog(entry *LogEntry, protocol, rfcFormat string) {
 			entry.Priority = entry.Facility*8 + overrideSeverity
 		}
 	}
+}
 
-	if err := s.db.InsertLog(entry, protocol, rfcFormat); err != nil {
-		log.Printf("Failed to save log: %v", err)
-		return
-	}
-
-	log.Printf("Saved log: %s (Device: %s, Event: %s)", entry.RawMessage[:min(len(entry.RawMessage), 50)], entry.DeviceType, entry.EventType)
-
+// logsCommitted is called by the log writer after a batch has been committed
+func (s *Server) logsCommitted(batch []PendingLog) {
 	// Update stats
 	s.stats.mu.Lock()
-	s.stats.TotalMessages++
-	s.stats.MessagesByRFC[rfcFormat]++
-	s.stats.MessagesByProto[protocol]++
+	for _, pending := range batch {
+		s.stats.TotalMessages++
+		s.stats.MessagesByRFC[pending.RFCFormat]++
+		s.stats.MessagesByProto[pending.Protocol]++
+	}
 	s.stats.LastMessageTime = time.Now()
 	s.stats.mu.Unlock()
-}
 
+	// Push to live tail subscribers
+	s.liveTail.Publish(batch)
+
+	// Queue for upstream syslog targets
+	s.forwarder.Publish(batch)
+
+	s.evaluateAlerts(batch)
+}
@@ -17,10 +17,36 @@ type LogEntry struct {
 	StructuredData map[string]map[string]string `json:"structured_data"`
 	RawMessage     string                       `json:"raw_message"`
 	RemoteAddr     string                       `json:"remote_addr"`
+	ListenerID     string                       `json:"listener_id"`
+	ReceivedAt     time.Time                    `json:"received_at"`
+	DeviceID       string                       `json:"device_id"`                 // Configured device the log was matched to
+	DeviceName     string                       `json:"device_name,omitempty"`     // Current name of DeviceID, filled in by the API
+	ClientIdentity string                       `json:"client_identity,omitempty"` // Client certificate identity the log was received with
 	DeviceType     string                       `json:"device_type"`
 	EventType      string                       `json:"event_type"`
 	EventCategory  string                       `json:"event_category"`
 	ParsedFields   map[string]interface{}       `json:"parsed_fields"`
+
+	// onSaved, if set, is called once the entry has been committed to the
+	// database (err == nil) or could not be stored
+	onSaved func(err error)
+}
+
+// Body returns the message text device modules parse: the syslog MSG part,
+// or the whole frame for messages that couldn't be parsed as syslog
+func (s *LogEntry) Body() string {
+	if s.Message != "" {
+		return s.Message
+	}
+	return s.RawMessage
+}
+
+// saved reports the outcome of storing the entry to its onSaved callback
+func (s *LogEntry) saved(err error) {
+	if s.onSaved != nil {
+		s.onSaved(err)
+		s.onSaved = nil
+	}
 }
 
 func (s *LogEntry) GetSeverityName() string {
@@ -51,6 +77,32 @@ func (s *LogEntry) GetSeverityColor() string {
 	return severityColors[s.Severity]
 }
 
+// Matches reports whether entry passes the LogMatch conditions
+func (m *LogMatch) Matches(entry *LogEntry) bool {
+	if len(m.Severities) > 0 {
+		found := false
+		for _, sev := range m.Severities {
+			if sev == int(entry.Severity) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.DeviceType != "" && entry.DeviceType != m.DeviceType {
+		return false
+	}
+	if m.EventType != "" && entry.EventType != m.EventType {
+		return false
+	}
+	if m.Device != "" && !containsFold(m.Device, entry.Hostname, entry.RemoteAddr) {
+		return false
+	}
+	return true
+}
+
 // User represents a user account
 type User struct {
 	ID           int64  `json:"id"`
@@ -75,3 +127,55 @@ type LoginAttempt struct {
 	Success   bool   `json:"success"`
 	CreatedAt string `json:"created_at"`
 }
+
+// Alert is a fired alert rule
+type Alert struct {
+	ID           int64     `json:"id"`
+	RuleID       string    `json:"rule_id"`
+	RuleName     string    `json:"rule_name"`
+	GroupBy      string    `json:"group_by,omitempty"`
+	GroupValue   string    `json:"group_value,omitempty"`
+	Count        int       `json:"count"`   // Matching logs in the window when the rule fired
+	Message      string    `json:"message"` // Human readable summary
+	LogIDs       []int64   `json:"log_ids"` // Triggering logs (most recent, capped)
+	FiredAt      time.Time `json:"fired_at"`
+	Acknowledged bool      `json:"acknowledged"`
+
+	channels []string // Notification channels of the rule that fired
+}
+
+// UnknownSender summarizes messages from a source that matches no device
+type UnknownSender struct {
+	SourceIP            string    `json:"source_ip"`
+	MessageCount        int64     `json:"message_count"`
+	FirstSeen           time.Time `json:"first_seen"`
+	LastSeen            time.Time `json:"last_seen"`
+	Hostname            string    `json:"hostname,omitempty"`
+	ListenerID          string    `json:"listener_id,omitempty"`
+	SuggestedDeviceType string    `json:"suggested_device_type"`
+	Quarantined         int64     `json:"quarantined"` // Messages currently held for this sender
+}
+
+// QuarantinedLog is a message held until its sender is adopted as a device
+type QuarantinedLog struct {
+	ID                  int64     `json:"id"`
+	SourceIP            string    `json:"source_ip"`
+	Protocol            string    `json:"protocol"`
+	RFCFormat           string    `json:"rfc_format"`
+	Entry               *LogEntry `json:"entry"`
+	SuggestedDeviceType string    `json:"-"`
+}
+
+// IssuedCertificate is a certificate issued by the internal CA
+type IssuedCertificate struct {
+	Serial      string     `json:"serial"` // Hex
+	Kind        string     `json:"kind"`   // "server" or "client"
+	Subject     string     `json:"subject"`
+	ListenerID  string     `json:"listener_id,omitempty"` // Server certificates
+	DeviceID    string     `json:"device_id,omitempty"`   // Client certificates
+	Fingerprint string     `json:"fingerprint"`           // SHA-256, lowercase hex
+	NotBefore   time.Time  `json:"not_before"`
+	NotAfter    time.Time  `json:"not_after"`
+	IssuedAt    time.Time  `json:"issued_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	syslog "github.com/leodido/go-syslog/v4"
+	"github.com/leodido/go-syslog/v4/rfc5424"
+)
+
+// Multi-line reassembly for TCP streams: frames that continue the previous
+// message, such as the lines of a stack trace sent one per frame, are joined
+// to it and stored as one log
+
+const (
+	defaultMultilineMaxLines     = 500
+	defaultMultilineFlushTimeout = time.Second
+	maxMultilineFlushTimeoutMs   = 60000
+	maxMultilineSize             = 256 * 1024 // Bytes joined into one log; longer messages are split
+)
+
+// validateListenerMultiline checks a listener's multiline rules
+func validateListenerMultiline(listener *ListenerConfig) error {
+	if listener.Multiline != nil && listener.Protocol != "TCP" {
+		return errors.New("multiline is only supported for tcp listeners")
+	}
+	return validateMultiline(listener.Multiline)
+}
+
+// validateMultiline checks listener or device multiline rules
+func validateMultiline(multiline *MultilineConfig) error {
+	if multiline == nil {
+		return nil
+	}
+	if multiline.ContinuationPattern == "" && !multiline.LeadingWhitespace {
+		return errors.New("multiline requires continuation_pattern or leading_whitespace")
+	}
+	if multiline.ContinuationPattern != "" {
+		if _, err := regexp.Compile(multiline.ContinuationPattern); err != nil {
+			return fmt.Errorf("invalid multiline continuation_pattern: %w", err)
+		}
+	}
+	if multiline.MaxLines < 0 {
+		return errors.New("multiline max_lines must not be negative")
+	}
+	if multiline.FlushTimeoutMs < 0 || multiline.FlushTimeoutMs > maxMultilineFlushTimeoutMs {
+		return fmt.Errorf("multiline flush_timeout_ms must be between 0 and %d", maxMultilineFlushTimeoutMs)
+	}
+	return nil
+}
+
+// continuationLine is a frame joined to the message before it
+type continuationLine struct {
+	raw  []byte // The frame as received
+	body string // Its message, without the syslog header
+}
+
+// multilineRules decide which frames continue the previous message
+type multilineRules struct {
+	continuation      *regexp.Regexp
+	leadingWhitespace bool
+	maxLines          int
+	flushTimeout      time.Duration
+}
+
+// newMultilineRules compiles multiline settings. It returns nil for nil settings.
+func newMultilineRules(multiline *MultilineConfig) (*multilineRules, error) {
+	if multiline == nil {
+		return nil, nil
+	}
+	if err := validateMultiline(multiline); err != nil {
+		return nil, err
+	}
+
+	rules := &multilineRules{
+		leadingWhitespace: multiline.LeadingWhitespace,
+		maxLines:          multiline.MaxLines,
+		flushTimeout:      time.Duration(multiline.FlushTimeoutMs) * time.Millisecond,
+	}
+	if multiline.ContinuationPattern != "" {
+		rules.continuation = regexp.MustCompile(multiline.ContinuationPattern)
+	}
+	if rules.maxLines == 0 {
+		rules.maxLines = defaultMultilineMaxLines
+	}
+	if rules.flushTimeout == 0 {
+		rules.flushTimeout = defaultMultilineFlushTimeout
+	}
+	return rules, nil
+}
+
+// continues reports whether a message body continues the previous message
+func (r *multilineRules) continues(body string) bool {
+	if r.leadingWhitespace && body != "" && (body[0] == ' ' || body[0] == '\t') {
+		return true
+	}
+	return r.continuation != nil && r.continuation.MatchString(body)
+}
+
+// multilineRulesFor returns the multiline rules for a connection: those of
+// the sender's device if it has its own, otherwise the listener's. nil
+// means frames are processed one by one.
+func (s *Server) multilineRulesFor(listener ListenerConfig, remoteAddr string) *multilineRules {
+	multiline := listener.Multiline
+	if device := s.devices.Match(remoteAddr, ""); device != nil && device.Multiline != nil {
+		multiline = device.Multiline
+	}
+	rules, err := newMultilineRules(multiline)
+	if err != nil {
+		log.Printf("Ignoring multiline rules of listener %s for %s: %v", listener.Name, remoteAddr, err)
+		return nil
+	}
+	return rules
+}
+
+// multilineJoiner collects the frames of one connection into messages and
+// passes each complete message to emit. A message is complete when a frame
+// that doesn't continue it arrives, it reaches max_lines, or no frame
+// arrives within the flush timeout.
+type multilineJoiner struct {
+	rules   *multilineRules
+	emit    func(ingestMessage)
+	rfc5424 syslog.Machine // Finds the message of RFC 5424 frames
+
+	mu         sync.Mutex
+	pending    *ingestMessage
+	size       int
+	generation int // Increased by every frame, so a stale timer doesn't flush a newer message
+	timer      *time.Timer
+	closed     bool
+}
+
+func newMultilineJoiner(rules *multilineRules, emit func(ingestMessage)) *multilineJoiner {
+	return &multilineJoiner{
+		rules:   rules,
+		emit:    emit,
+		rfc5424: rfc5424.NewParser(rfc5424.WithBestEffort()),
+	}
+}
+
+// Add handles a frame. The frame's data is copied, so the caller may reuse it.
+func (j *multilineJoiner) Add(msg ingestMessage) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		j.emit(msg)
+		return
+	}
+
+	data := bytes.Clone(msg.data)
+	if j.pending != nil && j.size+len(data) < maxMultilineSize {
+		if body := j.body(data); j.rules.continues(body) {
+			j.pending.continuation = append(j.pending.continuation, continuationLine{raw: data, body: body})
+			j.size += len(data) + 1
+			if 1+len(j.pending.continuation) >= j.rules.maxLines {
+				j.flushLocked()
+				return
+			}
+			j.scheduleFlush()
+			return
+		}
+	}
+
+	j.flushLocked()
+	msg.data = data
+	j.pending = &msg
+	j.size = len(data)
+	j.scheduleFlush()
+}
+
+// Close emits the message being collected. Frames added afterwards are
+// passed on immediately.
+func (j *multilineJoiner) Close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	j.flushLocked()
+}
+
+// scheduleFlush restarts the flush timeout. Called with j.mu held.
+func (j *multilineJoiner) scheduleFlush() {
+	j.generation++
+	generation := j.generation
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.timer = time.AfterFunc(j.rules.flushTimeout, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.generation == generation {
+			j.flushLocked()
+		}
+	})
+}
+
+// flushLocked emits the message being collected, if any. Called with j.mu held.
+func (j *multilineJoiner) flushLocked() {
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	if j.pending == nil {
+		return
+	}
+	msg := *j.pending
+	j.pending = nil
+	j.size = 0
+	j.emit(msg)
+}
+
+// body returns the message of a frame that continuation rules are matched
+// against. RFC 3164 parsing stops at a tab, which starts many stack trace
+// lines, so its header is skipped here rather than parsed.
+func (j *multilineJoiner) body(data []byte) string {
+	if detectSyslogFormat(data) == parserRFC5424 {
+		if msg, _ := j.rfc5424.Parse(data); msg != nil {
+			if message := msg.(*rfc5424.SyslogMessage).Message; message != nil {
+				return *message
+			}
+			return ""
+		}
+		return string(data)
+	}
+	return string(rfc3164Body(data))
+}
+
+// rfc3164Body skips the PRI, TIMESTAMP, HOSTNAME and TAG of an RFC 3164
+// frame. Frames without a PRI are returned whole.
+func rfc3164Body(data []byte) []byte {
+	if len(data) < 3 || data[0] != '<' {
+		return data
+	}
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return data
+	}
+	rest := data[end+1:]
+
+	// "Mmm dd hh:mm:ss "
+	if len(rest) >= 16 && rest[3] == ' ' && rest[6] == ' ' && rest[9] == ':' && rest[12] == ':' && rest[15] == ' ' {
+		rest = rest[16:]
+	}
+	header := rest
+
+	// The message follows the tag, the first word ending in ':' (the hostname may come before it)
+	for words := 0; words < 2; words++ {
+		end := bytes.IndexAny(rest, " \t")
+		if end < 0 {
+			break
+		}
+		if end > 0 && rest[end-1] == ':' {
+			body := rest[end:]
+			if body[0] == ' ' {
+				body = body[1:]
+			}
+			return body
+		}
+		rest = rest[end+1:]
+	}
+	return header
+}
+
+// joinContinuation appends the bodies of continuation lines to a message
+func joinContinuation(message string, lines []continuationLine) string {
+	if len(lines) == 0 {
+		return message
+	}
+	var b strings.Builder
+	b.WriteString(message)
+	for _, line := range lines {
+		b.WriteByte('\n')
+		b.WriteString(line.body)
+	}
+	return b.String()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMultilineRulesContinues matches continuation lines by pattern and
+// leading whitespace
+func TestMultilineRulesContinues(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MultilineConfig
+		body   string
+		want   bool
+	}{
+		{name: "tab", config: MultilineConfig{LeadingWhitespace: true}, body: "\tat com.example.Main.run(Main.java:10)", want: true},
+		{name: "space", config: MultilineConfig{LeadingWhitespace: true}, body: "  File \"app.py\", line 3", want: true},
+		{name: "no whitespace", config: MultilineConfig{LeadingWhitespace: true}, body: "Exception in thread main", want: false},
+		{name: "empty body", config: MultilineConfig{LeadingWhitespace: true}, body: "", want: false},
+		{name: "whitespace off", config: MultilineConfig{ContinuationPattern: `^Caused by`}, body: "\tat x", want: false},
+		{name: "pattern", config: MultilineConfig{ContinuationPattern: `^(Caused by|\.\.\. \d+ more)`}, body: "... 12 more", want: true},
+		{name: "pattern not matched", config: MultilineConfig{ContinuationPattern: `^Caused by`}, body: "Started", want: false},
+		{name: "either rule", config: MultilineConfig{ContinuationPattern: `^Caused by`, LeadingWhitespace: true}, body: "Caused by: x", want: true},
+	}
+
+	for _, tt := range tests {
+		rules, err := newMultilineRules(&tt.config)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := rules.continues(tt.body); got != tt.want {
+			t.Errorf("%s: continues(%q) = %t, want %t", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+// TestValidateMultiline rejects unusable settings
+func TestValidateMultiline(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *MultilineConfig
+		errText string // "" for valid
+	}{
+		{name: "unset", config: nil},
+		{name: "whitespace", config: &MultilineConfig{LeadingWhitespace: true}},
+		{name: "pattern", config: &MultilineConfig{ContinuationPattern: `^\s`, MaxLines: 10, FlushTimeoutMs: 500}},
+		{name: "no rule", config: &MultilineConfig{MaxLines: 10}, errText: "requires continuation_pattern or leading_whitespace"},
+		{name: "bad pattern", config: &MultilineConfig{ContinuationPattern: `(`}, errText: "invalid multiline continuation_pattern"},
+		{name: "negative max_lines", config: &MultilineConfig{LeadingWhitespace: true, MaxLines: -1}, errText: "max_lines"},
+		{name: "negative timeout", config: &MultilineConfig{LeadingWhitespace: true, FlushTimeoutMs: -1}, errText: "flush_timeout_ms"},
+		{name: "long timeout", config: &MultilineConfig{LeadingWhitespace: true, FlushTimeoutMs: maxMultilineFlushTimeoutMs + 1}, errText: "flush_timeout_ms"},
+	}
+	for _, tt := range tests {
+		err := validateMultiline(tt.config)
+		if tt.errText == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.errText != "" && (err == nil || !strings.Contains(err.Error(), tt.errText)) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.errText)
+		}
+	}
+
+	if err := validateListenerMultiline(&ListenerConfig{Protocol: "UDP", Multiline: &MultilineConfig{LeadingWhitespace: true}}); err == nil {
+		t.Errorf("multiline on a UDP listener was accepted")
+	}
+}
+
+// testJoiner returns a joiner whose messages are sent to the returned channel
+func testJoiner(t *testing.T, config MultilineConfig) (*multilineJoiner, chan ingestMessage) {
+	t.Helper()
+	rules, err := newMultilineRules(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitted := make(chan ingestMessage, 100)
+	return newMultilineJoiner(rules, func(msg ingestMessage) { emitted <- msg }), emitted
+}
+
+// joinedLines returns the first frame of msg followed by its continuation bodies
+func joinedLines(msg ingestMessage) []string {
+	lines := []string{string(msg.data)}
+	for _, line := range msg.continuation {
+		lines = append(lines, line.body)
+	}
+	return lines
+}
+
+// receive waits for the next emitted message
+func receive(t *testing.T, emitted chan ingestMessage) []string {
+	t.Helper()
+	select {
+	case msg := <-emitted:
+		return joinedLines(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message was emitted")
+		return nil
+	}
+}
+
+func addFrames(j *multilineJoiner, frames ...string) {
+	for _, frame := range frames {
+		j.Add(ingestMessage{data: []byte(frame)})
+	}
+}
+
+// TestMultilineJoiner joins continuation frames and cuts messages at
+// max_lines and the size limit
+func TestMultilineJoiner(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MultilineConfig
+		frames []string
+		want   [][]string // Message bodies as emitted, including by Close
+	}{
+		{
+			name:   "stack trace",
+			config: MultilineConfig{LeadingWhitespace: true, ContinuationPattern: `^Caused by`},
+			frames: []string{"Exception in main", "\tat a", "\tat b", "Caused by: io", "\tat c", "next message"},
+			want:   [][]string{{"Exception in main", "\tat a", "\tat b", "Caused by: io", "\tat c"}, {"next message"}},
+		},
+		{
+			name:   "nothing to join",
+			config: MultilineConfig{LeadingWhitespace: true},
+			frames: []string{"one", "two"},
+			want:   [][]string{{"one"}, {"two"}},
+		},
+		{
+			name:   "continuation without a message",
+			config: MultilineConfig{LeadingWhitespace: true},
+			frames: []string{"\torphan", "\tat a"},
+			want:   [][]string{{"\torphan", "\tat a"}},
+		},
+		{
+			name:   "max_lines",
+			config: MultilineConfig{LeadingWhitespace: true, MaxLines: 3},
+			frames: []string{"first", "\t1", "\t2", "\t3", "\t4", "next"},
+			want:   [][]string{{"first", "\t1", "\t2"}, {"\t3", "\t4"}, {"next"}},
+		},
+		{
+			name:   "RFC 3164 frames",
+			config: MultilineConfig{LeadingWhitespace: true},
+			frames: []string{"<11>Oct 11 22:14:15 host app: Traceback", "<11>Oct 11 22:14:15 host app:   File \"x.py\"", "<11>Oct 11 22:14:16 host app: done"},
+			want:   [][]string{{"<11>Oct 11 22:14:15 host app: Traceback", "  File \"x.py\""}, {"<11>Oct 11 22:14:16 host app: done"}},
+		},
+		{
+			name:   "RFC 5424 frames",
+			config: MultilineConfig{ContinuationPattern: `^\s+at `},
+			frames: []string{"<11>1 2024-01-01T00:00:00Z h app - - - Error", "<11>1 2024-01-01T00:00:00Z h app - - -     at f()", "<11>1 2024-01-01T00:00:01Z h app - - - ok"},
+			want:   [][]string{{"<11>1 2024-01-01T00:00:00Z h app - - - Error", "    at f()"}, {"<11>1 2024-01-01T00:00:01Z h app - - - ok"}},
+		},
+	}
+
+	for _, tt := range tests {
+		j, emitted := testJoiner(t, tt.config)
+		addFrames(j, tt.frames...)
+		j.Close()
+		close(emitted)
+
+		var got [][]string
+		for msg := range emitted {
+			got = append(got, joinedLines(msg))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if strings.Join(got[i], "\n") != strings.Join(tt.want[i], "\n") {
+				t.Errorf("%s: message %d is %q, want %q", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+// TestMultilineJoinerSizeLimit starts a new message rather than join past
+// maxMultilineSize
+func TestMultilineJoinerSizeLimit(t *testing.T) {
+	j, emitted := testJoiner(t, MultilineConfig{LeadingWhitespace: true})
+	defer j.Close()
+
+	line := "\t" + strings.Repeat("x", 64*1024)
+	addFrames(j, "first", line, line, line, line, line)
+	got := receive(t, emitted)
+	if len(got) != 4 {
+		t.Errorf("first message has %d lines, want 4", len(got))
+	}
+	size := 0
+	for _, l := range got {
+		size += len(l)
+	}
+	if size >= maxMultilineSize {
+		t.Errorf("first message is %d bytes, want less than %d", size, maxMultilineSize)
+	}
+}
+
+// TestMultilineJoinerFlushTimeout emits a message once no frame arrives
+// within the flush timeout
+func TestMultilineJoinerFlushTimeout(t *testing.T) {
+	j, emitted := testJoiner(t, MultilineConfig{LeadingWhitespace: true, FlushTimeoutMs: 50})
+	defer j.Close()
+
+	addFrames(j, "first", "\tat a")
+	select {
+	case msg := <-emitted:
+		t.Fatalf("emitted %q before the timeout", joinedLines(msg))
+	case <-time.After(10 * time.Millisecond):
+	}
+	if got := receive(t, emitted); strings.Join(got, "|") != "first|\tat a" {
+		t.Errorf("got %q, want first and its continuation", got)
+	}
+
+	// A continuation after the timeout starts a message of its own
+	addFrames(j, "\tlate")
+	if got := receive(t, emitted); strings.Join(got, "|") != "\tlate" {
+		t.Errorf("got %q, want the late line alone", got)
+	}
+
+	// Frames after Close are passed on as they arrive
+	j.Close()
+	addFrames(j, "after close", "\tat b")
+	for _, want := range []string{"after close", "\tat b"} {
+		if got := receive(t, emitted); strings.Join(got, "|") != want {
+			t.Errorf("after close: got %q, want %q", got, want)
+		}
+	}
+}
+
+// TestRFC3164Body skips the header of RFC 3164 frames
+func TestRFC3164Body(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  string
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed", "'su root' failed"},
+		{"<34>Oct 11 22:14:15 mymachine su:\tat x", "\tat x"},
+		{"<34>Oct 11 22:14:15 mymachine su:   indented", "  indented"},
+		{"<34>Oct 11 22:14:15 app[123]: message", "message"},
+		{"<34>app: message", "message"},
+		{"<34>Oct 11 22:14:15 host no tag here", "host no tag here"},
+		{"plain text", "plain text"},
+		{"\tat x", "\tat x"},
+	}
+	for _, tt := range tests {
+		if got := string(rfc3164Body([]byte(tt.frame))); got != tt.want {
+			t.Errorf("rfc3164Body(%q) = %q, want %q", tt.frame, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification channel senders: webhook, SMTP and syslog
+
+const notificationDialTimeout = 10 * time.Second
+
+// webhookTemplateFuncs are available in webhook body templates. Use
+// {{json .Message}} to insert a correctly quoted JSON value.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// webhookBody renders the request body for an alert
+func webhookBody(cfg *WebhookConfig, alert *Alert) ([]byte, error) {
+	if cfg.BodyTemplate == "" {
+		return json.Marshal(alert)
+	}
+
+	tmpl, err := parseWebhookTemplate(cfg.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, alert); err != nil {
+		return nil, fmt.Errorf("failed to render body template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("body template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// sendWebhook sends an alert to an HTTP endpoint. With a secret, the body
+// is signed as X-Qlog-Signature: sha256=<hex HMAC-SHA256>.
+func sendWebhook(cfg *WebhookConfig, alert *Alert) error {
+	body, err := webhookBody(cfg, alert)
+	if err != nil {
+		return err
+	}
+
+	method := cfg.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "qlog")
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Qlog-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	timeout := 10 * time.Second
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail sends an alert by SMTP, upgrading with STARTTLS if configured
+func sendEmail(cfg *SMTPConfig, alert *Alert) error {
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+		if cfg.Security == "starttls" {
+			port = 587
+		}
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", addr, notificationDialTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.SkipVerify}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if cfg.Username != "" {
+		// PlainAuth refuses to send credentials unencrypted except to localhost
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(cfg, alert)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailMessage builds a plain text email for an alert
+func emailMessage(cfg *SMTPConfig, alert *Alert) []byte {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", " ", "\n", " ")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(cfg.From))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(strings.Join(cfg.To, ", ")))
+	fmt.Fprintf(&b, "Subject: [qLog] %s\r\n", clean.Replace(alert.RuleName))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", alert.Message)
+	fmt.Fprintf(&b, "Rule:     %s\r\n", alert.RuleName)
+	fmt.Fprintf(&b, "Fired at: %s\r\n", alert.FiredAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Count:    %d\r\n", alert.Count)
+	if alert.GroupBy != "" {
+		fmt.Fprintf(&b, "Group:    %s=%s\r\n", alert.GroupBy, alert.GroupValue)
+	}
+	if len(alert.LogIDs) > 0 {
+		ids := make([]string, len(alert.LogIDs))
+		for i, id := range alert.LogIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		fmt.Fprintf(&b, "Log IDs:  %s\r\n", strings.Join(ids, ", "))
+	}
+	return []byte(b.String())
+}
+
+// sendSyslogAlert sends an alert as an RFC5424 message. TCP and TLS use
+// octet-counting framing (RFC 6587 / RFC 5425).
+func sendSyslogAlert(cfg *SyslogNotifyConfig, alert *Alert) error {
+	facility := uint8(16)
+	if cfg.Facility != nil {
+		facility = *cfg.Facility
+	}
+	severity := uint8(1)
+	if cfg.Severity != nil {
+		severity = *cfg.Severity
+	}
+	appName := cfg.AppName
+	if appName == "" {
+		appName = "qlog"
+	}
+
+	sd := map[string]string{
+		"alert_id": strconv.FormatInt(alert.ID, 10),
+		"rule_id":  alert.RuleID,
+		"count":    strconv.Itoa(alert.Count),
+	}
+	if alert.GroupBy != "" {
+		sd["group_by"] = alert.GroupBy
+		sd["group_value"] = alert.GroupValue
+	}
+	hostname, _ := os.Hostname()
+	structuredData := formatStructuredData(map[string]map[string]string{"qlogAlert@32473": sd})
+	msg := formatRFC5424(facility*8+severity, alert.FiredAt, hostname, appName, strconv.Itoa(os.Getpid()), "ALERT", structuredData, alert.Message)
+
+	var conn net.Conn
+	var err error
+	switch cfg.Protocol {
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", cfg.Address, notificationDialTimeout)
+	case "tls":
+		dialer := &net.Dialer{Timeout: notificationDialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Address, &tls.Config{InsecureSkipVerify: cfg.SkipVerify})
+	default:
+		conn, err = net.DialTimeout("udp", cfg.Address, notificationDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(notificationDialTimeout))
+
+	if cfg.Protocol == "tcp" || cfg.Protocol == "tls" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_, err = conn.Write([]byte(msg))
+	return err
+}
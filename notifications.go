@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert notifications: delivers fired alerts to notification channels with
+// retry and records the outcome of each delivery
+
+const (
+	notificationQueueSize      = 1000
+	notificationWorkers        = 4
+	defaultNotificationRetries = 3
+	notificationBaseBackoff    = 2 * time.Second
+	notificationMaxBackoff     = 5 * time.Minute
+
+	// redactedSecret replaces passwords and secrets in API responses
+	redactedSecret = "********"
+)
+
+// NotificationDelivery is the delivery log entry for one alert and channel
+type NotificationDelivery struct {
+	ID          int64     `json:"id"`
+	AlertID     int64     `json:"alert_id"`
+	ChannelID   string    `json:"channel_id"`
+	ChannelName string    `json:"channel_name"`
+	ChannelType string    `json:"channel_type"`
+	Status      string    `json:"status"` // "sent" or "failed"
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// notificationJob is a pending delivery of an alert to a channel
+type notificationJob struct {
+	alert   *Alert
+	channel NotificationChannel
+	attempt int
+	errors  []string
+}
+
+// Notifier delivers alerts to notification channels in the background
+type Notifier struct {
+	db       *Database
+	mu       sync.RWMutex
+	channels map[string]NotificationChannel
+	queue    chan *notificationJob
+}
+
+func NewNotifier(db *Database, channels []NotificationChannel) *Notifier {
+	n := &Notifier{
+		db:    db,
+		queue: make(chan *notificationJob, notificationQueueSize),
+	}
+	n.SetChannels(channels)
+
+	for i := 0; i < notificationWorkers; i++ {
+		go n.worker()
+	}
+	return n
+}
+
+// SetChannels replaces the configured channels
+func (n *Notifier) SetChannels(channels []NotificationChannel) {
+	byID := make(map[string]NotificationChannel, len(channels))
+	for _, channel := range channels {
+		byID[channel.ID] = channel
+	}
+
+	n.mu.Lock()
+	n.channels = byID
+	n.mu.Unlock()
+}
+
+// Notify queues an alert for delivery to the given channels. Unknown and
+// disabled channels are skipped.
+func (n *Notifier) Notify(alert *Alert, channelIDs []string) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, id := range channelIDs {
+		channel, ok := n.channels[id]
+		if !ok || !channel.Enabled {
+			continue
+		}
+		n.enqueue(&notificationJob{alert: alert, channel: channel})
+	}
+}
+
+// enqueue adds a job without blocking the caller (the log writer)
+func (n *Notifier) enqueue(job *notificationJob) {
+	select {
+	case n.queue <- job:
+	default:
+		log.Printf("Notification queue full, dropping alert %d for channel %q", job.alert.ID, job.channel.Name)
+		n.record(job, "failed", "notification queue full")
+	}
+}
+
+func (n *Notifier) worker() {
+	for job := range n.queue {
+		n.deliver(job)
+	}
+}
+
+// deliver makes one delivery attempt and schedules a retry with exponential
+// backoff on failure. Retries are timers so a failing channel doesn't hold
+// up the workers.
+func (n *Notifier) deliver(job *notificationJob) {
+	job.attempt++
+	err := sendNotification(&job.channel, job.alert)
+	if err == nil {
+		n.record(job, "sent", "")
+		return
+	}
+
+	job.errors = append(job.errors, fmt.Sprintf("attempt %d: %v", job.attempt, err))
+
+	maxRetries := defaultNotificationRetries
+	if job.channel.MaxRetries != nil {
+		maxRetries = *job.channel.MaxRetries
+	}
+	if job.attempt > maxRetries {
+		log.Printf("Notification to %q failed after %d attempts: %v", job.channel.Name, job.attempt, err)
+		n.record(job, "failed", strings.Join(job.errors, "; "))
+		return
+	}
+
+	backoff := min(notificationBaseBackoff<<(job.attempt-1), notificationMaxBackoff)
+	time.AfterFunc(backoff, func() { n.enqueue(job) })
+}
+
+// record writes the final outcome of a job to the delivery log
+func (n *Notifier) record(job *notificationJob, status, errMsg string) {
+	delivery := &NotificationDelivery{
+		AlertID:     job.alert.ID,
+		ChannelID:   job.channel.ID,
+		ChannelName: job.channel.Name,
+		ChannelType: job.channel.Type,
+		Status:      status,
+		Attempts:    job.attempt,
+		Error:       errMsg,
+		CreatedAt:   time.Now(),
+	}
+	if err := n.db.InsertNotificationDelivery(delivery); err != nil {
+		log.Printf("Error saving notification delivery: %v", err)
+	}
+}
+
+// sendNotification delivers an alert to a channel once
+func sendNotification(channel *NotificationChannel, alert *Alert) error {
+	switch channel.Type {
+	case "webhook":
+		if channel.Webhook == nil {
+			return errors.New("webhook settings missing")
+		}
+		return sendWebhook(channel.Webhook, alert)
+	case "smtp":
+		if channel.SMTP == nil {
+			return errors.New("smtp settings missing")
+		}
+		return sendEmail(channel.SMTP, alert)
+	case "syslog":
+		if channel.Syslog == nil {
+			return errors.New("syslog settings missing")
+		}
+		return sendSyslogAlert(channel.Syslog, alert)
+	}
+	return fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+// validateNotificationChannel checks a channel from the API and fills in its ID
+func validateNotificationChannel(channel *NotificationChannel) error {
+	if channel.Name == "" {
+		return errors.New("name is required")
+	}
+	if channel.MaxRetries != nil && *channel.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+
+	switch channel.Type {
+	case "webhook":
+		if channel.Webhook == nil || channel.Webhook.URL == "" {
+			return errors.New("webhook url is required")
+		}
+		if !strings.HasPrefix(channel.Webhook.URL, "http://") && !strings.HasPrefix(channel.Webhook.URL, "https://") {
+			return errors.New("webhook url must be http:// or https://")
+		}
+		if channel.Webhook.BodyTemplate != "" {
+			if _, err := parseWebhookTemplate(channel.Webhook.BodyTemplate); err != nil {
+				return fmt.Errorf("invalid body template: %w", err)
+			}
+		}
+	case "smtp":
+		if channel.SMTP == nil || channel.SMTP.Host == "" || channel.SMTP.From == "" || len(channel.SMTP.To) == 0 {
+			return errors.New("smtp host, from and to are required")
+		}
+		if channel.SMTP.Security != "" && channel.SMTP.Security != "none" && channel.SMTP.Security != "starttls" {
+			return errors.New("smtp security must be none or starttls")
+		}
+	case "syslog":
+		if channel.Syslog == nil || channel.Syslog.Address == "" {
+			return errors.New("syslog address is required")
+		}
+		switch channel.Syslog.Protocol {
+		case "", "udp", "tcp", "tls":
+		default:
+			return errors.New("syslog protocol must be udp, tcp or tls")
+		}
+		if channel.Syslog.Facility != nil && *channel.Syslog.Facility > 23 {
+			return errors.New("syslog facility must be 0-23")
+		}
+		if channel.Syslog.Severity != nil && *channel.Syslog.Severity > 7 {
+			return errors.New("syslog severity must be 0-7")
+		}
+	default:
+		return errors.New("type must be webhook, smtp or syslog")
+	}
+
+	if channel.ID == "" {
+		channel.ID = fmt.Sprintf("channel-%d", time.Now().UnixNano())
+	}
+	return nil
+}
+
+// redactChannel returns a copy of channel with secrets hidden for API responses
+func redactChannel(channel NotificationChannel) NotificationChannel {
+	if channel.Webhook != nil && channel.Webhook.Secret != "" {
+		webhook := *channel.Webhook
+		webhook.Secret = redactedSecret
+		channel.Webhook = &webhook
+	}
+	if channel.SMTP != nil && channel.SMTP.Password != "" {
+		smtp := *channel.SMTP
+		smtp.Password = redactedSecret
+		channel.SMTP = &smtp
+	}
+	return channel
+}
+
+// restoreChannelSecrets keeps the stored secrets when an update sends back
+// the redacted placeholder
+func restoreChannelSecrets(channel *NotificationChannel, existing NotificationChannel) {
+	if channel.Webhook != nil && channel.Webhook.Secret == redactedSecret && existing.Webhook != nil {
+		channel.Webhook.Secret = existing.Webhook.Secret
+	}
+	if channel.SMTP != nil && channel.SMTP.Password == redactedSecret && existing.SMTP != nil {
+		channel.SMTP.Password = existing.SMTP.Password
+	}
+}
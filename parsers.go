@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	syslog "github.com/leodido/go-syslog/v4"
+	"github.com/leodido/go-syslog/v4/rfc3164"
+	"github.com/leodido/go-syslog/v4/rfc5424"
+)
+
+// Per-listener syslog parser chains
+
+const (
+	parserRFC5424 = "RFC5424"
+	parserRFC3164 = "RFC3164"
+	parserAuto    = "auto"
+	parserRaw     = "raw"
+
+	parserFallbackRaw       = "raw"       // Store frames no parser accepted as unparsed logs
+	parserFallbackAlternate = "alternate" // Try the other RFC before storing as unparsed
+	parserFallbackDrop      = "drop"      // Discard frames no parser accepted
+)
+
+// formatParser is one syslog format in a chain. go-syslog machines parse
+// one message at a time, so each format keeps a pool of them for the
+// listener's connections and ingest workers.
+type formatParser struct {
+	format     string
+	machines   *sync.Pool
+	bestEffort bool
+}
+
+// ParserChain parses frames for one listener. Parsers are tried in order
+// until one accepts the frame.
+type ParserChain struct {
+	parsers  []formatParser
+	auto     bool // Order the parsers by the detected format of each frame
+	fallback string
+}
+
+// NewParserChain builds the chain for a listener. Listener settings that are
+// unset fall back to the global parsing config.
+func NewParserChain(listener ListenerConfig, parsing ParsingConfig) *ParserChain {
+	bestEffort := parsing.BestEffort
+	if listener.BestEffort != nil {
+		bestEffort = *listener.BestEffort
+	}
+
+	// Listeners set to one RFC used to accept both, so they still try the
+	// other one unless a fallback is chosen
+	chain := &ParserChain{fallback: listener.ParserFallback}
+	if chain.fallback == "" {
+		chain.fallback = parserFallbackRaw
+		if listener.Parser == parserRFC5424 || listener.Parser == parserRFC3164 {
+			chain.fallback = parserFallbackAlternate
+		}
+	}
+
+	switch listener.Parser {
+	case parserRaw:
+		return chain
+	case parserRFC5424:
+		chain.add(parserRFC5424, bestEffort)
+		if chain.fallback == parserFallbackAlternate {
+			chain.add(parserRFC3164, bestEffort)
+		}
+	case parserRFC3164:
+		chain.add(parserRFC3164, bestEffort)
+		if chain.fallback == parserFallbackAlternate {
+			chain.add(parserRFC5424, bestEffort)
+		}
+	default:
+		// Auto-detect, limited to the globally enabled formats
+		chain.auto = true
+		if parsing.RFC5424Enabled {
+			chain.add(parserRFC5424, bestEffort)
+		}
+		if parsing.RFC3164Enabled {
+			chain.add(parserRFC3164, bestEffort)
+		}
+	}
+	return chain
+}
+
+func (c *ParserChain) add(format string, bestEffort bool) {
+	var opts []syslog.MachineOption
+	if bestEffort {
+		if format == parserRFC5424 {
+			opts = append(opts, rfc5424.WithBestEffort())
+		} else {
+			opts = append(opts, rfc3164.WithBestEffort())
+		}
+	}
+
+	machines := &sync.Pool{New: func() interface{} {
+		if format == parserRFC5424 {
+			return rfc5424.NewParser(opts...)
+		}
+		return rfc3164.NewParser(opts...)
+	}}
+	c.parsers = append(c.parsers, formatParser{format: format, machines: machines, bestEffort: bestEffort})
+}
+
+// Parse returns the message from the first parser that accepts data and its
+// format. ok is false if none did.
+func (c *ParserChain) Parse(data []byte) (msg syslog.Message, format string, ok bool) {
+	parsers := c.parsers
+	if c.auto && len(parsers) == 2 && detectSyslogFormat(data) != parsers[0].format {
+		parsers = []formatParser{parsers[1], parsers[0]}
+	}
+
+	for _, p := range parsers {
+		machine := p.machines.Get().(syslog.Machine)
+		msg, err := machine.Parse(data)
+		p.machines.Put(machine)
+		if msg == nil {
+			continue
+		}
+		// Best effort keeps partial messages as long as the header is usable
+		if err == nil || (p.bestEffort && msg.Valid()) {
+			return msg, p.format, true
+		}
+	}
+	return nil, "", false
+}
+
+// HasParsers reports whether the chain parses frames at all (raw listeners don't)
+func (c *ParserChain) HasParsers() bool {
+	return len(c.parsers) > 0
+}
+
+// DropUnparsed reports whether frames no parser accepted should be discarded
+func (c *ParserChain) DropUnparsed() bool {
+	return c.fallback == parserFallbackDrop
+}
+
+// detectSyslogFormat guesses the format of a frame from its header: RFC5424
+// has a version number right after the PRI ("<34>1 "), RFC3164 does not
+func detectSyslogFormat(data []byte) string {
+	if len(data) < 3 || data[0] != '<' {
+		return parserRFC3164
+	}
+	i := 1
+	for i < len(data) && i <= 4 && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	if i == 1 || i >= len(data) || data[i] != '>' {
+		return parserRFC3164
+	}
+	i++
+	start := i
+	for i < len(data) && i-start < 3 && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	if i > start && i < len(data) && data[i] == ' ' && data[start] != '0' {
+		return parserRFC5424
+	}
+	return parserRFC3164
+}
+
+// validateListenerParser checks a listener's parser settings
+func validateListenerParser(listener *ListenerConfig) error {
+	switch listener.Parser {
+	case "", parserRFC5424, parserRFC3164, parserAuto, parserRaw:
+	default:
+		return errors.New("parser must be RFC5424, RFC3164, auto or raw")
+	}
+	switch listener.ParserFallback {
+	case "", parserFallbackRaw, parserFallbackAlternate, parserFallbackDrop:
+	default:
+		return errors.New("parser_fallback must be raw, alternate or drop")
+	}
+	if listener.Parser == parserRaw && listener.ParserFallback == parserFallbackDrop {
+		return errors.New("parser_fallback drop would discard every message of a raw listener")
+	}
+	return nil
+}
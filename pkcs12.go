@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"unicode/utf16"
+)
+
+// PKCS#12 (RFC 7292) encoding of a private key and its certificate chain,
+// for importing client certificates into devices and certificate stores.
+// The key is encrypted with PBES2 (PBKDF2-SHA256, AES-256-CBC) and the file
+// is authenticated with HMAC-SHA256, the defaults of OpenSSL 3.
+
+const pkcs12Iterations = 10000
+
+var (
+	oidPKCS7Data           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509CertificateType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue // [0] EXPLICIT
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue // SET OF
+}
+
+type pkcs12CertBag struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue // [0] EXPLICIT OCTET STRING
+}
+
+type pkcs12EncryptedKey struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivation    pkix.AlgorithmIdentifier
+	EncryptionScheme pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// encodePKCS12 returns a PKCS#12 file holding key, its certificate and the
+// certificates of its issuers, protected by password
+func encodePKCS12(key crypto.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate, friendlyName, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("a password is required")
+	}
+	if key == nil || cert == nil {
+		return nil, errors.New("a private key and its certificate are required")
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		if public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && !public.Equal(cert.PublicKey) {
+			return nil, errors.New("the private key does not match the certificate")
+		}
+	}
+
+	keyID := sha1.Sum(cert.Raw)
+	attributes, err := pkcs12BagAttributes(keyID[:], friendlyName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates are not encrypted, only the key
+	var certBags []pkcs12SafeBag
+	for i, c := range append([]*x509.Certificate{cert}, chain...) {
+		certBag, err := asn1.Marshal(pkcs12CertBag{
+			ID:    oidX509CertificateType,
+			Value: explicitTag(mustMarshal(c.Raw)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		bag := pkcs12SafeBag{ID: oidCertBag, Value: explicitTag(certBag)}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := pbes2Encrypt(pkcs8, password)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []pkcs12SafeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicitTag(encryptedKey), Attributes: attributes}}
+
+	var contents []pkcs12ContentInfo
+	for _, bags := range [][]pkcs12SafeBag{certBags, keyBags} {
+		safeContents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: explicitTag(mustMarshal(safeContents))})
+	}
+	authSafe, err := asn1.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt := make([]byte, 16)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(sha256.New, 64, bmpPassword(password), macSalt, 3, pkcs12Iterations, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+
+	return asn1.Marshal(pkcs12PFX{
+		Version:  3,
+		AuthSafe: pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: explicitTag(mustMarshal(authSafe))},
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// pkcs12BagAttributes returns the localKeyId and friendlyName attributes
+// that pair a key with its certificate
+func pkcs12BagAttributes(keyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	localKeyID, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{ID: oidLocalKeyID, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: localKeyID}}}
+	if friendlyName != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{ID: oidFriendlyName, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: name}})
+	}
+	return attributes, nil
+}
+
+// pbes2Encrypt encrypts a PKCS#8 key as an EncryptedPrivateKeyInfo
+func pbes2Encrypt(plaintext []byte, password string) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, pkcs12Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	data := append(append([]byte{}, plaintext...), make([]byte, padding)...)
+	for i := len(plaintext); i < len(data); i++ {
+		data[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivation:    pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: mustMarshal(iv)}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs12EncryptedKey{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+// pkcs12KDF derives key material as in RFC 7292 appendix B.2. v is the
+// block size of the hash and id selects the purpose (3 = MAC key).
+func pkcs12KDF(newHash func() hash.Hash, v int, password, salt []byte, id byte, iterations, size int) []byte {
+	repeat := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(data)+v-1)/v))
+		for i := range out {
+			out[i] = data[i%len(data)]
+		}
+		return out
+	}
+
+	diversifier := make([]byte, v)
+	for i := range diversifier {
+		diversifier[i] = id
+	}
+	input := append(repeat(salt), repeat(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := newHash()
+		h.Write(diversifier)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+
+		// Add B+1 to each v-byte block of the input
+		b := make([]byte, v)
+		for i := range b {
+			b[i] = a[i%len(a)]
+		}
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes s as big-endian UTF-16
+func bmpString(s string) []byte {
+	var out []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+// bmpPassword encodes a password for the PKCS#12 KDF: UTF-16 with a
+// terminating NUL
+func bmpPassword(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+// explicitTag wraps DER-encoded content in a [0] EXPLICIT tag
+func explicitTag(content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+}
+
+// mustMarshal encodes a byte slice as an OCTET STRING, which cannot fail
+func mustMarshal(data []byte) []byte {
+	encoded, err := asn1.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
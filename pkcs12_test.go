@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a certificate for a new key, signed by parent, or
+// self-signed when parent is nil
+func testCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// decodedPKCS12 is what decodePKCS12 reads back from a PKCS#12 file
+type decodedPKCS12 struct {
+	certs     []*x509.Certificate
+	certKeyID []byte // localKeyId of the first certificate
+	key       any
+	keyID     []byte
+}
+
+// decodePKCS12 reads a file written by encodePKCS12, checking its MAC and
+// decrypting the key with password
+func decodePKCS12(data []byte, password string) (*decodedPKCS12, error) {
+	var pfx pkcs12PFX
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, err
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, err
+	}
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) {
+		return nil, errors.New("unexpected MAC algorithm")
+	}
+	macKey := pkcs12KDF(sha256.New, 64, bmpPassword(password), pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		return nil, errors.New("MAC mismatch")
+	}
+
+	var contents []pkcs12ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, err
+	}
+	decoded := &decodedPKCS12{}
+	for _, content := range contents {
+		var safeContents []byte
+		if _, err := asn1.Unmarshal(content.Content.Bytes, &safeContents); err != nil {
+			return nil, err
+		}
+		var bags []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+			return nil, err
+		}
+		for _, bag := range bags {
+			keyID, err := bagLocalKeyID(bag)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var certBag pkcs12CertBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+					return nil, err
+				}
+				var der []byte
+				if _, err := asn1.Unmarshal(certBag.Value.Bytes, &der); err != nil {
+					return nil, err
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, err
+				}
+				if len(decoded.certs) == 0 {
+					decoded.certKeyID = keyID
+				}
+				decoded.certs = append(decoded.certs, cert)
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				pkcs8, err := pbes2Decrypt(bag.Value.Bytes, password)
+				if err != nil {
+					return nil, err
+				}
+				if decoded.key, err = x509.ParsePKCS8PrivateKey(pkcs8); err != nil {
+					return nil, err
+				}
+				decoded.keyID = keyID
+			default:
+				return nil, errors.New("unexpected bag type " + bag.ID.String())
+			}
+		}
+	}
+	return decoded, nil
+}
+
+// bagLocalKeyID returns the localKeyId attribute of bag, if it has one
+func bagLocalKeyID(bag pkcs12SafeBag) ([]byte, error) {
+	for _, attribute := range bag.Attributes {
+		if attribute.ID.Equal(oidLocalKeyID) {
+			var keyID []byte
+			_, err := asn1.Unmarshal(attribute.Values.Bytes, &keyID)
+			return keyID, err
+		}
+	}
+	return nil, nil
+}
+
+// pbes2Decrypt reverses pbes2Encrypt
+func pbes2Decrypt(data []byte, password string) ([]byte, error) {
+	var encrypted pkcs12EncryptedKey
+	if _, err := asn1.Unmarshal(data, &encrypted); err != nil {
+		return nil, err
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(encrypted.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivation.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, kdfParams.Salt, kdfParams.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(encrypted.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, encrypted.EncryptedData)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("bad padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// TestEncodePKCS12 writes the key encrypted, the certificate first followed
+// by its chain in order, and pairs the key with the certificate
+func TestEncodePKCS12(t *testing.T) {
+	root, rootKey := testCertificate(t, "root", nil, nil)
+	intermediate, intermediateKey := testCertificate(t, "intermediate", root, rootKey)
+	leaf, leafKey := testCertificate(t, "device", intermediate, intermediateKey)
+
+	data, err := encodePKCS12(leafKey, leaf, []*x509.Certificate{intermediate, root}, "Firewall", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodePKCS12(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, cert := range decoded.certs {
+		names = append(names, cert.Subject.CommonName)
+	}
+	if strings.Join(names, ",") != "device,intermediate,root" {
+		t.Errorf("certificates are %q, want device, intermediate and root", names)
+	}
+	if key, ok := decoded.key.(*ecdsa.PrivateKey); !ok || !key.Equal(leafKey) {
+		t.Errorf("decrypted key does not match the original")
+	}
+	keyID := sha1.Sum(leaf.Raw)
+	if !bytes.Equal(decoded.keyID, keyID[:]) || !bytes.Equal(decoded.certKeyID, keyID[:]) {
+		t.Errorf("localKeyId of key %x and certificate %x, want %x", decoded.keyID, decoded.certKeyID, keyID)
+	}
+
+	if _, err := decodePKCS12(data, "wrong"); err == nil || err.Error() != "MAC mismatch" {
+		t.Errorf("wrong password: got %v, want a MAC mismatch", err)
+	}
+}
+
+// TestPBES2Encrypt decrypts only with the password it was encrypted with
+func TestPBES2Encrypt(t *testing.T) {
+	for _, plaintext := range []string{"", "key", strings.Repeat("k", aes.BlockSize), strings.Repeat("k", 100)} {
+		encrypted, err := pbes2Encrypt([]byte(plaintext), "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := pbes2Decrypt(encrypted, "secret"); err != nil || string(got) != plaintext {
+			t.Errorf("%d bytes: decrypted %q (%v), want %q", len(plaintext), got, err, plaintext)
+		}
+		if got, err := pbes2Decrypt(encrypted, "wrong"); err == nil && string(got) == plaintext {
+			t.Errorf("%d bytes: decrypted with the wrong password", len(plaintext))
+		}
+	}
+}
+
+// TestEncodePKCS12Rejects refuses to write a file without a password, key or
+// certificate, or for a key that isn't the certificate's
+func TestEncodePKCS12Rejects(t *testing.T) {
+	cert, key := testCertificate(t, "device", nil, nil)
+	_, otherKey := testCertificate(t, "other", nil, nil)
+
+	tests := []struct {
+		name     string
+		key      crypto.PrivateKey
+		cert     *x509.Certificate
+		password string
+		errText  string
+	}{
+		{name: "no password", key: key, cert: cert, errText: "password is required"},
+		{name: "no key", cert: cert, password: "secret", errText: "private key and its certificate are required"},
+		{name: "no certificate", key: key, password: "secret", errText: "private key and its certificate are required"},
+		{name: "other key", key: otherKey, cert: cert, password: "secret", errText: "does not match the certificate"},
+	}
+	for _, tt := range tests {
+		_, err := encodePKCS12(tt.key, tt.cert, nil, "", tt.password)
+		if err == nil || !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.errText)
+		}
+	}
+}
+
+// TestPKCS12KDF matches the key derivation of OpenSSL
+func TestPKCS12KDF(t *testing.T) {
+	tests := []struct {
+		name       string
+		newHash    func() hash.Hash
+		password   string
+		salt       string
+		id         byte
+		iterations int
+		want       string
+	}{
+		{name: "SHA-1 key", newHash: sha1.New, password: "smeg", salt: "0a58cf64530d823f", id: 1, iterations: 1, want: "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca3"},
+		{name: "SHA-1 key over two blocks", newHash: sha1.New, password: "smeg", salt: "0a58cf64530d823f", id: 1, iterations: 1, want: "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca340830d0ce0d7879d3efedb33c8ae755c95b9defaf51cf797"},
+		{name: "SHA-256 MAC key", newHash: sha256.New, password: "secret", salt: "0102030405060708", id: 3, iterations: 1000, want: "db560f5571c1a4c9525e2d7b4da32f8de09e34a256c28ea84387f1744d27680d"},
+	}
+	for _, tt := range tests {
+		salt, _ := hex.DecodeString(tt.salt)
+		got := pkcs12KDF(tt.newHash, 64, bmpPassword(tt.password), salt, tt.id, tt.iterations, len(tt.want)/2)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s: got %x, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestEncodePKCS12OpenSSL reads the file with openssl, when it is installed
+func TestEncodePKCS12OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not found")
+	}
+	ca, caKey := testCertificate(t, "ca", nil, nil)
+	cert, key := testCertificate(t, "device", ca, caKey)
+	data, err := encodePKCS12(key, cert, []*x509.Certificate{ca}, "device", "pässwörd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "device.p12")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(openssl, "pkcs12", "-in", path, "-passin", "pass:pässwörd", "-nodes").CombinedOutput()
+	if err != nil {
+		t.Fatalf("openssl: %v\n%s", err, out)
+	}
+	if got := string(out); strings.Count(got, "BEGIN CERTIFICATE") != 2 || !strings.Contains(got, "BEGIN PRIVATE KEY") || strings.Index(got, "CN = device") > strings.Index(got, "CN = ca") {
+		t.Errorf("openssl read:\n%s", got)
+	}
+	if out, err := exec.Command(openssl, "pkcs12", "-in", path, "-passin", "pass:wrong", "-nodes").CombinedOutput(); err == nil {
+		t.Errorf("openssl accepted the wrong password:\n%s", out)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY protocol (v1 text and v2 binary) for TCP and TLS listeners behind
+// load balancers, so devices are matched by the real client address
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+	proxyV1MaxLength   = 107 // Longest v1 header including CRLF
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyPolicy decides which peers' PROXY headers are honored
+type proxyPolicy struct {
+	trusted []netip.Prefix
+}
+
+// newProxyPolicy returns the listener's PROXY protocol policy, or nil if the
+// listener doesn't use PROXY protocol
+func newProxyPolicy(listener ListenerConfig) (*proxyPolicy, error) {
+	if !listener.ProxyProtocol {
+		return nil, nil
+	}
+	if err := validateListenerProxy(&listener); err != nil {
+		return nil, err
+	}
+	policy := &proxyPolicy{}
+	for _, value := range listener.ProxyTrustedCIDRs {
+		prefix, _ := parseDevicePrefix(value)
+		policy.trusted = append(policy.trusted, prefix)
+	}
+	return policy, nil
+}
+
+// validateListenerProxy checks a listener's PROXY protocol settings
+func validateListenerProxy(listener *ListenerConfig) error {
+	if !listener.ProxyProtocol {
+		return nil
+	}
+	if listener.Protocol != "TCP" && listener.Protocol != "TLS" {
+		return errors.New("proxy_protocol is only supported for TCP and TLS listeners")
+	}
+	if len(listener.ProxyTrustedCIDRs) == 0 {
+		return errors.New("proxy_protocol requires proxy_trusted_cidrs")
+	}
+	for _, value := range listener.ProxyTrustedCIDRs {
+		if _, err := parseDevicePrefix(value); err != nil {
+			return fmt.Errorf("invalid proxy trusted address or CIDR range %q", value)
+		}
+	}
+	return nil
+}
+
+// trusts reports whether the peer at addr may send a PROXY header
+func (p *proxyPolicy) trusts(addr net.Addr) bool {
+	ip, ok := remoteIP(addr.String())
+	if !ok {
+		return false
+	}
+	for _, prefix := range p.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap reads the PROXY header of a connection from a trusted peer and returns
+// a connection reporting the client address it names. Untrusted peers are
+// served as direct connections. A nil policy returns conn unchanged.
+func (p *proxyPolicy) wrap(conn net.Conn) (net.Conn, error) {
+	if p == nil || !p.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	reader := bufio.NewReader(conn)
+	source, err := readProxyHeader(reader)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol header from %s: %w", conn.RemoteAddr(), err)
+	}
+
+	wrapped := &proxyConn{Conn: conn, reader: reader, remote: conn.RemoteAddr()}
+	if source != nil {
+		wrapped.remote = source
+	}
+	return wrapped, nil
+}
+
+// proxyConn is a connection whose PROXY header has been consumed
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader // Holds data read past the header
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address it
+// carries, or nil for LOCAL (v2) and UNKNOWN (v1) connections such as load
+// balancer health checks
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Both versions' headers are longer than the v2 signature
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2Header(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1Header(r)
+	default:
+		return nil, errors.New("missing PROXY protocol header")
+	}
+}
+
+// readProxyV1Header reads "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n"
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header must end with CRLF")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2Header reads a binary v2 header, skipping any TLVs
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13] >> 4
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	switch command {
+	case 0: // LOCAL: the proxy's own connection, e.g. a health check
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+
+	switch family {
+	case 1: // AF_INET: src addr, dst addr, src port, dst port
+		if len(payload) < 12 {
+			return nil, errors.New("v2 IPv4 address block too short")
+		}
+		ip := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("v2 IPv6 address block too short")
+		}
+		ip := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	default:
+		// AF_UNSPEC or AF_UNIX carry no usable client IP
+		return nil, nil
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a v2 header with the given command, address family
+// and address block
+func proxyV2Header(command, family byte, block []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family<<4|1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(block)))
+	return append(header, block...)
+}
+
+// TestReadProxyHeader reads v1 and v2 headers followed by a syslog frame
+func TestReadProxyHeader(t *testing.T) {
+	ipv4Block := []byte{192, 0, 2, 10, 10, 0, 0, 1, 0x30, 0x39, 0x02, 0x02} // 192.0.2.10:12345 -> 10.0.0.1:514
+	ipv6Block := make([]byte, 36)
+	copy(ipv6Block, net.ParseIP("2001:db8::7"))
+	copy(ipv6Block[16:], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6Block[32:], 40000)
+	binary.BigEndian.PutUint16(ipv6Block[34:], 514)
+	ipv4WithTLV := append(append([]byte{}, ipv4Block...), 0x04, 0x00, 0x01, 0xff) // PP2_TYPE_NOOP
+
+	tests := []struct {
+		name      string
+		input     []byte
+		want      string // Source address, "" for none
+		errText   string
+		truncated bool // The stream ends inside the header
+	}{
+		{name: "v1 TCP4", input: []byte("PROXY TCP4 192.0.2.10 10.0.0.1 12345 514\r\n"), want: "192.0.2.10:12345"},
+		{name: "v1 TCP6", input: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 40000 514\r\n"), want: "[2001:db8::7]:40000"},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 UNKNOWN with addresses", input: []byte("PROXY UNKNOWN 192.0.2.10 10.0.0.1 12345 514\r\n")},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::7 2001:db8::1 40000 514\r\n"), errText: "invalid v1 source address"},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 192.0.2.10 10.0.0.1 70000 514\r\n"), errText: "invalid v1 source port"},
+		{name: "v1 missing fields", input: []byte("PROXY TCP4 192.0.2.10\r\n"), errText: "malformed v1 header"},
+		{name: "v1 UDP", input: []byte("PROXY UDP4 192.0.2.10 10.0.0.1 12345 514\r\n"), errText: "malformed v1 header"},
+		{name: "v1 bare LF", input: []byte("PROXY TCP4 192.0.2.10 10.0.0.1 12345 514\n"), errText: "must end with CRLF"},
+		{name: "v1 longer than the limit", input: []byte("PROXY TCP6 " + strings.Repeat("f", 120) + "\r\n"), errText: "too long"},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.10"), errText: io.ErrUnexpectedEOF.Error(), truncated: true},
+		{name: "v2 IPv4", input: proxyV2Header(1, 1, ipv4Block), want: "192.0.2.10:12345"},
+		{name: "v2 IPv6", input: proxyV2Header(1, 2, ipv6Block), want: "[2001:db8::7]:40000"},
+		{name: "v2 IPv4 with TLVs", input: proxyV2Header(1, 1, ipv4WithTLV), want: "192.0.2.10:12345"},
+		{name: "v2 LOCAL", input: proxyV2Header(0, 0, nil)},
+		{name: "v2 LOCAL with addresses", input: proxyV2Header(0, 1, ipv4Block)},
+		{name: "v2 AF_UNSPEC", input: proxyV2Header(1, 0, nil)},
+		{name: "v2 AF_UNIX", input: proxyV2Header(1, 3, make([]byte, 216))},
+		{name: "v2 unsupported command", input: proxyV2Header(2, 1, ipv4Block), errText: "unsupported v2 command"},
+		{name: "v2 unsupported version", input: append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 0), errText: "unsupported v2 version"},
+		{name: "v2 short IPv4 block", input: proxyV2Header(1, 1, ipv4Block[:8]), errText: "IPv4 address block too short"},
+		{name: "v2 short IPv6 block", input: proxyV2Header(1, 2, ipv6Block[:20]), errText: "IPv6 address block too short"},
+		{name: "v2 truncated", input: proxyV2Header(1, 1, ipv4Block)[:20], errText: io.ErrUnexpectedEOF.Error(), truncated: true},
+		{name: "no header", input: []byte("<34>Oct 11 22:14:15 host su: hi\n"), errText: "missing PROXY protocol header"},
+	}
+
+	const frame = "<34>1 - h a - - - after the header\n"
+	for _, tt := range tests {
+		input := tt.input
+		if !tt.truncated {
+			input = append(append([]byte{}, input...), frame...)
+		}
+		r := bufio.NewReader(bytes.NewReader(input))
+		addr, err := readProxyHeader(r)
+		if tt.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("%s: got %v, %v, want an error containing %q", tt.name, addr, err, tt.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got source %q, want %q", tt.name, got, tt.want)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != frame {
+			t.Errorf("%s: data after the header is %q, want %q", tt.name, rest, frame)
+		}
+	}
+}
+
+// TestProxyPolicyWrap honors headers only from trusted peers
+func TestProxyPolicyWrap(t *testing.T) {
+	policy, err := newProxyPolicy(ListenerConfig{Protocol: "TCP", ProxyProtocol: true, ProxyTrustedCIDRs: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.10 10.0.0.1 12345 514\r\nhello"))
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wrapped, err := policy.wrap(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wrapped.RemoteAddr().String(); got != "192.0.2.10:12345" {
+		t.Errorf("trusted peer: got remote address %s, want 192.0.2.10:12345", got)
+	}
+	if data, _ := io.ReadAll(wrapped); string(data) != "hello" {
+		t.Errorf("trusted peer: read %q after the header, want %q", data, "hello")
+	}
+
+	untrusted := &proxyPolicy{}
+	if got, _ := untrusted.wrap(conn); got != conn {
+		t.Errorf("untrusted peer: connection was wrapped")
+	}
+}
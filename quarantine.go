@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"qlog/modules"
+)
+
+// Quarantine: messages from senders that match no device are held in the
+// database (bounded per sender) so they can be reviewed and adopted
+
+const (
+	quarantinePerSource     = 100   // Messages kept per unknown sender
+	quarantineMaxSources    = 10000 // Unknown senders tracked before the least recent are dropped
+	quarantineQueueSize     = 5000
+	quarantineFlushInterval = time.Second
+	quarantinePruneInterval = 10 * time.Minute
+)
+
+var (
+	errQuarantineClosed = errors.New("quarantine is closed")
+	errQuarantineFull   = errors.New("quarantine queue is full")
+)
+
+// Quarantine batches messages from unknown senders into the database on its
+// own goroutine. A flood from unknown sources never blocks ingest: when the
+// queue is full messages are dropped and counted.
+type Quarantine struct {
+	db      *Database
+	entries chan QuarantinedLog
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQuarantine creates a quarantine and starts its writer
+func NewQuarantine(db *Database) *Quarantine {
+	q := &Quarantine{
+		db:      db,
+		entries: make(chan QuarantinedLog, quarantineQueueSize),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Add queues a message from an unknown sender
+func (q *Quarantine) Add(entry *LogEntry, protocol, rfcFormat string) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		entry.saved(errQuarantineClosed)
+		return
+	}
+
+	sourceIP := entry.RemoteAddr
+	if addr, ok := remoteIP(entry.RemoteAddr); ok {
+		sourceIP = addr.String()
+	}
+
+	// Suggest a device type from the module that recognizes the message
+	suggested := modules.GetRegistry().ParseLog(entry.Body(), entry.Timestamp, entry.Severity, entry.Priority).DeviceType
+	if suggested == "unknown" {
+		suggested = ""
+	}
+
+	select {
+	case q.entries <- QuarantinedLog{SourceIP: sourceIP, Protocol: protocol, RFCFormat: rfcFormat, Entry: entry, SuggestedDeviceType: suggested}:
+	default:
+		q.dropped.Add(1)
+		entry.saved(errQuarantineFull)
+	}
+}
+
+// Dropped returns the number of messages discarded because the queue was full
+func (q *Quarantine) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Close writes queued messages and stops the writer
+func (q *Quarantine) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
+
+func (q *Quarantine) run() {
+	defer close(q.done)
+
+	flush := time.NewTicker(quarantineFlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(quarantinePruneInterval)
+	defer prune.Stop()
+
+	var batch []QuarantinedLog
+	for {
+		select {
+		case item, ok := <-q.entries:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= defaultWriteBatchSize {
+				q.flush(batch)
+				batch = nil
+			}
+		case <-flush.C:
+			q.flush(batch)
+			batch = nil
+		case <-prune.C:
+			if removed, err := q.db.PruneUnknownSenders(quarantineMaxSources); err != nil {
+				log.Printf("Quarantine: failed to prune unknown senders: %v", err)
+			} else if removed > 0 {
+				log.Printf("Quarantine: removed %d least recently seen unknown senders", removed)
+			}
+		}
+	}
+}
+
+func (q *Quarantine) flush(batch []QuarantinedLog) {
+	if len(batch) == 0 {
+		return
+	}
+	err := q.db.InsertQuarantineBatch(batch, quarantinePerSource)
+	for _, item := range batch {
+		item.Entry.saved(err)
+	}
+	if err != nil {
+		log.Printf("Quarantine: failed to store %d messages: %v", len(batch), err)
+	}
+}
+
+// adoptUnknownSender creates a device for a quarantined sender and, if
+// backfill is set, saves its quarantined messages as logs of that device.
+// The sender is removed from quarantine either way.
+func (s *Server) adoptUnknownSender(sourceIP string, device DeviceConfig, backfill bool) (*DeviceConfig, int, error) {
+	sender, err := s.db.GetUnknownSender(sourceIP)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if device.Name == "" {
+		device.Name = sender.Hostname
+		if device.Name == "" {
+			device.Name = sender.SourceIP
+		}
+	}
+	if device.DeviceType == "" {
+		device.DeviceType = sender.SuggestedDeviceType
+		if device.DeviceType == "" {
+			device.DeviceType = "generic"
+		}
+	}
+	if device.ListenerID == "" {
+		device.ListenerID = sender.ListenerID
+	}
+	if device.ListenerID == "" {
+		return nil, 0, errors.New("listener_id is required")
+	}
+	if len(device.IPAddresses) == 0 {
+		device.IPAddresses = []string{sender.SourceIP}
+	}
+	if err := validateDevice(&device); err != nil {
+		return nil, 0, err
+	}
+	if device.ID == "" {
+		device.ID = fmt.Sprintf("device-%d", time.Now().UnixNano())
+	}
+
+	// Load before the device exists so messages arriving meanwhile aren't doubled
+	var quarantined []QuarantinedLog
+	if backfill {
+		quarantined, err = s.db.GetQuarantinedLogs(sourceIP, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	s.config.Devices = append(s.config.Devices, device)
+	if err := SaveConfig("config.json", s.config); err != nil {
+		s.config.Devices = s.config.Devices[:len(s.config.Devices)-1]
+		return nil, 0, err
+	}
+	s.devices.SetDevices(s.config.Devices)
+
+	backfilled := 0
+	for _, item := range quarantined {
+		s.applyDevice(item.Entry, &device)
+		if err := s.writer.Enqueue(item.Entry, item.Protocol, item.RFCFormat); err != nil {
+			log.Printf("Quarantine: failed to backfill message from %s: %v", sourceIP, err)
+			break
+		}
+		backfilled++
+	}
+
+	if err := s.db.DeleteUnknownSender(sourceIP); err != nil {
+		log.Printf("Quarantine: failed to clear adopted sender %s: %v", sourceIP, err)
+	}
+
+	log.Printf("Adopted unknown sender %s as device %q (%s), backfilled %d messages", sourceIP, device.Name, device.DeviceType, backfilled)
+	return &device, backfilled, nil
+}
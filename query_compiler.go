@@ -0,0 +1,441 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// qLog query language: compilation to parameterized SQL against logs.
+// User input only ever reaches SQL as bound parameters; identifiers are
+// either whitelisted columns or validated aliases.
+
+const qlDefaultLimit = 100
+
+// qlColumns are the logs columns that can be referenced directly
+var qlColumns = map[string]bool{
+	"id": true, "timestamp": true, "priority": true, "facility": true, "severity": true,
+	"version": true, "hostname": true, "appname": true, "procid": true, "msgid": true,
+	"message": true, "raw_message": true, "remote_addr": true, "protocol": true,
+	"rfc_format": true, "device_type": true, "event_type": true, "event_category": true,
+	"listener_id": true, "received_at": true, "device_id": true, "created_at": true,
+	"client_identity": true,
+}
+
+// qlNumericColumns compare numerically
+var qlNumericColumns = map[string]bool{
+	"id": true, "priority": true, "facility": true, "severity": true, "version": true,
+}
+
+// qlDefaultFields are returned when no fields or stats command is given
+var qlDefaultFields = []string{"id", "timestamp", "severity", "hostname", "remote_addr", "device_type", "event_type", "message"}
+
+var qlSeverityNames = map[string]int{
+	"emergency": 0, "emerg": 0, "alert": 1, "critical": 2, "crit": 2,
+	"error": 3, "err": 3, "warning": 4, "warn": 4, "notice": 5,
+	"informational": 6, "info": 6, "debug": 7,
+}
+
+// sqlFragment is a piece of SQL and the arguments for its placeholders
+type sqlFragment struct {
+	sql  string
+	args []interface{}
+}
+
+// CompiledQuery is a parameterized SQL statement
+type CompiledQuery struct {
+	SQL  string
+	Args []interface{}
+}
+
+type qlCompiler struct {
+	useFTS bool
+	// outputs holds stats output column names; when set, fields in where
+	// and sort refer to stats output instead of logs columns
+	outputs map[string]bool
+}
+
+// quoteIdent quotes a validated identifier for use as a column alias
+func quoteIdent(name string) string {
+	return `"` + name + `"`
+}
+
+// fieldExpr returns the SQL expression for a field
+func (c *qlCompiler) fieldExpr(field qlField) (sqlFragment, error) {
+	if c.outputs != nil {
+		if !c.outputs[field.Name] {
+			return sqlFragment{}, queryErrorf(field.Pos, "unknown field '%s' after stats (available: %s)", field.Name, strings.Join(sortedKeys(c.outputs), ", "))
+		}
+		return sqlFragment{sql: quoteIdent(field.Name)}, nil
+	}
+
+	if qlColumns[field.Name] {
+		return sqlFragment{sql: field.Name}, nil
+	}
+
+	path := strings.TrimPrefix(field.Name, "parsed_fields.")
+	if path == "parsed_fields" || path == "" {
+		return sqlFragment{}, queryErrorf(field.Pos, "parsed_fields needs a path, e.g. parsed_fields.src")
+	}
+	return sqlFragment{sql: "json_extract(parsed_fields, ?)", args: []interface{}{"$." + path}}, nil
+}
+
+// isParsedField reports whether a field is read from parsed_fields JSON
+func (c *qlCompiler) isParsedField(field qlField) bool {
+	return c.outputs == nil && !qlColumns[field.Name]
+}
+
+func (c *qlCompiler) compileExpr(expr qlExpr) (sqlFragment, error) {
+	switch e := expr.(type) {
+	case *qlBinary:
+		left, err := c.compileExpr(e.Left)
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		right, err := c.compileExpr(e.Right)
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		return sqlFragment{
+			sql:  "(" + left.sql + " " + e.Op + " " + right.sql + ")",
+			args: append(left.args, right.args...),
+		}, nil
+
+	case *qlNot:
+		inner, err := c.compileExpr(e.Expr)
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		return sqlFragment{sql: "NOT " + inner.sql, args: inner.args}, nil
+
+	case *qlText:
+		return c.compileText(e)
+
+	case *qlCompare:
+		return c.compileCompare(e)
+	}
+
+	return sqlFragment{}, queryErrorf(expr.exprPos(), "unsupported expression")
+}
+
+func (c *qlCompiler) compileText(text *qlText) (sqlFragment, error) {
+	if c.outputs != nil {
+		return sqlFragment{}, queryErrorf(text.Pos, "free-text search is not allowed after stats; use field=value")
+	}
+
+	term := text.Text
+	prefix := strings.HasSuffix(term, "*")
+	term = strings.TrimRight(term, "*")
+
+	if c.useFTS && hasSearchableChars(term) {
+		ftsQuery := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			ftsQuery += "*"
+		}
+		return sqlFragment{
+			sql:  "id IN (SELECT rowid FROM logs_fts WHERE logs_fts MATCH ?)",
+			args: []interface{}{ftsQuery},
+		}, nil
+	}
+
+	pattern := "%" + escapeLike(term) + "%"
+	return sqlFragment{
+		sql:  `(raw_message LIKE ? ESCAPE '\' OR message LIKE ? ESCAPE '\')`,
+		args: []interface{}{pattern, pattern},
+	}, nil
+}
+
+func (c *qlCompiler) compileCompare(cmp *qlCompare) (sqlFragment, error) {
+	expr, err := c.fieldExpr(cmp.Field)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	parsed := c.isParsedField(cmp.Field)
+	value := cmp.Value
+
+	// Severity accepts names as well as numbers
+	if cmp.Field.Name == "severity" && c.outputs == nil {
+		if n, ok := qlSeverityNames[strings.ToLower(value)]; ok {
+			value = strconv.Itoa(n)
+		}
+	}
+
+	switch cmp.Op {
+	case "=", "!=":
+		negate := cmp.Op == "!="
+
+		// cidr_match only accepts text, so rows without the field never match
+		if _, _, err := net.ParseCIDR(value); err == nil {
+			match := "cidr_match(CAST(" + expr.sql + " AS TEXT), ?)"
+			if negate {
+				match = "NOT " + match
+			}
+			args := append(append(append([]interface{}{}, expr.args...), expr.args...), value)
+			return sqlFragment{sql: "(" + expr.sql + " IS NOT NULL AND " + match + ")", args: args}, nil
+		}
+
+		if strings.Contains(value, "*") {
+			pattern := strings.ReplaceAll(escapeLike(value), "*", "%")
+			op := " LIKE "
+			if negate {
+				op = " NOT LIKE "
+			}
+			return sqlFragment{sql: expr.sql + op + `? ESCAPE '\'`, args: append(expr.args, pattern)}, nil
+		}
+
+		// JSON values may be numbers or strings, compare their text form
+		if parsed {
+			expr.sql = "CAST(" + expr.sql + " AS TEXT)"
+		}
+		return sqlFragment{sql: expr.sql + " " + cmp.Op + " ?", args: append(expr.args, c.bindValue(cmp.Field, value))}, nil
+
+	case "<", "<=", ">", ">=":
+		if parsed {
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				return sqlFragment{sql: "CAST(" + expr.sql + " AS REAL) " + cmp.Op + " ?", args: append(expr.args, n)}, nil
+			}
+		}
+		return sqlFragment{sql: expr.sql + " " + cmp.Op + " ?", args: append(expr.args, c.bindValue(cmp.Field, value))}, nil
+
+	case "~", "!~":
+		op := " LIKE "
+		if cmp.Op == "!~" {
+			op = " NOT LIKE "
+		}
+		return sqlFragment{sql: expr.sql + op + `? ESCAPE '\'`, args: append(expr.args, "%"+escapeLike(value)+"%")}, nil
+	}
+
+	return sqlFragment{}, queryErrorf(cmp.Pos, "unsupported operator %s", cmp.Op)
+}
+
+// bindValue converts a comparison value to the type the field compares as
+func (c *qlCompiler) bindValue(field qlField, value string) interface{} {
+	if c.outputs != nil || qlNumericColumns[field.Name] {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+// CompileQuery compiles a parsed query to SQL. timeFilter is a trusted SQL
+// condition applied to every query; useFTS enables the full-text index for
+// free-text terms.
+func CompileQuery(query *QLQuery, timeFilter string, useFTS bool) (*CompiledQuery, error) {
+	c := &qlCompiler{useFTS: useFTS}
+
+	var where []sqlFragment
+	if timeFilter != "" {
+		where = append(where, sqlFragment{sql: timeFilter})
+	}
+	if query.Search != nil {
+		frag, err := c.compileExpr(query.Search)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, frag)
+	}
+
+	var (
+		selectCols []sqlFragment
+		groupBy    []string
+		having     []sqlFragment
+		orderBy    []sqlFragment
+		limit      = qlDefaultLimit
+		stats      *qlStats
+		fields     *qlFields
+	)
+
+	for _, command := range query.Commands {
+		switch cmd := command.(type) {
+		case *qlWhere:
+			frag, err := c.compileExpr(cmd.Expr)
+			if err != nil {
+				return nil, err
+			}
+			if stats != nil {
+				having = append(having, frag)
+			} else {
+				where = append(where, frag)
+			}
+
+		case *qlStats:
+			if stats != nil {
+				return nil, queryErrorf(cmd.Pos, "only one stats command is allowed")
+			}
+			if fields != nil {
+				return nil, queryErrorf(cmd.Pos, "stats cannot be combined with fields")
+			}
+			if len(orderBy) > 0 {
+				return nil, queryErrorf(cmd.Pos, "sort must come after stats")
+			}
+			stats = cmd
+
+			outputs := make(map[string]bool)
+			for _, field := range cmd.By {
+				expr, err := c.fieldExpr(field)
+				if err != nil {
+					return nil, err
+				}
+				if outputs[field.Name] {
+					return nil, queryErrorf(field.Pos, "duplicate column '%s'", field.Name)
+				}
+				outputs[field.Name] = true
+				selectCols = append(selectCols, sqlFragment{sql: expr.sql + " AS " + quoteIdent(field.Name), args: expr.args})
+				groupBy = append(groupBy, quoteIdent(field.Name))
+			}
+			for _, agg := range cmd.Aggregates {
+				frag, err := c.compileAggregate(agg)
+				if err != nil {
+					return nil, err
+				}
+				if outputs[agg.Alias] {
+					return nil, queryErrorf(agg.Pos, "duplicate column '%s' (use 'as' to rename)", agg.Alias)
+				}
+				outputs[agg.Alias] = true
+				selectCols = append(selectCols, frag)
+			}
+			c.outputs = outputs
+
+		case *qlFields:
+			if stats != nil {
+				return nil, queryErrorf(cmd.Pos, "fields cannot be used after stats")
+			}
+			fields = cmd
+			selectCols = nil
+			for _, field := range cmd.Fields {
+				expr, err := c.fieldExpr(field)
+				if err != nil {
+					return nil, err
+				}
+				selectCols = append(selectCols, sqlFragment{sql: expr.sql + " AS " + quoteIdent(field.Name), args: expr.args})
+			}
+
+		case *qlSort:
+			orderBy = nil
+			for _, key := range cmd.Keys {
+				expr, err := c.fieldExpr(qlField{Name: key.Name, Pos: key.Pos})
+				if err != nil {
+					return nil, err
+				}
+				if key.Desc {
+					expr.sql += " DESC"
+				}
+				orderBy = append(orderBy, expr)
+			}
+
+		case *qlHead:
+			limit = cmd.N
+		}
+	}
+
+	if selectCols == nil {
+		for _, name := range qlDefaultFields {
+			selectCols = append(selectCols, sqlFragment{sql: name})
+		}
+	}
+	if orderBy == nil && stats == nil {
+		orderBy = []sqlFragment{{sql: "timestamp DESC"}}
+	}
+
+	// Assemble in placeholder order: SELECT, WHERE, GROUP BY, HAVING, ORDER BY, LIMIT
+	var sb strings.Builder
+	args := []interface{}{}
+
+	sb.WriteString("SELECT ")
+	args = appendJoined(&sb, selectCols, ", ", args)
+	sb.WriteString(" FROM logs")
+	if len(where) > 0 {
+		sb.WriteString(" WHERE ")
+		args = appendJoined(&sb, where, " AND ", args)
+	}
+	if len(groupBy) > 0 {
+		sb.WriteString(" GROUP BY " + strings.Join(groupBy, ", "))
+	}
+	if len(having) > 0 {
+		sb.WriteString(" HAVING ")
+		args = appendJoined(&sb, having, " AND ", args)
+	}
+	if len(orderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		args = appendJoined(&sb, orderBy, ", ", args)
+	}
+	sb.WriteString(" LIMIT ?")
+	args = append(args, limit)
+
+	return &CompiledQuery{SQL: sb.String(), Args: args}, nil
+}
+
+func (c *qlCompiler) compileAggregate(agg qlAggregate) (sqlFragment, error) {
+	alias := " AS " + quoteIdent(agg.Alias)
+	if agg.Field == nil {
+		return sqlFragment{sql: "COUNT(*)" + alias}, nil
+	}
+
+	expr, err := c.fieldExpr(*agg.Field)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+
+	switch agg.Func {
+	case "count":
+		expr.sql = "COUNT(" + expr.sql + ")"
+	case "dc":
+		expr.sql = "COUNT(DISTINCT " + expr.sql + ")"
+	case "sum", "avg", "min", "max":
+		expr.sql = strings.ToUpper(agg.Func) + "(" + expr.sql + ")"
+	default:
+		return sqlFragment{}, queryErrorf(agg.Pos, "unknown aggregate %s", agg.Func)
+	}
+	expr.sql += alias
+	return expr, nil
+}
+
+// appendJoined writes fragments separated by sep and returns args with theirs appended
+func appendJoined(sb *strings.Builder, frags []sqlFragment, sep string, args []interface{}) []interface{} {
+	for i, frag := range frags {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(frag.sql)
+		args = append(args, frag.args...)
+	}
+	return args
+}
+
+// cidrMatch reports whether value (an IP, optionally with a port) is inside
+// cidr. Registered as the SQLite function cidr_match.
+func cidrMatch(value, cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+
+	host := value
+	if h, _, err := net.SplitHostPort(value); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && network.Contains(ip)
+}
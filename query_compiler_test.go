@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// TestCompileQueryCIDRWithNulls runs CIDR filters against rows where the
+// filtered field is missing or NULL
+func TestCompileQueryCIDRWithNulls(t *testing.T) {
+	db, err := sql.Open(sqliteDriver, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE logs (
+		id INTEGER PRIMARY KEY, timestamp DATETIME, severity INTEGER, hostname TEXT,
+		remote_addr TEXT, device_type TEXT, event_type TEXT, message TEXT,
+		listener_id TEXT, device_id TEXT, parsed_fields TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO logs (id, remote_addr, device_id, parsed_fields) VALUES
+		(1, '10.1.2.3:514', NULL, '{"src_ip":"10.0.0.5"}'),
+		(2, '192.168.1.1:514', 'dev', '{"src_ip":"192.168.0.9"}'),
+		(3, NULL, NULL, NULL),
+		(4, '10.9.9.9:514', NULL, '{"src_ip":42}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"src_ip=10.0.0.0/8", []int64{1}},
+		{"src_ip!=10.0.0.0/8", []int64{2, 4}},
+		{"remote_addr=10.0.0.0/8", []int64{1, 4}},
+		{"device_id=10.0.0.0/8", nil},
+	}
+	for _, tt := range tests {
+		parsed, err := ParseQuery(tt.query + " | sort id")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		compiled, err := CompileQuery(parsed, "", false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		rows, err := db.Query(compiled.SQL, compiled.Args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var got []int64
+		for rows.Next() {
+			columns, _ := rows.Columns()
+			values := make([]interface{}, len(columns))
+			ptrs := make([]interface{}, len(columns))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatalf("%s: %v", tt.query, err)
+			}
+			got = append(got, values[0].(int64))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		rows.Close()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got ids %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got ids %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// qLog query language: parsing
+//
+// A query is a search expression followed by piped commands:
+//
+//	device_type=meraki severity<=3 src_ip=10.0.0.0/8 | stats count by event_type | sort -count | head 20
+//
+// Search expression:
+//
+//	field=value  field!=value            equality (* in value is a wildcard, CIDR values match IPs)
+//	field<value  field<=value ...        ordering comparisons
+//	field~value  field!~value            contains / does not contain
+//	word  "quoted phrase"                free-text search
+//	a b  a AND b  a OR b  NOT a  ( )     boolean logic (AND is implicit)
+//
+// Fields are log columns (severity, hostname, event_type, ...). Any other
+// name, or parsed_fields.<path>, refers to a field parsed by a device module.
+//
+// Commands:
+//
+//	where <expression>                   filter (after stats, filters on stats output)
+//	stats count, dc(f), sum(f), avg(f), min(f), max(f) [as name] [by f1, f2]
+//	sort [-]field, ...                   - for descending
+//	head [n] / limit [n]                 limit the number of rows
+//	fields f1, f2, ...                   choose output columns
+//
+// The AST is compiled to parameterized SQL in query_compiler.go.
+
+// QueryError is a query language error at a 1-based character position
+type QueryError struct {
+	Pos int    `json:"position"`
+	Msg string `json:"error"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Pos, e.Msg)
+}
+
+func queryErrorf(pos int, format string, args ...interface{}) *QueryError {
+	return &QueryError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Lexer
+
+type qlTokenKind int
+
+const (
+	qlEOF qlTokenKind = iota
+	qlWord
+	qlString
+	qlOperator // = != < <= > >= ~ !~
+	qlPipe
+	qlLParen
+	qlRParen
+	qlComma
+)
+
+type qlToken struct {
+	kind qlTokenKind
+	text string
+	pos  int
+}
+
+func (t qlToken) describe() string {
+	switch t.kind {
+	case qlEOF:
+		return "end of query"
+	case qlString:
+		return fmt.Sprintf("%q", t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// isWordBreak reports whether r ends a bare word
+func isWordBreak(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`|(),"'=!<>~`, r)
+}
+
+func lexQuery(input string) ([]qlToken, error) {
+	runes := []rune(input)
+	tokens := []qlToken{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '|':
+			tokens = append(tokens, qlToken{qlPipe, "|", pos})
+			i++
+		case r == '(':
+			tokens = append(tokens, qlToken{qlLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, qlToken{qlRParen, ")", pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, qlToken{qlComma, ",", pos})
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, qlToken{qlOperator, string(r), pos})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '!' && runes[i+1] == '~')) {
+				tokens = append(tokens, qlToken{qlOperator, string(runes[i : i+2]), pos})
+				i += 2
+				continue
+			}
+			if r == '!' {
+				return nil, queryErrorf(pos, "unexpected '!' (did you mean != or !~?)")
+			}
+			tokens = append(tokens, qlToken{qlOperator, string(r), pos})
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			closed := false
+			for j < len(runes) {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					sb.WriteRune(runes[j+1])
+					j += 2
+					continue
+				}
+				if runes[j] == r {
+					closed = true
+					break
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			if !closed {
+				return nil, queryErrorf(pos, "unterminated string")
+			}
+			tokens = append(tokens, qlToken{qlString, sb.String(), pos})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !isWordBreak(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, qlToken{qlWord, string(runes[i:j]), pos})
+			i = j
+		}
+	}
+
+	tokens = append(tokens, qlToken{qlEOF, "", len(runes) + 1})
+	return tokens, nil
+}
+
+// AST
+
+// qlExpr is a search/filter expression node
+type qlExpr interface {
+	exprPos() int
+}
+
+type qlBinary struct {
+	Op          string // AND, OR
+	Left, Right qlExpr
+	Pos         int
+}
+
+type qlNot struct {
+	Expr qlExpr
+	Pos  int
+}
+
+type qlCompare struct {
+	Field qlField
+	Op    string
+	Value string
+	Pos   int
+}
+
+type qlText struct {
+	Text string
+	Pos  int
+}
+
+func (e *qlBinary) exprPos() int  { return e.Pos }
+func (e *qlNot) exprPos() int     { return e.Pos }
+func (e *qlCompare) exprPos() int { return e.Pos }
+func (e *qlText) exprPos() int    { return e.Pos }
+
+// qlField is a field reference: a logs column or a parsed_fields path
+type qlField struct {
+	Name string // as written, used as the output column name
+	Pos  int
+}
+
+type qlCommand interface {
+	commandPos() int
+}
+
+type qlWhere struct {
+	Expr qlExpr
+	Pos  int
+}
+
+type qlAggregate struct {
+	Func  string   // count, dc, sum, avg, min, max
+	Field *qlField // nil for count
+	Alias string
+	Pos   int
+}
+
+type qlStats struct {
+	Aggregates []qlAggregate
+	By         []qlField
+	Pos        int
+}
+
+type qlSortKey struct {
+	Name string
+	Desc bool
+	Pos  int
+}
+
+type qlSort struct {
+	Keys []qlSortKey
+	Pos  int
+}
+
+type qlHead struct {
+	N   int
+	Pos int
+}
+
+type qlFields struct {
+	Fields []qlField
+	Pos    int
+}
+
+func (c *qlWhere) commandPos() int  { return c.Pos }
+func (c *qlStats) commandPos() int  { return c.Pos }
+func (c *qlSort) commandPos() int   { return c.Pos }
+func (c *qlHead) commandPos() int   { return c.Pos }
+func (c *qlFields) commandPos() int { return c.Pos }
+
+// QLQuery is a parsed query
+type QLQuery struct {
+	Search   qlExpr // nil matches everything
+	Commands []qlCommand
+}
+
+// Parser
+
+const qlMaxHead = 10000
+
+var (
+	qlFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z0-9_]+)*$`)
+	qlAliasPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	qlAggregates   = map[string]bool{"count": true, "dc": true, "sum": true, "avg": true, "min": true, "max": true}
+)
+
+type qlParser struct {
+	tokens []qlToken
+	pos    int
+}
+
+// ParseQuery parses a query language string into an AST
+func ParseQuery(input string) (*QLQuery, error) {
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &qlParser{tokens: tokens}
+	query := &QLQuery{}
+
+	if !p.at(qlPipe) && !p.at(qlEOF) {
+		if query.Search, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	for p.at(qlPipe) {
+		p.next()
+		command, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		query.Commands = append(query.Commands, command)
+	}
+
+	if !p.at(qlEOF) {
+		return nil, p.unexpected("'|' or end of query")
+	}
+
+	return query, nil
+}
+
+func (p *qlParser) peek() qlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *qlParser) next() qlToken {
+	token := p.tokens[p.pos]
+	if token.kind != qlEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *qlParser) at(kind qlTokenKind) bool {
+	return p.peek().kind == kind
+}
+
+// atKeyword reports whether the next token is the given bare word (case-insensitive)
+func (p *qlParser) atKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == qlWord && strings.EqualFold(token.text, keyword)
+}
+
+func (p *qlParser) unexpected(expected string) error {
+	token := p.peek()
+	return queryErrorf(token.pos, "expected %s, found %s", expected, token.describe())
+}
+
+// parseExpr parses OR expressions (lowest precedence)
+func (p *qlParser) parseExpr() (qlExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.atKeyword("OR") {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &qlBinary{Op: "OR", Left: left, Right: right, Pos: op.pos}
+	}
+	return left, nil
+}
+
+// parseAnd parses explicit and implicit AND
+func (p *qlParser) parseAnd() (qlExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		pos := p.peek().pos
+		if p.atKeyword("AND") {
+			p.next()
+		} else if !p.startsPrimary() {
+			return left, nil
+		}
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &qlBinary{Op: "AND", Left: left, Right: right, Pos: pos}
+	}
+}
+
+// startsPrimary reports whether the next token can start an operand
+func (p *qlParser) startsPrimary() bool {
+	switch p.peek().kind {
+	case qlLParen, qlString:
+		return true
+	case qlWord:
+		return !p.atKeyword("OR")
+	}
+	return false
+}
+
+func (p *qlParser) parseNot() (qlExpr, error) {
+	if p.atKeyword("NOT") {
+		token := p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &qlNot{Expr: expr, Pos: token.pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *qlParser) parsePrimary() (qlExpr, error) {
+	token := p.peek()
+
+	switch token.kind {
+	case qlLParen:
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.at(qlRParen) {
+			return nil, p.unexpected("')'")
+		}
+		p.next()
+		return expr, nil
+
+	case qlString:
+		p.next()
+		return &qlText{Text: token.text, Pos: token.pos}, nil
+
+	case qlWord:
+		if strings.EqualFold(token.text, "AND") || strings.EqualFold(token.text, "OR") {
+			return nil, queryErrorf(token.pos, "%s needs an expression on both sides", strings.ToUpper(token.text))
+		}
+		p.next()
+
+		// A word followed by an operator is a comparison, otherwise free text
+		if !p.at(qlOperator) {
+			return &qlText{Text: token.text, Pos: token.pos}, nil
+		}
+
+		field, err := newField(token)
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+
+		value := p.peek()
+		if value.kind != qlWord && value.kind != qlString {
+			return nil, p.unexpected("a value after " + op.text)
+		}
+		p.next()
+		return &qlCompare{Field: field, Op: op.text, Value: value.text, Pos: token.pos}, nil
+	}
+
+	return nil, p.unexpected("a search term or comparison")
+}
+
+func newField(token qlToken) (qlField, error) {
+	if token.kind != qlWord || !qlFieldPattern.MatchString(token.text) {
+		return qlField{}, queryErrorf(token.pos, "invalid field name %s", token.describe())
+	}
+	return qlField{Name: token.text, Pos: token.pos}, nil
+}
+
+func (p *qlParser) parseField() (qlField, error) {
+	token := p.peek()
+	if token.kind != qlWord {
+		return qlField{}, p.unexpected("a field name")
+	}
+	p.next()
+	return newField(token)
+}
+
+// parseFieldList parses fields separated by commas and/or spaces
+func (p *qlParser) parseFieldList() ([]qlField, error) {
+	fields := []qlField{}
+	for {
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+
+		if p.at(qlComma) {
+			p.next()
+			continue
+		}
+		if !p.at(qlWord) {
+			return fields, nil
+		}
+	}
+}
+
+func (p *qlParser) parseCommand() (qlCommand, error) {
+	token := p.peek()
+	if token.kind != qlWord {
+		return nil, p.unexpected("a command (where, stats, sort, head, fields)")
+	}
+	p.next()
+
+	switch strings.ToLower(token.text) {
+	case "where":
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &qlWhere{Expr: expr, Pos: token.pos}, nil
+	case "stats":
+		return p.parseStats(token.pos)
+	case "sort":
+		return p.parseSort(token.pos)
+	case "head", "limit":
+		return p.parseHead(token.pos)
+	case "fields":
+		fields, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		return &qlFields{Fields: fields, Pos: token.pos}, nil
+	}
+
+	return nil, queryErrorf(token.pos, "unknown command '%s' (expected where, stats, sort, head or fields)", token.text)
+}
+
+func (p *qlParser) parseStats(pos int) (qlCommand, error) {
+	stats := &qlStats{Pos: pos}
+
+	for {
+		token := p.peek()
+		if token.kind != qlWord || !qlAggregates[strings.ToLower(token.text)] {
+			return nil, p.unexpected("an aggregate (count, dc, sum, avg, min, max)")
+		}
+		p.next()
+
+		agg := qlAggregate{Func: strings.ToLower(token.text), Pos: token.pos}
+		if p.at(qlLParen) {
+			p.next()
+			field, err := p.parseField()
+			if err != nil {
+				return nil, err
+			}
+			agg.Field = &field
+			if !p.at(qlRParen) {
+				return nil, p.unexpected("')'")
+			}
+			p.next()
+		} else if agg.Func != "count" {
+			return nil, p.unexpected("'(' after " + agg.Func)
+		}
+
+		if p.atKeyword("as") {
+			p.next()
+			alias := p.peek()
+			if alias.kind != qlWord || !qlAliasPattern.MatchString(alias.text) {
+				return nil, p.unexpected("a column name after 'as'")
+			}
+			p.next()
+			agg.Alias = alias.text
+		} else {
+			agg.Alias = agg.Func
+			if agg.Field != nil {
+				agg.Alias += "_" + strings.ReplaceAll(agg.Field.Name, ".", "_")
+			}
+		}
+
+		stats.Aggregates = append(stats.Aggregates, agg)
+
+		if p.at(qlComma) {
+			p.next()
+			continue
+		}
+		if p.at(qlWord) && !p.atKeyword("by") {
+			continue
+		}
+		break
+	}
+
+	if p.atKeyword("by") {
+		p.next()
+		by, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		stats.By = by
+	}
+
+	return stats, nil
+}
+
+func (p *qlParser) parseSort(pos int) (qlCommand, error) {
+	sort := &qlSort{Pos: pos}
+
+	for {
+		token := p.peek()
+		if token.kind != qlWord && token.kind != qlString {
+			return nil, p.unexpected("a field to sort by")
+		}
+		p.next()
+
+		key := qlSortKey{Name: token.text, Pos: token.pos}
+		if token.kind == qlWord {
+			if strings.HasPrefix(key.Name, "-") {
+				key.Desc = true
+				key.Name = key.Name[1:]
+			} else {
+				key.Name = strings.TrimPrefix(key.Name, "+")
+			}
+		}
+		if !qlFieldPattern.MatchString(key.Name) {
+			return nil, queryErrorf(token.pos, "invalid sort field %s", token.describe())
+		}
+		sort.Keys = append(sort.Keys, key)
+
+		if p.at(qlComma) {
+			p.next()
+			continue
+		}
+		if !p.at(qlWord) && !p.at(qlString) {
+			return sort, nil
+		}
+	}
+}
+
+func (p *qlParser) parseHead(pos int) (qlCommand, error) {
+	head := &qlHead{N: 10, Pos: pos}
+
+	token := p.peek()
+	if token.kind != qlWord {
+		return head, nil
+	}
+
+	n, err := strconv.Atoi(token.text)
+	if err != nil || n <= 0 {
+		return nil, queryErrorf(token.pos, "expected a positive number of rows, found %s", token.describe())
+	}
+	if n > qlMaxHead {
+		return nil, queryErrorf(token.pos, "at most %d rows can be returned", qlMaxHead)
+	}
+	p.next()
+	head.N = n
+	return head, nil
+}
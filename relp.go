@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RELP (Reliable Event Logging Protocol) listener. Every syslog command is
+// acknowledged only once its message is committed to the database, so
+// clients such as rsyslog's omrelp resend whatever was in flight when a
+// connection or qLog itself goes away.
+
+const (
+	relpWindow       = 1024 // Max unacknowledged messages per connection
+	relpWriteTimeout = 30 * time.Second
+	relpMaxTxnr      = 999999999
+	relpOpenResponse = "200 OK\nrelp_version=0\nrelp_software=qlog\ncommands=syslog"
+)
+
+// relpFrame is one RELP command: TXNR SP COMMAND SP DATALEN [SP DATA] LF
+type relpFrame struct {
+	txnr    int64
+	command string
+	data    []byte
+}
+
+// readRELPFrame reads the next frame from r. It returns io.EOF if the
+// stream ends between frames and io.ErrUnexpectedEOF if it ends inside one.
+func readRELPFrame(r *bufio.Reader, maxSize int) (relpFrame, error) {
+	var frame relpFrame
+
+	txnr, delim, err := readRELPToken(r, 9)
+	if err != nil {
+		return frame, err
+	}
+	frame.txnr, err = strconv.ParseInt(txnr, 10, 64)
+	if err != nil || delim != ' ' || frame.txnr < 0 || frame.txnr > relpMaxTxnr {
+		return frame, fmt.Errorf("invalid RELP transaction number %q", txnr)
+	}
+
+	command, delim, err := readRELPToken(r, 32)
+	if err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	if command == "" || delim != ' ' {
+		return frame, fmt.Errorf("invalid RELP command %q", command)
+	}
+	frame.command = command
+
+	dataLen, delim, err := readRELPToken(r, 9)
+	if err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	size, err := strconv.Atoi(dataLen)
+	if err != nil || size < 0 {
+		return frame, fmt.Errorf("invalid RELP data length %q", dataLen)
+	}
+	if size > maxSize {
+		return frame, fmt.Errorf("%w: RELP frame of %d bytes exceeds the %d byte limit", errFrameTooLarge, size, maxSize)
+	}
+	if delim == '\n' {
+		// DATALEN 0 has no data, the LF is the trailer
+		if size != 0 {
+			return frame, fmt.Errorf("RELP frame is missing %d bytes of data", size)
+		}
+		return frame, nil
+	}
+
+	frame.data = make([]byte, size)
+	if _, err := io.ReadFull(r, frame.data); err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	trailer, err := r.ReadByte()
+	if err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	if trailer != '\n' {
+		return frame, fmt.Errorf("RELP frame trailer is %q, expected LF", trailer)
+	}
+	return frame, nil
+}
+
+// readRELPToken reads up to maxLen bytes until a space or LF, returning the
+// token and the delimiter
+func readRELPToken(r *bufio.Reader, maxLen int) (string, byte, error) {
+	var token []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", 0, err
+		}
+		if b == ' ' || b == '\n' {
+			return string(token), b, nil
+		}
+		if len(token) == maxLen {
+			return "", 0, fmt.Errorf("RELP header field longer than %d bytes", maxLen)
+		}
+		token = append(token, b)
+	}
+}
+
+// unexpectedEOF maps io.EOF inside a frame to io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// relpAck is the outcome of storing the message of one syslog command
+type relpAck struct {
+	txnr int64
+	err  error
+}
+
+// relpConn is a RELP session. Acks arrive from the log writer in commit
+// order and are sent by their own goroutine; the window bounds how many
+// messages may be unacknowledged so the writer never blocks on a client.
+type relpConn struct {
+	conn     net.Conn
+	writeMu  sync.Mutex
+	acks     chan relpAck
+	window   chan struct{}
+	pending  sync.WaitGroup
+	acksDone chan struct{}
+	stopping atomic.Bool
+}
+
+func newRELPConn(conn net.Conn) *relpConn {
+	c := &relpConn{
+		conn:     conn,
+		acks:     make(chan relpAck, relpWindow),
+		window:   make(chan struct{}, relpWindow),
+		acksDone: make(chan struct{}),
+	}
+	go c.sendAcks()
+	return c
+}
+
+// send writes one frame to the client
+func (c *relpConn) send(txnr int64, command, data string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(relpWriteTimeout))
+	var err error
+	if data == "" {
+		_, err = fmt.Fprintf(c.conn, "%d %s 0\n", txnr, command)
+	} else {
+		_, err = fmt.Fprintf(c.conn, "%d %s %d %s\n", txnr, command, len(data), data)
+	}
+	return err
+}
+
+// respond sends a rsp frame for txnr
+func (c *relpConn) respond(txnr int64, data string) error {
+	return c.send(txnr, "rsp", data)
+}
+
+func (c *relpConn) sendAcks() {
+	defer close(c.acksDone)
+	for ack := range c.acks {
+		if ack.err != nil {
+			c.respond(ack.txnr, "500 "+ack.err.Error())
+		} else {
+			c.respond(ack.txnr, "200 OK")
+		}
+		<-c.window
+		c.pending.Done()
+	}
+}
+
+// track reserves a window slot for txnr and returns the callback that
+// acknowledges it once the message is stored
+func (c *relpConn) track(txnr int64) func(err error) {
+	c.window <- struct{}{}
+	c.pending.Add(1)
+	return func(err error) {
+		c.acks <- relpAck{txnr: txnr, err: err}
+	}
+}
+
+// finish waits for outstanding acks to be sent, tells the client the server
+// is closing the session if it is being stopped, and closes the connection
+func (c *relpConn) finish() {
+	c.pending.Wait()
+	close(c.acks)
+	<-c.acksDone
+	if c.stopping.Load() {
+		c.send(0, "serverclose", "")
+	}
+	c.conn.Close()
+}
+
+// stop makes the session stop reading new commands and shut down once its
+// outstanding messages are acknowledged
+func (c *relpConn) stop() {
+	c.stopping.Store(true)
+	c.conn.SetReadDeadline(time.Now())
+}
+
+func (s *Server) startRELPListener(listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter, certs *certStore) (func(), error) {
+	// TLS is used when the listener has a certificate and key uploaded
+	var tlsConfig *tls.Config
+	if certs != nil {
+		tlsConfig = certs.TLSConfig()
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", listener.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	tcpListener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("RELP syslog listener '%s' listening on port %d (tls: %t, parser: %s)",
+		listener.Name, listener.Port, tlsConfig != nil, listener.Parser)
+
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+
+	var connMu sync.Mutex
+	conns := make(map[*relpConn]struct{})
+	var handlers sync.WaitGroup
+
+	go func() {
+		defer tcpListener.Close()
+		defer close(done)
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			default:
+				tcpListener.SetDeadline(time.Now().Add(100 * time.Millisecond))
+				tcpConn, err := tcpListener.AcceptTCP()
+				if err != nil {
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						continue
+					}
+					if err != io.EOF {
+						log.Printf("RELP accept error: %v", err)
+					}
+					continue
+				}
+
+				var conn net.Conn = tcpConn
+				if tlsConfig != nil {
+					conn = tls.Server(tcpConn, tlsConfig)
+				}
+				session := newRELPConn(conn)
+
+				connMu.Lock()
+				conns[session] = struct{}{}
+				connMu.Unlock()
+
+				handlers.Add(1)
+				go func() {
+					defer handlers.Done()
+					s.handleRELPConnection(session, listener, parsers, stats, filter)
+
+					connMu.Lock()
+					delete(conns, session)
+					connMu.Unlock()
+				}()
+			}
+		}
+	}()
+
+	stopFunc := func() {
+		close(stopChan)
+		tcpListener.Close()
+		<-done
+
+		// Let open sessions acknowledge what they have received, then close them
+		connMu.Lock()
+		for session := range conns {
+			session.stop()
+		}
+		connMu.Unlock()
+		handlers.Wait()
+	}
+
+	return stopFunc, nil
+}
+
+// handleRELPConnection serves one RELP session until the client closes it,
+// the connection fails or the listener is stopped
+func (s *Server) handleRELPConnection(session *relpConn, listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter) {
+	defer session.finish()
+	remoteAddr := session.conn.RemoteAddr().String()
+
+	if !filter.Permits(remoteAddr) {
+		stats.RecordRefusedConnection()
+		log.Printf("Refused RELP connection from %s: not allowed by the listener's access lists", remoteAddr)
+		return
+	}
+
+	connection := stats.OpenConnection(remoteAddr, "RELP")
+	defer stats.CloseConnection(connection)
+	var identity, deviceID string
+	if tlsConn, ok := session.conn.(*tls.Conn); ok {
+		if err := tlsHandshake(tlsConn, connection); err != nil {
+			if !session.stopping.Load() {
+				log.Printf("RELP TLS handshake error from %s: %v", remoteAddr, err)
+			}
+			return
+		}
+		identity, deviceID = s.clientIdentity(tlsConn)
+	}
+
+	reader := bufio.NewReader(session.conn)
+	opened := false
+	for {
+		frame, err := readRELPFrame(reader, maxFrameSize)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) && !session.stopping.Load() {
+				connection.RecordReadError(err)
+				log.Printf("RELP connection error from %s: %v", remoteAddr, err)
+			}
+			return
+		}
+
+		switch frame.command {
+		case "open":
+			opened = true
+			if err := session.respond(frame.txnr, relpOpenResponse); err != nil {
+				return
+			}
+		case "syslog":
+			if !opened {
+				session.respond(frame.txnr, "500 session not open")
+				return
+			}
+			s.processMessage(ingestMessage{
+				data:           frame.data,
+				remoteAddr:     remoteAddr,
+				protocol:       "RELP",
+				parsers:        parsers,
+				listenerID:     listener.ID,
+				deviceID:       deviceID,
+				clientIdentity: identity,
+				connection:     connection,
+				filter:         filter,
+				receivedAt:     time.Now(),
+				onSaved:        session.track(frame.txnr),
+			})
+		case "close":
+			// Acknowledge everything received before confirming the close
+			session.pending.Wait()
+			session.respond(frame.txnr, "")
+			return
+		default:
+			if err := session.respond(frame.txnr, "500 unsupported command"); err != nil {
+				return
+			}
+		}
+	}
+}
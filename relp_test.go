@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestReadRELPFrame reads single frames, well-formed and not
+func TestReadRELPFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    relpFrame
+		wantErr error  // Matched with errors.Is
+		errText string // Substring of the error message
+	}{
+		{
+			name:  "open",
+			input: "1 open 86 relp_version=0\nrelp_software=librelp,1.2.18,http://librelp.adiscon.com\ncommands=syslog\n",
+			want:  relpFrame{txnr: 1, command: "open", data: []byte("relp_version=0\nrelp_software=librelp,1.2.18,http://librelp.adiscon.com\ncommands=syslog")},
+		},
+		{
+			name:  "syslog",
+			input: "2 syslog 39 <34>1 2024-01-01T00:00:00Z h a - - - hi\n",
+			want:  relpFrame{txnr: 2, command: "syslog", data: []byte("<34>1 2024-01-01T00:00:00Z h a - - - hi")},
+		},
+		{
+			name:  "syslog with line break in data",
+			input: "3 syslog 9 line1\nend\n",
+			want:  relpFrame{txnr: 3, command: "syslog", data: []byte("line1\nend")},
+		},
+		{
+			name:  "close without data",
+			input: "4 close 0\n",
+			want:  relpFrame{txnr: 4, command: "close"},
+		},
+		{
+			name:  "zero length with separator",
+			input: "5 close 0 \n",
+			want:  relpFrame{txnr: 5, command: "close", data: []byte{}},
+		},
+		{name: "empty stream", input: "", wantErr: io.EOF},
+		{name: "truncated transaction number", input: "12", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated command", input: "1 sys", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated data length", input: "1 syslog 1", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated data", input: "1 syslog 10 short", wantErr: io.ErrUnexpectedEOF},
+		{name: "missing trailer", input: "1 syslog 5 hello", wantErr: io.ErrUnexpectedEOF},
+		{name: "data length without data", input: "1 syslog 5\n", errText: "missing 5 bytes"},
+		{name: "wrong trailer", input: "1 syslog 5 helloX", errText: "trailer"},
+		{name: "non-numeric data length", input: "1 syslog abc data\n", errText: "invalid RELP data length"},
+		{name: "negative data length", input: "1 syslog -1 \n", errText: "invalid RELP data length"},
+		{name: "oversized frame", input: "1 syslog 70000 ", wantErr: errFrameTooLarge},
+		{name: "data length too long", input: "1 syslog 1234567890 ", errText: "longer than 9 bytes"},
+		{name: "non-numeric transaction number", input: "x open 0\n", errText: "invalid RELP transaction number"},
+		{name: "transaction number too large", input: "1234567890 open 0\n", errText: "longer than 9 bytes"},
+		{name: "empty command", input: "1  0\n", errText: "invalid RELP command"},
+		{name: "command ended by LF", input: "1 close\n", errText: "invalid RELP command"},
+	}
+
+	for _, tt := range tests {
+		frame, err := readRELPFrame(bufio.NewReader(strings.NewReader(tt.input)), maxFrameSize)
+		if tt.wantErr != nil || tt.errText != "" {
+			switch {
+			case err == nil:
+				t.Errorf("%s: got frame %+v, want an error", tt.name, frame)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			case tt.errText != "" && !strings.Contains(err.Error(), tt.errText):
+				t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if frame.txnr != tt.want.txnr || frame.command != tt.want.command || string(frame.data) != string(tt.want.data) {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", tt.name,
+				frame.txnr, frame.command, frame.data, tt.want.txnr, tt.want.command, tt.want.data)
+		}
+	}
+}
+
+// TestReadRELPFrameSession reads a whole session from one stream
+func TestReadRELPFrameSession(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("1 open 14 relp_version=0\n2 syslog 5 first\n3 syslog 6 second\n4 close 0\n"))
+	want := []relpFrame{
+		{txnr: 1, command: "open", data: []byte("relp_version=0")},
+		{txnr: 2, command: "syslog", data: []byte("first")},
+		{txnr: 3, command: "syslog", data: []byte("second")},
+		{txnr: 4, command: "close"},
+	}
+	for _, w := range want {
+		frame, err := readRELPFrame(r, maxFrameSize)
+		if err != nil {
+			t.Fatalf("frame %d: %v", w.txnr, err)
+		}
+		if frame.txnr != w.txnr || frame.command != w.command || string(frame.data) != string(w.data) {
+			t.Errorf("got %d %q %q, want %d %q %q", frame.txnr, frame.command, frame.data, w.txnr, w.command, w.data)
+		}
+	}
+	if _, err := readRELPFrame(r, maxFrameSize); err != io.EOF {
+		t.Errorf("after close: got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Log retention: enforces Database.Limit and RetentionConfig in the background
+
+const defaultRetentionInterval = 10 * time.Minute
+
+// RetentionResult reports what a retention run deleted
+type RetentionResult struct {
+	RanAt      time.Time        `json:"ran_at"`
+	DurationMs int64            `json:"duration_ms"`
+	ByAge      int64            `json:"by_age"`
+	ByRule     map[string]int64 `json:"by_rule,omitempty"` // rule index/description -> rows deleted
+	ByCount    int64            `json:"by_count"`
+	BySize     int64            `json:"by_size"`
+	Total      int64            `json:"total"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+// retentionState holds the result of the last retention run
+type retentionState struct {
+	mu      sync.RWMutex
+	running sync.Mutex
+	last    *RetentionResult
+}
+
+func (r *retentionState) Last() *RetentionResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.last
+}
+
+// enforceRetention periodically prunes logs according to the retention config
+func (s *Server) enforceRetention() {
+	for {
+		s.runRetention()
+
+		interval := defaultRetentionInterval
+		if s.config.Retention != nil && s.config.Retention.IntervalMinutes > 0 {
+			interval = time.Duration(s.config.Retention.IntervalMinutes) * time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+// runRetention performs a single retention pass and records the result.
+// Concurrent calls wait for the running pass rather than pruning twice.
+func (s *Server) runRetention() *RetentionResult {
+	s.retention.running.Lock()
+	defer s.retention.running.Unlock()
+
+	start := time.Now()
+	result := &RetentionResult{
+		RanAt:  start,
+		ByRule: make(map[string]int64),
+	}
+
+	recordErr := func(what string, err error) {
+		log.Printf("Retention: failed to %s: %v", what, err)
+		result.Errors = append(result.Errors, what+": "+err.Error())
+	}
+
+	retention := s.config.Retention
+	if retention != nil {
+		// Rules first - each rule excludes logs already covered by earlier rules
+		for i, rule := range retention.Rules {
+			if rule.MaxAgeDays <= 0 {
+				continue
+			}
+			cutoff := start.AddDate(0, 0, -rule.MaxAgeDays)
+			n, err := s.db.PruneLogsByAge(cutoff, &retention.Rules[i], retention.Rules[:i])
+			if err != nil {
+				recordErr("apply retention rule "+retentionRuleName(i, rule), err)
+			}
+			if n > 0 {
+				result.ByRule[retentionRuleName(i, rule)] = n
+			}
+		}
+
+		// Default max age applies to logs not matched by any rule
+		if retention.MaxAgeDays > 0 {
+			cutoff := start.AddDate(0, 0, -retention.MaxAgeDays)
+			n, err := s.db.PruneLogsByAge(cutoff, nil, retention.Rules)
+			if err != nil {
+				recordErr("prune logs by age", err)
+			}
+			result.ByAge = n
+		}
+	}
+
+	// Row and size limits are hard caps and ignore per-rule retention
+	if s.config.Database.Limit > 0 {
+		n, err := s.db.PruneLogsToCount(s.config.Database.Limit)
+		if err != nil {
+			recordErr("enforce row limit", err)
+		}
+		result.ByCount = n
+	}
+
+	if retention != nil && retention.MaxSizeMB > 0 {
+		n, err := s.db.PruneLogsToSize(int64(retention.MaxSizeMB) << 20)
+		if err != nil {
+			recordErr("enforce size limit", err)
+		}
+		result.BySize = n
+	}
+
+	result.Total = result.ByAge + result.ByCount + result.BySize
+	for _, n := range result.ByRule {
+		result.Total += n
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	if result.Total > 0 {
+		log.Printf("Retention: deleted %d logs (age: %d, rules: %v, row limit: %d, size limit: %d) in %dms",
+			result.Total, result.ByAge, result.ByRule, result.ByCount, result.BySize, result.DurationMs)
+	}
+
+	s.retention.mu.Lock()
+	s.retention.last = result
+	s.retention.mu.Unlock()
+
+	return result
+}
+
+// retentionRuleName returns a readable identifier for a rule in results
+func retentionRuleName(index int, rule RetentionRule) string {
+	name := fmt.Sprintf("rule %d", index+1)
+	if rule.DeviceType != "" {
+		name += " (" + rule.DeviceType + ")"
+	}
+	return name
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Full-text search query translation
+
+// ftsToken is a single element of a user search query
+type ftsToken struct {
+	text     string // term or phrase text (unquoted), or the operator/paren itself
+	operator bool   // AND, OR, NOT, ( or )
+	prefix   bool   // trailing * (prefix match)
+}
+
+// buildFTSQuery translates a user search string into an FTS5 MATCH
+// expression. Supported syntax:
+//
+//	error timeout          both terms (implicit AND)
+//	"link down"            exact phrase
+//	deny*                  prefix match
+//	vpn AND (up OR down)   boolean operators (uppercase) and grouping
+//	vpn NOT anyconnect     exclusion
+//
+// Every term is quoted so punctuation (IPs, MACs, URLs) can't produce FTS5
+// syntax errors. If the operators don't form a valid expression they are
+// searched for as plain terms instead. Returns "" if there is nothing to match.
+func buildFTSQuery(search string) string {
+	return strings.Join(ftsQueryParts(search), " ")
+}
+
+// ftsQueryParts returns the operators, parentheses and quoted phrases of
+// the FTS5 expression for search, or nil if there is nothing to match
+func ftsQueryParts(search string) []string {
+	tokens := tokenizeSearch(search)
+
+	if !validSearchExpression(tokens) {
+		// Treat everything as plain terms
+		plain := make([]ftsToken, 0, len(tokens))
+		for _, token := range tokens {
+			if token.operator && (token.text == "(" || token.text == ")") {
+				continue
+			}
+			token.operator = false
+			plain = append(plain, token)
+		}
+		tokens = plain
+	}
+
+	parts := make([]string, 0, len(tokens))
+	afterOperand := false
+	for _, token := range tokens {
+		if token.operator && token.text != "(" {
+			parts = append(parts, token.text)
+			afterOperand = token.text == ")"
+			continue
+		}
+		if !token.operator && !hasSearchableChars(token.text) {
+			continue
+		}
+
+		// FTS5 only allows implicit AND between phrases, not next to a group
+		if afterOperand {
+			parts = append(parts, "AND")
+		}
+
+		if token.operator {
+			parts = append(parts, token.text)
+			afterOperand = false
+			continue
+		}
+
+		part := `"` + strings.ReplaceAll(token.text, `"`, `""`) + `"`
+		if token.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+		afterOperand = true
+	}
+
+	// Dropping unsearchable terms can leave a dangling operator
+	if !validSearchExpression(tokensFromParts(parts)) {
+		return nil
+	}
+
+	return parts
+}
+
+// tokenizeSearch splits a search string into terms, phrases, operators and parens
+func tokenizeSearch(search string) []ftsToken {
+	tokens := []ftsToken{}
+	runes := []rune(search)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, ftsToken{text: string(r), operator: true})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			token := ftsToken{text: string(runes[i+1 : min(end, len(runes))])}
+			i = end + 1
+			if i < len(runes) && runes[i] == '*' {
+				token.prefix = true
+				i++
+			}
+			tokens = append(tokens, token)
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '(' && runes[end] != ')' && runes[end] != '"' {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+
+			if word == "AND" || word == "OR" || word == "NOT" {
+				// FTS5 NOT is binary, so "a AND NOT b" is written "a NOT b"
+				if word == "NOT" && len(tokens) > 0 && tokens[len(tokens)-1].operator && tokens[len(tokens)-1].text == "AND" {
+					tokens = tokens[:len(tokens)-1]
+				}
+				tokens = append(tokens, ftsToken{text: word, operator: true})
+				continue
+			}
+
+			token := ftsToken{}
+			if strings.HasSuffix(word, "*") {
+				token.prefix = true
+				word = strings.TrimRight(word, "*")
+			}
+			token.text = word
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
+
+// validSearchExpression checks binary operators have operands on both sides
+// and parentheses are balanced
+func validSearchExpression(tokens []ftsToken) bool {
+	depth := 0
+	expectOperand := true
+	for _, token := range tokens {
+		switch {
+		case token.operator && token.text == "(":
+			if !expectOperand {
+				// Implicit AND before a group
+				expectOperand = true
+			}
+			depth++
+		case token.operator && token.text == ")":
+			if expectOperand || depth == 0 {
+				return false
+			}
+			depth--
+		case token.operator:
+			if expectOperand {
+				return false
+			}
+			expectOperand = true
+		default:
+			expectOperand = false
+		}
+	}
+	return depth == 0 && !expectOperand
+}
+
+// tokensFromParts re-tokenizes built query parts for validation
+func tokensFromParts(parts []string) []ftsToken {
+	tokens := make([]ftsToken, len(parts))
+	for i, part := range parts {
+		switch part {
+		case "AND", "OR", "NOT", "(", ")":
+			tokens[i] = ftsToken{text: part, operator: true}
+		default:
+			tokens[i] = ftsToken{text: part}
+		}
+	}
+	return tokens
+}
+
+// hasSearchableChars reports whether s contains anything the FTS tokenizer indexes
+func hasSearchableChars(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchNode is a parsed full-text query: an operator with two operands, or
+// a phrase
+type searchNode struct {
+	op          string // AND, OR or NOT; "" for a phrase
+	left, right *searchNode
+	phrase      []string // Lowercased tokens that must appear in sequence
+	prefix      bool     // The last token only needs to be a prefix
+}
+
+// compileSearch parses search the way buildFTSQuery translates it, so logs
+// can be matched in memory like the full-text index matches them. Returns
+// nil if there is nothing to match.
+func compileSearch(search string) *searchNode {
+	p := &searchParser{parts: ftsQueryParts(search)}
+	if len(p.parts) == 0 {
+		return nil
+	}
+	return p.parseOr()
+}
+
+// searchParser reads ftsQueryParts output. FTS5 binds NOT tightest, then
+// AND (explicit or implicit), then OR.
+type searchParser struct {
+	parts []string
+	pos   int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos < len(p.parts) {
+		return p.parts[p.pos]
+	}
+	return ""
+}
+
+func (p *searchParser) parseOr() *searchNode {
+	node := p.parseAnd()
+	for p.peek() == "OR" {
+		p.pos++
+		node = &searchNode{op: "OR", left: node, right: p.parseAnd()}
+	}
+	return node
+}
+
+func (p *searchParser) parseAnd() *searchNode {
+	node := p.parseNot()
+	for {
+		switch next := p.peek(); {
+		case next == "AND":
+			p.pos++
+		case next == "" || next == "OR" || next == ")":
+			return node
+		}
+		node = &searchNode{op: "AND", left: node, right: p.parseNot()}
+	}
+}
+
+func (p *searchParser) parseNot() *searchNode {
+	node := p.parsePrimary()
+	for p.peek() == "NOT" {
+		p.pos++
+		node = &searchNode{op: "NOT", left: node, right: p.parsePrimary()}
+	}
+	return node
+}
+
+func (p *searchParser) parsePrimary() *searchNode {
+	part := p.peek()
+	p.pos++
+	if part == "(" {
+		node := p.parseOr()
+		if p.peek() == ")" {
+			p.pos++
+		}
+		return node
+	}
+
+	node := &searchNode{}
+	if strings.HasSuffix(part, "*") {
+		node.prefix = true
+		part = strings.TrimSuffix(part, "*")
+	}
+	part = strings.TrimSuffix(strings.TrimPrefix(part, `"`), `"`)
+	node.phrase = searchTokens(strings.ReplaceAll(part, `""`, `"`))
+	return node
+}
+
+// Matches reports whether any of values contains the query. Like FTS5
+// columns, a phrase must appear within a single value.
+func (n *searchNode) Matches(values ...string) bool {
+	columns := make([][]string, len(values))
+	for i, value := range values {
+		columns[i] = searchTokens(value)
+	}
+	return n.match(columns)
+}
+
+func (n *searchNode) match(columns [][]string) bool {
+	switch n.op {
+	case "AND":
+		return n.left.match(columns) && n.right.match(columns)
+	case "OR":
+		return n.left.match(columns) || n.right.match(columns)
+	case "NOT":
+		return n.left.match(columns) && !n.right.match(columns)
+	}
+	for _, tokens := range columns {
+		if n.matchPhrase(tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPhrase reports whether the phrase appears in tokens
+func (n *searchNode) matchPhrase(tokens []string) bool {
+	if len(n.phrase) == 0 {
+		return false
+	}
+	last := len(n.phrase) - 1
+	for start := 0; start+last < len(tokens); start++ {
+		matched := true
+		for i, want := range n.phrase {
+			got := tokens[start+i]
+			if got != want && !(i == last && n.prefix && strings.HasPrefix(got, want)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTokens splits text into lowercase runs of letters and digits, like
+// the FTS5 unicode61 tokenizer
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestBuildFTSQuery quotes every term and keeps operators only when they
+// form a valid expression
+func TestBuildFTSQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		search string
+		want   string // "" when there is nothing to match
+	}{
+		{name: "implicit AND", search: "error timeout", want: `"error" AND "timeout"`},
+		{name: "phrase", search: `"link down"`, want: `"link down"`},
+		{name: "prefix", search: "deny*", want: `"deny"*`},
+		{name: "phrase prefix", search: `"link down"* up`, want: `"link down"* AND "up"`},
+		{name: "grouping", search: "vpn AND (up OR down)", want: `"vpn" AND ( "up" OR "down" )`},
+		{name: "implicit AND before a group", search: "vpn (up OR down)", want: `"vpn" AND ( "up" OR "down" )`},
+		{name: "implicit AND after a group", search: "(up OR down) vpn", want: `( "up" OR "down" ) AND "vpn"`},
+		{name: "nested groups", search: "(((a)))", want: `( ( ( "a" ) ) )`},
+		{name: "NOT", search: "fw* NOT deny", want: `"fw"* NOT "deny"`},
+		{name: "AND NOT", search: "vpn AND NOT anyconnect", want: `"vpn" NOT "anyconnect"`},
+		{name: "IP address", search: "10.1.2.3", want: `"10.1.2.3"`},
+		{name: "MAC address", search: "00:1a:2b:3c:4d:5e", want: `"00:1a:2b:3c:4d:5e"`},
+		{name: "URL", search: "https://example.com/a?b=c", want: `"https://example.com/a?b=c"`},
+		{name: "column filter syntax", search: "a:b*", want: `"a:b"*`},
+		{name: "unbalanced quote", search: `say "hi`, want: `"say" AND "hi"`},
+		{name: "quote inside a word", search: `a"b`, want: `"a" AND "b"`},
+		{name: "non-ASCII", search: "ünïcödé 日本", want: `"ünïcödé" AND "日本"`},
+		{name: "lowercase operators are terms", search: "and or not", want: `"and" AND "or" AND "not"`},
+		{name: "lone operator", search: "AND", want: `"AND"`},
+		{name: "trailing operator", search: "a AND", want: `"a" AND "AND"`},
+		{name: "leading operator", search: "OR b", want: `"OR" AND "b"`},
+		{name: "leading NOT", search: "NOT a", want: `"NOT" AND "a"`},
+		{name: "OR NOT", search: "a OR NOT b", want: `"a" AND "OR" AND "NOT" AND "b"`},
+		{name: "unclosed group", search: "(a OR b", want: `"a" AND "OR" AND "b"`},
+		{name: "unopened group", search: "a OR b)", want: `"a" AND "OR" AND "b"`},
+		{name: "empty group", search: "a ()", want: `"a"`},
+		{name: "unsearchable term dropped", search: "a --- b", want: `"a" AND "b"`},
+		{name: "only punctuation", search: "---", want: ""},
+		{name: "only a star", search: "*", want: ""},
+		{name: "operator left dangling", search: "a AND ---", want: ""},
+		{name: "group left empty", search: "a AND ( --- )", want: ""},
+		{name: "empty", search: "", want: ""},
+		{name: "whitespace", search: "  \t ", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := buildFTSQuery(tt.search); got != tt.want {
+			t.Errorf("%s: buildFTSQuery(%q) = %q, want %q", tt.name, tt.search, got, tt.want)
+		}
+	}
+}
+
+// openTestDatabase returns a new database holding logs with the given messages
+func openTestDatabase(t *testing.T, messages ...string) (*Database, []*LogEntry) {
+	t.Helper()
+	d, err := NewDatabase(filepath.Join(t.TempDir(), "qlog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	var entries []*LogEntry
+	for i, message := range messages {
+		entry := &LogEntry{
+			Timestamp:  time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			Hostname:   "fw01",
+			AppName:    "kernel",
+			Message:    message,
+			RawMessage: "<134>1 2024-01-01T00:00:00Z fw01 kernel - - - " + message,
+		}
+		if err := d.InsertLog(entry, "UDP", "RFC5424"); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return d, entries
+}
+
+// TestBuildFTSQueryInFTS5 runs built queries against the full-text index:
+// none may be an FTS5 syntax error, and live tail must match the same logs
+func TestBuildFTSQueryInFTS5(t *testing.T) {
+	d, entries := openTestDatabase(t,
+		"link down on port 10.1.2.3",
+		"vpn up for user alice",
+		"vpn down, anyconnect session ended",
+		"deny tcp 00:1a:2b:3c:4d:5e -> 192.0.2.1",
+		`user said "hello" at https://example.com/a?b=c`,
+		"ünïcödé 日本 message",
+	)
+	if !d.ftsAvailable.Load() {
+		t.Skip("SQLite was built without FTS5 (build with -tags sqlite_fts5)")
+	}
+
+	searches := []string{
+		"error timeout", `"link down"`, "deny*", `"link down"* up`, "vpn AND (up OR down)",
+		"vpn (up OR down)", "(up OR down) vpn", "(((vpn)))", "vpn NOT anyconnect", "vpn AND NOT anyconnect",
+		"10.1.2.3", "00:1a:2b:3c:4d:5e", "https://example.com/a?b=c", "a:b*", `say "hello`, `user"said`,
+		"ünïcödé 日本", "and or not", "AND", "vpn AND", "OR vpn", "NOT vpn", "vpn OR NOT up", "(vpn OR up",
+		"vpn OR up)", "vpn ()", "vpn --- up", "NEAR(vpn up)", "vpn^", "-vpn", "+vpn", "{hostname}: fw01",
+		"kernel", "fw0*", "192.0.2.*",
+	}
+	for _, search := range searches {
+		ftsQuery := d.searchIndexQuery(search)
+		if ftsQuery == "" {
+			t.Errorf("%q: the index is not used", search)
+			continue
+		}
+		rows, err := d.db.Query("SELECT rowid FROM logs_fts WHERE logs_fts MATCH ? ORDER BY rowid", ftsQuery)
+		if err != nil {
+			t.Errorf("%q: MATCH %q: %v", search, ftsQuery, err)
+			continue
+		}
+		var got []int64
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			got = append(got, id)
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("%q: MATCH %q: %v", search, ftsQuery, err)
+		}
+		rows.Close()
+
+		filter := LogFilter{Search: search}
+		filter.UseSearchIndex()
+		var want []int64
+		for _, entry := range entries {
+			if filter.Matches(entry) {
+				want = append(want, entry.ID)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: the index matched %v, live tail %v", search, got, want)
+		}
+	}
+}
+
+// TestSearchLogsLikeFallback searches with LIKE while the index is
+// backfilling and for queries the index can't express
+func TestSearchLogsLikeFallback(t *testing.T) {
+	d, entries := openTestDatabase(t, "link down on port 10.1.2.3", "checksum ---- mismatch", "vpn up")
+
+	tests := []struct {
+		name   string
+		search string
+		ready  bool
+		want   []int64
+	}{
+		{name: "backfilling", search: "10.1.2", want: []int64{entries[0].ID}},
+		{name: "backfilling, partial word", search: "ink dow", want: []int64{entries[0].ID}},
+		{name: "nothing to match", search: "----", ready: true, want: []int64{entries[1].ID}},
+		{name: "operator left dangling", search: "vpn AND ---", ready: true},
+	}
+	for _, tt := range tests {
+		d.ftsReady.Store(tt.ready && d.ftsAvailable.Load())
+		if got := d.searchIndexQuery(tt.search); got != "" {
+			t.Errorf("%s: searchIndexQuery(%q) = %q, want LIKE", tt.name, tt.search, got)
+		}
+		logs, err := d.SearchLogs(tt.search, 10, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []int64
+		for _, entry := range logs {
+			got = append(got, entry.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: SearchLogs(%q) = %v, want %v", tt.name, tt.search, got, tt.want)
+		}
+	}
+
+	d.ftsReady.Store(false)
+	if got := d.searchIndexQuery("vpn"); got != "" {
+		t.Errorf("searchIndexQuery while backfilling = %q, want \"\"", got)
+	}
+	if !d.ftsAvailable.Load() {
+		return
+	}
+	d.ftsReady.Store(true)
+	if got := d.searchIndexQuery("vpn"); got != `"vpn"` {
+		t.Errorf("searchIndexQuery once ready = %q, want %q", got, `"vpn"`)
+	}
+}
@@ -6,11 +6,9 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
-
-	"github.com/leodido/go-syslog/v4/rfc3164"
-	"github.com/leodido/go-syslog/v4/rfc5424"
 )
 
 //go:embed web/*
@@ -18,18 +16,31 @@ var webFiles embed.FS
 
 type Server struct {
 	db              *Database
+	writer          *LogWriter
 	config          *Config
-	rfc5424Parser   interface{}
-	rfc3164Parser   interface{}
 	mu              sync.RWMutex
 	stats           *ServerStats
 	activeListeners map[string]ListenerControl // listener ID -> control
 	listenerMu      sync.RWMutex
+	retention       retentionState
+	liveTail        *LogBroadcaster
+	alerts          *AlertEngine
+	notifier        *Notifier
+	forwarder       *Forwarder
+	devices         *DeviceMatcher
+	quarantine      *Quarantine
+	health          *DeviceHealthTracker
+	listenerStats   map[string]*ListenerStats // listener ID -> statistics, kept while the listener is stopped
+	listenerStatsMu sync.RWMutex
+	ca              *InternalCA // Built-in certificate authority, nil until created; guarded by mu
 }
 
 type ListenerControl struct {
-	Stop func()
-	Type string // "udp", "tcp", "tls"
+	Stop   func()
+	Type   string       // "udp", "tcp", "tls", "relp", "unix"
+	Ingest *IngestQueue // Bounded ingest queue (UDP and UNIX datagram, nil otherwise)
+	Stats  *ListenerStats
+	Certs  *certStore // Reloadable TLS certificate (TLS, and RELP with a certificate), nil otherwise
 }
 
 type ServerStats struct {
@@ -46,36 +57,34 @@ func NewServer(config *Config) (*Server, error) {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	var rfc5424Parser interface{}
-	var rfc3164Parser interface{}
-
-	if config.Parsing.RFC5424Enabled {
-		if config.Parsing.BestEffort {
-			rfc5424Parser = rfc5424.NewParser(rfc5424.WithBestEffort())
-		} else {
-			rfc5424Parser = rfc5424.NewParser()
-		}
-	}
-
-	if config.Parsing.RFC3164Enabled {
-		if config.Parsing.BestEffort {
-			rfc3164Parser = rfc3164.NewParser(rfc3164.WithBestEffort())
-		} else {
-			rfc3164Parser = rfc3164.NewParser()
-		}
-	}
-
-	return &Server{
-		db:            db,
-		config:        config,
-		rfc5424Parser: rfc5424Parser,
-		rfc3164Parser: rfc3164Parser,
+	s := &Server{
+		db:     db,
+		config: config,
 		stats: &ServerStats{
 			MessagesByRFC:   make(map[string]int64),
 			MessagesByProto: make(map[string]int64),
 		},
 		activeListeners: make(map[string]ListenerControl),
-	}, nil
+		listenerStats:   make(map[string]*ListenerStats),
+		liveTail:        NewLogBroadcaster(),
+		alerts:          NewAlertEngine(config.AlertRules),
+		notifier:        NewNotifier(db, config.Notifications),
+		devices:         NewDeviceMatcher(config.Devices),
+		quarantine:      NewQuarantine(db),
+		forwarder:       NewForwarder(filepath.Join(filepath.Dir(config.Database.Path), "forward-queue"), config.ForwardTargets),
+	}
+
+	s.health = NewDeviceHealthTracker(s.deviceHealthChanged)
+	s.loadListenerStats()
+
+	if s.ca, err = loadInternalCA(internalCADir, db); err != nil {
+		log.Printf("Warning: internal CA unavailable: %v", err)
+	}
+
+	flushInterval := time.Duration(config.Database.FlushIntervalMs) * time.Millisecond
+	s.writer = NewLogWriter(db, config.Database.BatchSize, flushInterval, s.logsCommitted)
+
+	return s, nil
 }
 
 func (s *Server) Start() error {
@@ -87,6 +96,29 @@ func (s *Server) Start() error {
 	// Start periodic cleanup of expired sessions
 	go s.cleanupExpiredSessions()
 
+	// Index logs that predate the full-text search index
+	go s.db.BackfillSearchIndex()
+
+	// Attribute logs stored before device IDs were recorded
+	go s.db.BackfillDeviceIDs(func(remoteAddr, hostname string) string {
+		if device := s.devices.Match(remoteAddr, hostname); device != nil {
+			return device.ID
+		}
+		return ""
+	})
+
+	// Start periodic log retention (row limit, max age, max size)
+	go s.enforceRetention()
+
+	// Track device activity and flag devices that go silent
+	go s.monitorDeviceHealth()
+
+	// Save listener statistics so totals survive restarts
+	go s.persistListenerStats()
+
+	// Keep the internal CA's CRL from expiring
+	go s.refreshCRL()
+
 	// Start all enabled listeners from config
 	if err := s.startListeners(); err != nil {
 		log.Printf("Warning: Error starting some listeners: %v", err)
@@ -124,6 +156,24 @@ func (s *Server) Stop() error {
 		delete(s.activeListeners, id)
 	}
 
+	// Flush logs still waiting to be written before closing the database
+	if s.writer != nil {
+		s.writer.Close()
+	}
+
+	if s.db != nil {
+		s.saveListenerStats()
+	}
+
+	if s.quarantine != nil {
+		s.quarantine.Close()
+	}
+
+	// Forwarding queues are kept on disk and resume on the next start
+	if s.forwarder != nil {
+		s.forwarder.Close()
+	}
+
 	if s.db != nil {
 		return s.db.Close()
 	}
@@ -156,6 +206,7 @@ func (s *Server) startWebServer() error {
 
 	// Protected API routes (require authentication, except shared views)
 	mux.HandleFunc("/api/logs", s.requireAuth(s.handleLogsAPI))
+	mux.HandleFunc("/api/logs/stream", s.requireAuth(s.handleLogStreamAPI))
 	mux.HandleFunc("/api/logs/", s.requireAuth(s.handleLogDetailAPI))
 	mux.HandleFunc("/api/stats", s.requireAuth(s.handleStatsAPI))
 	mux.HandleFunc("/api/clear", s.requireAuth(s.handleClearAPI))
@@ -163,9 +214,23 @@ func (s *Server) startWebServer() error {
 	mux.HandleFunc("/api/config", s.requireAuth(s.handleConfigAPI))
 	mux.HandleFunc("/api/config/limit", s.requireAuth(s.handleConfigLimitAPI))
 	mux.HandleFunc("/api/config/reset", s.requireAuth(s.handleConfigResetAPI))
+	mux.HandleFunc("/api/retention", s.requireAuth(s.handleRetentionAPI))
+	mux.HandleFunc("/api/retention/run", s.requireAuth(s.handleRetentionRunAPI))
+	mux.HandleFunc("/api/alerts", s.requireAuth(s.handleAlertsAPI))
+	mux.HandleFunc("/api/alerts/", s.requireAuth(s.handleAlertAPI))
+	mux.HandleFunc("/api/alert-rules", s.requireAuth(s.handleAlertRulesAPI))
+	mux.HandleFunc("/api/alert-rules/", s.requireAuth(s.handleAlertRuleAPI))
+	mux.HandleFunc("/api/notification-channels", s.requireAuth(s.handleNotificationChannelsAPI))
+	mux.HandleFunc("/api/notification-channels/", s.requireAuth(s.handleNotificationChannelAPI))
+	mux.HandleFunc("/api/notifications/test", s.requireAuth(s.handleNotificationTestAPI))
+	mux.HandleFunc("/api/notifications/deliveries", s.requireAuth(s.handleNotificationDeliveriesAPI))
+	mux.HandleFunc("/api/forward-targets", s.requireAuth(s.handleForwardTargetsAPI))
+	mux.HandleFunc("/api/forward-targets/", s.requireAuth(s.handleForwardTargetAPI))
 	mux.HandleFunc("/api/device-types", s.requireAuth(s.handleDeviceTypesAPI))
 	mux.HandleFunc("/api/devices", s.requireAuth(s.handleDevicesAPI))
 	mux.HandleFunc("/api/devices/", s.requireAuth(s.handleDeviceAPI))
+	mux.HandleFunc("/api/unknown-senders", s.requireAuth(s.handleUnknownSendersAPI))
+	mux.HandleFunc("/api/unknown-senders/", s.requireAuth(s.handleUnknownSenderAPI))
 	mux.HandleFunc("/api/severity-overrides", s.requireAuth(s.handleSeverityOverridesAPI))
 	mux.HandleFunc("/api/severity-overrides/", s.requireAuth(s.handleSeverityOverrideAPI))
 	mux.HandleFunc("/api/event-types", s.requireAuth(s.handleEventTypesAPI))
@@ -175,6 +240,8 @@ func (s *Server) startWebServer() error {
 	mux.HandleFunc("/api/listeners/", s.requireAuth(s.handleListenerAPI))
 	mux.HandleFunc("/api/certs/upload", s.requireAuth(s.handleCertUploadAPI))
 	mux.HandleFunc("/api/certs/validate", s.requireAuth(s.handleCertValidateAPI))
+	mux.HandleFunc("/api/ca", s.requireAuth(s.handleCAAPI))
+	mux.HandleFunc("/api/ca/", s.requireAuth(s.handleCAResourceAPI))
 	mux.HandleFunc("/api/views", s.requireAuth(s.handleViewsAPI))
 	mux.HandleFunc("/api/views/", s.requireAuth(s.handleViewAPI))
 	mux.HandleFunc("/api/query", s.requireAuth(s.handleQueryAPI))
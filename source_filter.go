@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Per-listener source access lists and per-source rate limits, applied to
+// every message before it is parsed
+
+const (
+	rateLimitActionDrop   = "drop"
+	rateLimitActionSample = "sample"
+
+	defaultRateLimitSampleRate     = 10
+	defaultRateLimitExemptSeverity = 2 // Critical and more severe messages are never limited
+
+	rateLimitBucketIdle      = 10 * time.Minute // Buckets of sources quiet this long are discarded
+	rateLimitPruneInterval   = time.Minute
+	rateLimitDropLogInterval = 10 * time.Second
+)
+
+// validateListenerAccess checks a listener's access lists and rate limit
+func validateListenerAccess(listener *ListenerConfig) error {
+	if listener.Protocol == "UNIX" && (len(listener.AllowCIDRs) > 0 || len(listener.DenyCIDRs) > 0) {
+		return errors.New("allow_cidrs and deny_cidrs are not supported for unix listeners")
+	}
+	for _, list := range [][]string{listener.AllowCIDRs, listener.DenyCIDRs} {
+		for _, value := range list {
+			if _, err := parseDevicePrefix(value); err != nil {
+				return fmt.Errorf("invalid access list address or CIDR range %q", value)
+			}
+		}
+	}
+	return validateRateLimit(listener.RateLimit)
+}
+
+// validateRateLimit checks a listener or device rate limit
+func validateRateLimit(limit *RateLimitConfig) error {
+	if limit == nil {
+		return nil
+	}
+	if limit.Burst < 0 {
+		return errors.New("rate_limit burst must not be negative")
+	}
+	switch limit.Action {
+	case "", rateLimitActionDrop, rateLimitActionSample:
+	default:
+		return errors.New("rate_limit action must be drop or sample")
+	}
+	if limit.SampleRate < 0 {
+		return errors.New("rate_limit sample_rate must not be negative")
+	}
+	if limit.ExemptSeverity != nil && (*limit.ExemptSeverity < -1 || *limit.ExemptSeverity > 7) {
+		return errors.New("rate_limit exempt_severity must be between -1 and 7")
+	}
+	return nil
+}
+
+// tokenBucket tracks the rate of one source
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	excess int64 // Messages over the limit, for sampling
+}
+
+// SourceFilter decides whether a listener accepts a message from a source
+type SourceFilter struct {
+	listenerID string
+	allow      []netip.Prefix
+	deny       []netip.Prefix
+	rateLimit  *RateLimitConfig
+	devices    *DeviceMatcher // For per-device rate limit overrides
+	stats      *ListenerStats
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket // Source IP (or socket path) -> bucket
+	lastPrune   time.Time
+	lastDropLog time.Time
+	dropsToLog  int64
+}
+
+// newSourceFilter builds the filter for a listener
+func newSourceFilter(listener ListenerConfig, devices *DeviceMatcher, stats *ListenerStats) (*SourceFilter, error) {
+	if err := validateListenerAccess(&listener); err != nil {
+		return nil, err
+	}
+
+	f := &SourceFilter{
+		listenerID: listener.ID,
+		rateLimit:  listener.RateLimit,
+		devices:    devices,
+		stats:      stats,
+		buckets:    make(map[string]*tokenBucket),
+		lastPrune:  time.Now(),
+	}
+	for _, value := range listener.AllowCIDRs {
+		prefix, _ := parseDevicePrefix(value)
+		f.allow = append(f.allow, prefix)
+	}
+	for _, value := range listener.DenyCIDRs {
+		prefix, _ := parseDevicePrefix(value)
+		f.deny = append(f.deny, prefix)
+	}
+	return f, nil
+}
+
+// Permits reports whether the access lists accept a sender. Senders without
+// an IP address (UNIX sockets) are always accepted. A nil filter accepts all.
+func (f *SourceFilter) Permits(remoteAddr string) bool {
+	if f == nil || (len(f.allow) == 0 && len(f.deny) == 0) {
+		return true
+	}
+	ip, ok := remoteIP(remoteAddr)
+	if !ok {
+		return true
+	}
+	for _, prefix := range f.deny {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admit reports whether a message should be processed: its sender must be
+// permitted and within its rate limit. Messages at or above the exempt
+// severity are never rate limited. Dropped messages are counted as received.
+func (f *SourceFilter) Admit(msg ingestMessage) bool {
+	if f == nil {
+		return true
+	}
+	if !f.Permits(msg.remoteAddr) {
+		f.stats.RecordFiltered(msg.connection, msg.size(), true)
+		return false
+	}
+
+	limit := f.limitFor(msg)
+	if limit == nil || limit.MessagesPerSecond <= 0 {
+		return true
+	}
+	if severity, ok := frameSeverity(msg.data); ok && severity <= limit.exemptSeverity() {
+		return true
+	}
+
+	source := msg.remoteAddr
+	if ip, ok := remoteIP(msg.remoteAddr); ok {
+		source = ip.String()
+	}
+
+	f.mu.Lock()
+	now := time.Now()
+	f.prune(now)
+
+	burst := limit.burst()
+	bucket := f.buckets[source]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		f.buckets[source] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.MessagesPerSecond)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		f.mu.Unlock()
+		return true
+	}
+
+	// Over the limit: keep the first of every sample_rate excess messages when sampling
+	bucket.excess++
+	sampled := limit.Action == rateLimitActionSample && (bucket.excess-1)%int64(limit.sampleRate()) == 0
+	if !sampled {
+		f.logDrop(source, now)
+	}
+	f.mu.Unlock()
+
+	if sampled {
+		f.stats.RecordSampled()
+		return true
+	}
+	f.stats.RecordFiltered(msg.connection, msg.size(), false)
+	return false
+}
+
+// limitFor returns the rate limit for a message's sender: the override of
+// its device if it has one, otherwise the listener's
+func (f *SourceFilter) limitFor(msg ingestMessage) *RateLimitConfig {
+	var device *DeviceConfig
+	if msg.deviceID != "" {
+		device = f.devices.ByID(msg.deviceID)
+	} else {
+		device = f.devices.Match(msg.remoteAddr, "")
+	}
+	if device != nil && device.RateLimit != nil {
+		return device.RateLimit
+	}
+	return f.rateLimit
+}
+
+// prune discards buckets of sources that have gone quiet. Called with f.mu held.
+func (f *SourceFilter) prune(now time.Time) {
+	if now.Sub(f.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	f.lastPrune = now
+	for source, bucket := range f.buckets {
+		if now.Sub(bucket.last) > rateLimitBucketIdle {
+			delete(f.buckets, source)
+		}
+	}
+}
+
+// logDrop reports rate limited messages at most once per
+// rateLimitDropLogInterval. Called with f.mu held.
+func (f *SourceFilter) logDrop(source string, now time.Time) {
+	f.dropsToLog++
+	if now.Sub(f.lastDropLog) < rateLimitDropLogInterval {
+		return
+	}
+	log.Printf("Rate limiting listener %s: dropped %d messages (latest from %s)", f.listenerID, f.dropsToLog, source)
+	f.lastDropLog = now
+	f.dropsToLog = 0
+}
+
+func (l *RateLimitConfig) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(1, l.MessagesPerSecond)
+}
+
+func (l *RateLimitConfig) sampleRate() int {
+	if l.SampleRate > 0 {
+		return l.SampleRate
+	}
+	return defaultRateLimitSampleRate
+}
+
+func (l *RateLimitConfig) exemptSeverity() int {
+	if l.ExemptSeverity != nil {
+		return *l.ExemptSeverity
+	}
+	return defaultRateLimitExemptSeverity
+}
+
+// frameSeverity reads the severity from a frame's <PRI> without parsing the
+// rest of it
+func frameSeverity(data []byte) (int, bool) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, false
+	}
+	pri := 0
+	for i := 1; i < len(data) && i <= 4; i++ {
+		switch {
+		case data[i] >= '0' && data[i] <= '9':
+			pri = pri*10 + int(data[i]-'0')
+		case data[i] == '>' && i > 1 && pri <= 191:
+			return pri % 8, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSourceFilter(t *testing.T, listener ListenerConfig, devices []DeviceConfig) *SourceFilter {
+	t.Helper()
+	f, err := newSourceFilter(listener, NewDeviceMatcher(devices), NewListenerStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestSourceFilterPermits applies deny before allow
+func TestSourceFilterPermits(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      []string
+		deny       []string
+		remoteAddr string
+		want       bool
+	}{
+		{name: "no lists", remoteAddr: "203.0.113.1:514", want: true},
+		{name: "allowed", allow: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:514", want: true},
+		{name: "not allowed", allow: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:514", want: false},
+		{name: "denied", deny: []string{"192.0.2.0/24"}, remoteAddr: "192.0.2.1:514", want: false},
+		{name: "not denied", deny: []string{"192.0.2.0/24"}, remoteAddr: "198.51.100.1:514", want: true},
+		{name: "deny wins over allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.9.0.0/16"}, remoteAddr: "10.9.1.1:514", want: false},
+		{name: "allow outside deny", allow: []string{"10.0.0.0/8"}, deny: []string{"10.9.0.0/16"}, remoteAddr: "10.8.1.1:514", want: true},
+		{name: "deny wins over a more specific allow", allow: []string{"10.9.1.1"}, deny: []string{"10.0.0.0/8"}, remoteAddr: "10.9.1.1:514", want: false},
+		{name: "IPv4-mapped sender", allow: []string{"10.0.0.0/8"}, remoteAddr: "[::ffff:10.1.2.3]:514", want: true},
+		{name: "IPv6 sender", allow: []string{"2001:db8::/32"}, remoteAddr: "[2001:db8::1]:514", want: true},
+		{name: "IPv6 sender not allowed", allow: []string{"10.0.0.0/8"}, remoteAddr: "[2001:db8::1]:514", want: false},
+		{name: "no address", allow: []string{"10.0.0.0/8"}, remoteAddr: "/dev/log", want: true},
+	}
+
+	for _, tt := range tests {
+		f := newTestSourceFilter(t, ListenerConfig{ID: "l1", Protocol: "UDP", AllowCIDRs: tt.allow, DenyCIDRs: tt.deny}, nil)
+		if got := f.Permits(tt.remoteAddr); got != tt.want {
+			t.Errorf("%s: Permits(%q) = %t, want %t", tt.name, tt.remoteAddr, got, tt.want)
+		}
+		msg := ingestMessage{data: []byte("<34>hi"), remoteAddr: tt.remoteAddr}
+		if got := f.Admit(msg); got != tt.want {
+			t.Errorf("%s: Admit(%q) = %t, want %t", tt.name, tt.remoteAddr, got, tt.want)
+		}
+	}
+
+	var nilFilter *SourceFilter
+	if !nilFilter.Permits("192.0.2.1:514") || !nilFilter.Admit(ingestMessage{remoteAddr: "192.0.2.1:514"}) {
+		t.Errorf("a nil filter must accept everything")
+	}
+}
+
+// admitCount returns how many of n messages from remoteAddr are admitted
+func admitCount(f *SourceFilter, remoteAddr, frame string, n int) int {
+	admitted := 0
+	for i := 0; i < n; i++ {
+		if f.Admit(ingestMessage{data: []byte(frame), remoteAddr: remoteAddr}) {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+// TestSourceFilterTokenBucket limits each source separately and refills
+// buckets over time
+func TestSourceFilterTokenBucket(t *testing.T) {
+	limit := &RateLimitConfig{MessagesPerSecond: 10, Burst: 3}
+	f := newTestSourceFilter(t, ListenerConfig{ID: "l1", Protocol: "UDP", RateLimit: limit}, nil)
+	const frame = "<134>Oct 11 22:14:15 host app: info"
+
+	if got := admitCount(f, "192.0.2.1:514", frame, 5); got != 3 {
+		t.Errorf("burst: admitted %d of 5, want 3", got)
+	}
+	if got := admitCount(f, "192.0.2.2:514", frame, 5); got != 3 {
+		t.Errorf("second source: admitted %d of 5, want 3", got)
+	}
+	if got := admitCount(f, "192.0.2.1:40000", frame, 1); got != 0 {
+		t.Errorf("same IP, other port: admitted %d, want 0", got)
+	}
+
+	// 250ms at 10 per second refills 2.5 tokens
+	f.mu.Lock()
+	f.buckets["192.0.2.1"].last = f.buckets["192.0.2.1"].last.Add(-250 * time.Millisecond)
+	f.mu.Unlock()
+	if got := admitCount(f, "192.0.2.1:514", frame, 5); got != 2 {
+		t.Errorf("after 250ms: admitted %d of 5, want 2", got)
+	}
+
+	// Refilling never exceeds the burst
+	f.mu.Lock()
+	f.buckets["192.0.2.1"].last = f.buckets["192.0.2.1"].last.Add(-time.Hour)
+	f.mu.Unlock()
+	if got := admitCount(f, "192.0.2.1:514", frame, 5); got != 3 {
+		t.Errorf("after an hour: admitted %d of 5, want 3", got)
+	}
+
+	if counters := f.stats.Counters(); counters.RateLimited != 2+2+1+3+2 {
+		t.Errorf("counted %d rate limited messages, want 10", counters.RateLimited)
+	}
+}
+
+// TestSourceFilterExemptSeverity never limits messages at or above the
+// exempt severity
+func TestSourceFilterExemptSeverity(t *testing.T) {
+	exemptError := 3
+	limitAll := -1
+	tests := []struct {
+		name   string
+		exempt *int
+		frame  string
+		want   int // Admitted of 5, with a burst of 1
+	}{
+		{name: "emergency", frame: "<0>kernel panic", want: 5},
+		{name: "critical", frame: "<34>1 - h a - - - critical", want: 5},
+		{name: "error", frame: "<35>1 - h a - - - error", want: 1},
+		{name: "informational", frame: "<134>info", want: 1},
+		{name: "no PRI", frame: "plain text", want: 1},
+		{name: "invalid PRI", frame: "<999>too high", want: 1},
+		{name: "raised exemption", exempt: &exemptError, frame: "<35>error", want: 5},
+		{name: "raised exemption, warning", exempt: &exemptError, frame: "<36>warning", want: 1},
+		{name: "no exemption", exempt: &limitAll, frame: "<0>kernel panic", want: 1},
+	}
+
+	for _, tt := range tests {
+		limit := &RateLimitConfig{MessagesPerSecond: 0.001, Burst: 1, ExemptSeverity: tt.exempt}
+		f := newTestSourceFilter(t, ListenerConfig{ID: "l1", Protocol: "UDP", RateLimit: limit}, nil)
+		if got := admitCount(f, "192.0.2.1:514", tt.frame, 5); got != tt.want {
+			t.Errorf("%s: admitted %d of 5, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSourceFilterSample keeps one in every sample_rate excess messages
+func TestSourceFilterSample(t *testing.T) {
+	limit := &RateLimitConfig{MessagesPerSecond: 0.001, Burst: 1, Action: rateLimitActionSample, SampleRate: 3}
+	f := newTestSourceFilter(t, ListenerConfig{ID: "l1", Protocol: "UDP", RateLimit: limit}, nil)
+
+	var got []bool
+	for i := 0; i < 8; i++ {
+		got = append(got, f.Admit(ingestMessage{data: []byte("<134>info"), remoteAddr: "192.0.2.1:514"}))
+	}
+	want := []bool{true, true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("admitted %v, want %v", got, want)
+		}
+	}
+	if counters := f.stats.Counters(); counters.Sampled != 3 || counters.RateLimited != 4 {
+		t.Errorf("counted %d sampled and %d rate limited, want 3 and 4", counters.Sampled, counters.RateLimited)
+	}
+}
+
+// TestSourceFilterDeviceRateLimit uses a device's own rate limit for its addresses
+func TestSourceFilterDeviceRateLimit(t *testing.T) {
+	limit := &RateLimitConfig{MessagesPerSecond: 0.001, Burst: 1}
+	devices := []DeviceConfig{
+		{ID: "busy", ListenerID: "l1", IPAddresses: []string{"192.0.2.10"}, RateLimit: &RateLimitConfig{MessagesPerSecond: 0.001, Burst: 4}},
+		{ID: "unlimited", ListenerID: "l1", IPAddresses: []string{"192.0.2.11"}, RateLimit: &RateLimitConfig{}},
+	}
+	f := newTestSourceFilter(t, ListenerConfig{ID: "l1", Protocol: "UDP", RateLimit: limit}, devices)
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"192.0.2.1:514", 1},
+		{"192.0.2.10:514", 4},
+		{"192.0.2.11:514", 10},
+	}
+	for _, tt := range tests {
+		if got := admitCount(f, tt.remoteAddr, "<134>info", 10); got != tt.want {
+			t.Errorf("%s: admitted %d of 10, want %d", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+// TestFrameSeverity reads the severity from the PRI
+func TestFrameSeverity(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  int
+		ok    bool
+	}{
+		{"<0>msg", 0, true},
+		{"<34>1 2024-01-01T00:00:00Z h a - - -", 2, true},
+		{"<191>msg", 7, true},
+		{"<192>msg", 0, false},
+		{"<1234>msg", 0, false},
+		{"<>msg", 0, false},
+		{"<3", 0, false},
+		{"34>msg", 0, false},
+		{"<3a>msg", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := frameSeverity([]byte(tt.frame))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("frameSeverity(%q) = %d, %t, want %d, %t", tt.frame, got, ok, tt.want, tt.ok)
+		}
+	}
+}
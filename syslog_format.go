@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Syslog message rendering for outgoing messages (forwarding, notifications)
+
+// formatRFC5424 builds an RFC5424 message. Empty header fields are written
+// as "-"; structuredData must already be formatted (see formatStructuredData).
+func formatRFC5424(priority uint8, timestamp time.Time, hostname, appName, procID, msgID, structuredData, message string) string {
+	if structuredData == "" {
+		structuredData = "-"
+	}
+	ts := "-"
+	if !timestamp.IsZero() {
+		ts = timestamp.UTC().Format(time.RFC3339Nano)
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %s %s %s",
+		priority, ts, syslogHeaderField(hostname, 255), syslogHeaderField(appName, 48),
+		syslogHeaderField(procID, 128), syslogHeaderField(msgID, 32), structuredData)
+	if message != "" {
+		msg += " " + message
+	}
+	return msg
+}
+
+// formatStructuredData renders structured data elements (SD-ID -> params)
+// in RFC5424 syntax, sorted for stable output. Returns "-" if empty.
+func formatStructuredData(sd map[string]map[string]string) string {
+	if len(sd) == 0 {
+		return "-"
+	}
+
+	// Escape ", \ and ] in param values (RFC5424 section 6.3.3)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+	ids := make([]string, 0, len(sd))
+	for id := range sd {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString("[" + sdName(id))
+
+		names := make([]string, 0, len(sd[id]))
+		for name := range sd[id] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, ` %s="%s"`, sdName(name), escape.Replace(sd[id][name]))
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// formatRFC3164 builds a BSD syslog message: <PRI>Mmm dd hh:mm:ss HOST TAG[PID]: MSG
+func formatRFC3164(priority uint8, timestamp time.Time, hostname, tag, procID, message string) string {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	header := fmt.Sprintf("<%d>%s %s", priority, timestamp.Format(time.Stamp), syslogHeaderField(hostname, 255))
+	if tag = syslogHeaderField(tag, 32); tag != "-" {
+		header += " " + tag
+		if procID != "" {
+			header += "[" + syslogHeaderField(procID, 128) + "]"
+		}
+		header += ":"
+	}
+	return header + " " + message
+}
+
+// syslogHeaderField makes a value safe for a syslog header field:
+// printable ASCII without spaces, truncated to maxLen, "-" if empty
+func syslogHeaderField(value string, maxLen int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value[:min(len(value), maxLen)]
+}
+
+// sdName makes a value safe for an SD-ID or PARAM-NAME (no =, ] or ")
+func sdName(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, value)
+	return syslogHeaderField(value, 32)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// UNIX domain socket listener for local /dev/log style ingestion. Every
+// message is attributed to the listener's configured device.
+
+const (
+	unixSocketDatagram    = "datagram"
+	unixSocketStream      = "stream"
+	defaultUnixSocketMode = 0666
+)
+
+// validateUnixListener checks the socket settings of a UNIX listener
+func validateUnixListener(listener *ListenerConfig) error {
+	if listener.Protocol != "UNIX" {
+		if listener.DeviceID != "" {
+			return errors.New("device_id is only supported for unix listeners")
+		}
+		return nil
+	}
+	if listener.SocketPath == "" {
+		return errors.New("unix listener requires socket_path")
+	}
+	switch listener.SocketType {
+	case "", unixSocketDatagram, unixSocketStream:
+	default:
+		return errors.New("socket_type must be datagram or stream")
+	}
+	if _, err := unixSocketMode(listener); err != nil {
+		return err
+	}
+	return nil
+}
+
+// unixSocketMode returns the permissions to give the socket file
+func unixSocketMode(listener *ListenerConfig) (os.FileMode, error) {
+	if listener.SocketMode == "" {
+		return defaultUnixSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(listener.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("socket_mode must be octal permissions such as 0660, got %q", listener.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous run.
+// It refuses to remove other files or a socket something is listening on.
+func removeStaleSocket(path, network string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout(network, path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+func (s *Server) startUnixListener(listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter) (func(), *IngestQueue, error) {
+	if err := validateUnixListener(&listener); err != nil {
+		return nil, nil, err
+	}
+	if err := validateListenerFraming(&listener); err != nil {
+		return nil, nil, err
+	}
+	if listener.DeviceID == "" {
+		return nil, nil, errors.New("unix listener requires device_id")
+	}
+	mode, _ := unixSocketMode(&listener)
+
+	if listener.SocketType == unixSocketStream {
+		stopFunc, err := s.startUnixStreamListener(listener, parsers, mode, stats, filter)
+		return stopFunc, nil, err
+	}
+	return s.startUnixDatagramListener(listener, parsers, mode, stats, filter)
+}
+
+func (s *Server) startUnixDatagramListener(listener ListenerConfig, parsers *ParserChain, mode os.FileMode, stats *ListenerStats, filter *SourceFilter) (func(), *IngestQueue, error) {
+	if err := removeStaleSocket(listener.SocketPath, "unixgram"); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: listener.SocketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Chmod(listener.SocketPath, mode); err != nil {
+		conn.Close()
+		os.Remove(listener.SocketPath)
+		return nil, nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	// Datagrams are handed to a bounded worker pool, as for UDP
+	ingest := NewIngestQueue(listener.ID, listener.QueueSize, listener.Workers, s.processMessage, stats)
+
+	log.Printf("UNIX datagram syslog listener '%s' listening on %s (mode: %04o, queue: %d, workers: %d)",
+		listener.Name, listener.SocketPath, mode, cap(ingest.queue), ingest.workers)
+
+	remoteAddr := "unix:" + listener.SocketPath
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer conn.Close()
+		defer close(done)
+
+		buffer := make([]byte, 65535)
+		for {
+			select {
+			case <-stopChan:
+				return
+			default:
+				conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				n, _, err := conn.ReadFromUnix(buffer)
+				receivedAt := time.Now()
+				if err != nil {
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						continue
+					}
+					if !errors.Is(err, net.ErrClosed) {
+						log.Printf("UNIX read error: %v", err)
+					}
+					continue
+				}
+
+				// A datagram that fills the buffer was truncated
+				if n == len(buffer) {
+					stats.RecordOversized()
+				}
+
+				// Local senders (e.g. glibc syslog()) may end messages with NUL or LF
+				data := bytes.TrimRight(buffer[:n], "\x00\n")
+				if len(data) == 0 {
+					continue
+				}
+
+				msg := ingestMessage{
+					data:       bytes.Clone(data),
+					remoteAddr: remoteAddr,
+					protocol:   "UNIX",
+					parsers:    parsers,
+					listenerID: listener.ID,
+					deviceID:   listener.DeviceID,
+					receivedAt: receivedAt,
+				}
+				if filter.Admit(msg) {
+					ingest.Enqueue(msg)
+				}
+			}
+		}
+	}()
+
+	stopFunc := func() {
+		close(stopChan)
+		conn.Close()
+		<-done
+		os.Remove(listener.SocketPath)
+		// Drain messages already accepted before returning
+		ingest.Close()
+	}
+
+	return stopFunc, ingest, nil
+}
+
+func (s *Server) startUnixStreamListener(listener ListenerConfig, parsers *ParserChain, mode os.FileMode, stats *ListenerStats, filter *SourceFilter) (func(), error) {
+	if err := removeStaleSocket(listener.SocketPath, "unix"); err != nil {
+		return nil, err
+	}
+
+	unixListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: listener.SocketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(listener.SocketPath, mode); err != nil {
+		unixListener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	framing := listener.Framing
+	if framing == "" {
+		framing = framingNonTransparent
+	}
+
+	log.Printf("UNIX stream syslog listener '%s' listening on %s (mode: %04o, framing: %s)",
+		listener.Name, listener.SocketPath, mode, framing)
+
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		// Closing the listener also removes the socket file
+		defer unixListener.Close()
+		defer close(done)
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			default:
+				unixListener.SetDeadline(time.Now().Add(100 * time.Millisecond))
+				conn, err := unixListener.AcceptUnix()
+				if err != nil {
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						continue
+					}
+					if !errors.Is(err, net.ErrClosed) {
+						log.Printf("UNIX accept error: %v", err)
+					}
+					continue
+				}
+
+				go s.handleUnixStreamConnection(conn, listener, parsers, stats, filter)
+			}
+		}
+	}()
+
+	stopFunc := func() {
+		close(stopChan)
+		unixListener.Close()
+		<-done
+	}
+
+	return stopFunc, nil
+}
+
+func (s *Server) handleUnixStreamConnection(conn *net.UnixConn, listener ListenerConfig, parsers *ParserChain, stats *ListenerStats, filter *SourceFilter) {
+	defer conn.Close()
+	remoteAddr := "unix:" + listener.SocketPath
+	reader := bufio.NewReader(conn)
+
+	connection := stats.OpenConnection(remoteAddr, "UNIX")
+	defer stats.CloseConnection(connection)
+
+	framing := listener.Framing
+	switch framing {
+	case "":
+		framing = framingNonTransparent
+	case framingAuto:
+		var err error
+		if framing, err = detectFraming(reader); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("UNIX connection error: %v", err)
+			}
+			return
+		}
+	}
+	stats.RecordFraming(framing)
+
+	if framing == framingOctetCounting {
+		s.readOctetCountedFrames(reader, ingestMessage{
+			remoteAddr: remoteAddr,
+			protocol:   "UNIX",
+			parsers:    parsers,
+			listenerID: listener.ID,
+			deviceID:   listener.DeviceID,
+			connection: connection,
+			filter:     filter,
+		}, s.processMessage)
+		return
+	}
+
+	// Messages are terminated by LF or, as glibc's syslog() sends them, NUL
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 65535), 65535)
+	scanner.Split(scanSyslogLines)
+
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if len(data) > 0 {
+			s.processMessage(ingestMessage{
+				data:       bytes.Clone(data),
+				remoteAddr: remoteAddr,
+				protocol:   "UNIX",
+				parsers:    parsers,
+				listenerID: listener.ID,
+				deviceID:   listener.DeviceID,
+				connection: connection,
+				filter:     filter,
+				receivedAt: time.Now(),
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		connection.RecordReadError(err)
+		log.Printf("UNIX connection error: %v", err)
+	}
+}
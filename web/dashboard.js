@@ -124,6 +124,11 @@ function updateServerInfo(info) {
                             <div style="color: #9ca3af; font-size: 12px; margin-top: 4px;">
                                 ${listener.protocol} :${listener.port}
                             </div>
+                            ${listener.certificate ? `
+                            <div style="color: ${listener.certificate.expiring || listener.certificate.reload_error ? '#f59e0b' : '#9ca3af'}; font-size: 12px; margin-top: 4px;">
+                                Certificate expires ${new Date(listener.certificate.not_after).toLocaleDateString()}${listener.certificate.reload_error ? ' (reload failed)' : ''}
+                            </div>
+                            ` : ''}
                         </div>
                         <span style="padding: 4px 8px; background: #10b98120; color: #10b981; border-radius: 4px; font-size: 11px; font-weight: 600;">
                             Active
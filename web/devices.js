@@ -80,6 +80,7 @@ function fetchDevices() {
         .then(res => res.json())
         .then(data => {
             renderDevices(data || []);
+            fetchUnknownSenders();
         })
         .catch(err => {
             console.error('Error fetching devices:', err);
@@ -122,6 +123,24 @@ function renderDevices(devices) {
                     <span class="device-info-label">IP Addresses:</span>
                     <span class="device-info-value">${(device.ip_addresses || []).join(', ') || 'None'}</span>
                 </div>
+                ${device.hostnames && device.hostnames.length > 0 ? `
+                <div class="device-info-item">
+                    <span class="device-info-label">Hostnames:</span>
+                    <span class="device-info-value">${device.hostnames.join(', ')}</span>
+                </div>
+                ` : ''}
+                ${device.cert_identities && device.cert_identities.length > 0 ? `
+                <div class="device-info-item">
+                    <span class="device-info-label">Certificates:</span>
+                    <span class="device-info-value">${escapeHtml(device.cert_identities.join(', '))}</span>
+                </div>
+                ` : ''}
+                ${device.health ? `
+                <div class="device-info-item">
+                    <span class="device-info-label">Health:</span>
+                    <span class="device-info-value">${formatDeviceHealth(device.health)}</span>
+                </div>
+                ` : ''}
                 ${device.description ? `
                 <div class="device-info-item">
                     <span class="device-info-label">Description:</span>
@@ -129,7 +148,16 @@ function renderDevices(devices) {
                 </div>
                 ` : ''}
             </div>
+            <div class="device-card-info" id="deviceCerts-${device.id}" style="display: none;"></div>
             <div class="device-card-actions">
+                <button class="btn-secondary" onclick="issueDeviceCertificate('${device.id}')">
+                    <i class="fas fa-certificate"></i>
+                    Issue Certificate
+                </button>
+                <button class="btn-secondary" onclick="toggleDeviceCertificates('${device.id}')">
+                    <i class="fas fa-list"></i>
+                    Certificates
+                </button>
                 <button class="btn-secondary" onclick="deleteDevice('${device.id}')">
                     <i class="fas fa-trash"></i>
                     Delete
@@ -139,6 +167,215 @@ function renderDevices(devices) {
     `).join('');
 }
 
+// issueDeviceCertificate downloads a new client certificate for a device
+// from the internal CA, with its private key. Given a password, the file is
+// PKCS#12; otherwise PEM.
+function issueDeviceCertificate(deviceId) {
+    const password = prompt('Password for a PKCS#12 (.p12) file, or leave empty for PEM (certificate, CA and unencrypted key):', '');
+    if (password === null) return;
+
+    const format = password ? 'pkcs12' : 'pem';
+    apiFetch(`${API_BASE}/api/devices/${encodeURIComponent(deviceId)}/certificates`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ format, password })
+    })
+    .then(async res => {
+        if (!res.ok) throw new Error(await res.text());
+        downloadBlob(await res.blob(), `qlog-${deviceId}.${format === 'pkcs12' ? 'p12' : 'pem'}`);
+        fetchDevices();
+    })
+    .catch(err => {
+        console.error('Error issuing device certificate:', err);
+        alert('Error issuing certificate: ' + err.message);
+    });
+}
+
+// toggleDeviceCertificates shows or hides the certificates issued to a device
+function toggleDeviceCertificates(deviceId) {
+    const panel = document.getElementById(`deviceCerts-${deviceId}`);
+    if (!panel) return;
+    if (panel.style.display !== 'none') {
+        panel.style.display = 'none';
+        return;
+    }
+    panel.style.display = 'block';
+    loadDeviceCertificates(deviceId);
+}
+
+function loadDeviceCertificates(deviceId) {
+    const panel = document.getElementById(`deviceCerts-${deviceId}`);
+    if (!panel) return;
+    panel.innerHTML = '<span class="device-info-label">Loading...</span>';
+
+    apiFetch(`${API_BASE}/api/devices/${encodeURIComponent(deviceId)}/certificates`)
+        .then(res => res.json())
+        .then(certs => {
+            if (!certs || certs.length === 0) {
+                panel.innerHTML = '<span class="device-info-label">No certificates issued</span>';
+                return;
+            }
+            panel.innerHTML = certs.map(cert => `
+                <div class="device-info-item">
+                    <span class="device-info-label">${escapeHtml(cert.serial)}</span>
+                    <span class="device-info-value">
+                        ${cert.revoked_at
+                            ? `Revoked ${new Date(cert.revoked_at).toLocaleDateString()}`
+                            : `Valid until ${new Date(cert.not_after).toLocaleDateString()}
+                               <button class="btn-secondary btn-sm" onclick="revokeDeviceCertificate('${deviceId}', '${escapeHtml(cert.serial)}')">Revoke</button>`}
+                    </span>
+                </div>
+            `).join('');
+        })
+        .catch(err => {
+            console.error('Error loading device certificates:', err);
+            panel.innerHTML = '<span class="device-info-label">Failed to load certificates</span>';
+        });
+}
+
+function revokeDeviceCertificate(deviceId, serial) {
+    if (!confirm('Revoke this certificate? Listeners using the internal CA will reject it.')) {
+        return;
+    }
+
+    apiFetch(`${API_BASE}/api/ca/issued/${encodeURIComponent(serial)}/revoke`, {
+        method: 'POST'
+    })
+    .then(async res => {
+        if (!res.ok) throw new Error(await res.text());
+        loadDeviceCertificates(deviceId);
+    })
+    .catch(err => {
+        console.error('Error revoking certificate:', err);
+        alert('Error revoking certificate: ' + err.message);
+    });
+}
+
+function formatDeviceHealth(health) {
+    if (health.status === 'unknown' || !health.last_seen) {
+        return 'No messages seen';
+    }
+    const status = health.status === 'silent' ? 'Silent' : 'Active';
+    const rate = `${health.messages_per_minute.toFixed(1)}/min`;
+    const parsed = health.total_messages > 0 ? `, ${Math.round(health.parse_success_rate * 100)}% parsed` : '';
+    return `${status} - last seen ${new Date(health.last_seen).toLocaleString()} (${rate}${parsed})`;
+}
+
+function fetchUnknownSenders() {
+    apiFetch(`${API_BASE}/api/unknown-senders`)
+        .then(res => res.json())
+        .then(data => {
+            renderUnknownSenders(data || []);
+        })
+        .catch(err => {
+            console.error('Error fetching unknown senders:', err);
+            const container = document.getElementById('unknownSendersList');
+            if (container) {
+                container.innerHTML = '<div class="empty-state">Error loading unknown senders</div>';
+            }
+        });
+}
+
+function renderUnknownSenders(senders) {
+    const container = document.getElementById('unknownSendersList');
+    if (!container) return;
+    
+    if (senders.length === 0) {
+        container.innerHTML = '<div class="empty-state">No messages from unknown senders.</div>';
+        return;
+    }
+    
+    container.innerHTML = senders.map(sender => `
+        <div class="device-card">
+            <div class="device-card-header">
+                <div>
+                    <h3 class="device-card-title">${escapeHtml(sender.hostname || sender.source_ip)}</h3>
+                    <span class="device-card-status disabled">${sender.message_count} messages</span>
+                </div>
+            </div>
+            <div class="device-card-info">
+                <div class="device-info-item">
+                    <span class="device-info-label">Source IP:</span>
+                    <span class="device-info-value">${escapeHtml(sender.source_ip)}</span>
+                </div>
+                <div class="device-info-item">
+                    <span class="device-info-label">Suggested Type:</span>
+                    <span class="device-info-value">${escapeHtml(sender.suggested_device_type || 'generic')}</span>
+                </div>
+                <div class="device-info-item">
+                    <span class="device-info-label">Listener ID:</span>
+                    <span class="device-info-value">${escapeHtml(sender.listener_id || 'None')}</span>
+                </div>
+                <div class="device-info-item">
+                    <span class="device-info-label">First Seen:</span>
+                    <span class="device-info-value">${formatTimestamp(sender.first_seen)}</span>
+                </div>
+                <div class="device-info-item">
+                    <span class="device-info-label">Last Seen:</span>
+                    <span class="device-info-value">${formatTimestamp(sender.last_seen)}</span>
+                </div>
+                <div class="device-info-item">
+                    <span class="device-info-label">Quarantined:</span>
+                    <span class="device-info-value">${sender.quarantined}</span>
+                </div>
+            </div>
+            <div class="device-card-actions">
+                <button class="btn-primary" onclick="adoptUnknownSender('${escapeHtml(sender.source_ip)}', ${sender.quarantined})">
+                    <i class="fas fa-check"></i>
+                    Adopt as Device
+                </button>
+                <button class="btn-secondary" onclick="discardUnknownSender('${escapeHtml(sender.source_ip)}')">
+                    <i class="fas fa-trash"></i>
+                    Discard
+                </button>
+            </div>
+        </div>
+    `).join('');
+}
+
+function adoptUnknownSender(sourceIp, quarantined) {
+    const backfill = quarantined > 0 &&
+        confirm(`Save the ${quarantined} quarantined messages from ${sourceIp} as logs?\n\nCancel adopts the device without them.`);
+    
+    apiFetch(`${API_BASE}/api/unknown-senders/${encodeURIComponent(sourceIp)}/adopt`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ backfill: backfill })
+    })
+    .then(res => {
+        if (!res.ok) {
+            return res.text().then(text => {
+                throw new Error(text || `HTTP ${res.status}`);
+            });
+        }
+        return res.json();
+    })
+    .then(() => {
+        fetchDevices();
+    })
+    .catch(err => {
+        console.error('Error adopting sender:', err);
+        alert('Error adopting sender: ' + err.message);
+    });
+}
+
+function discardUnknownSender(sourceIp) {
+    if (!confirm(`Discard the quarantined messages from ${sourceIp}?`)) {
+        return;
+    }
+    
+    apiFetch(`${API_BASE}/api/unknown-senders/${encodeURIComponent(sourceIp)}`, {
+        method: 'DELETE'
+    })
+    .then(() => {
+        fetchUnknownSenders();
+    })
+    .catch(err => {
+        console.error('Error discarding sender:', err);
+        alert('Error discarding sender');
+    });
+}
+
 function openAddDeviceModal() {
     const modal = document.getElementById('addDeviceModal');
     if (modal) {
@@ -156,6 +393,8 @@ function openAddDeviceModal() {
         document.getElementById('deviceDescription').value = '';
         document.getElementById('deviceIpList').innerHTML = '';
         document.getElementById('deviceIpInput').value = '';
+        document.getElementById('deviceHostnames').value = '';
+        document.getElementById('deviceCertIdentities').value = '';
         deviceIpAddresses = [];
         
         // Fetch and populate device types and listeners when opening modal
@@ -213,10 +452,11 @@ function addDeviceIp() {
         return;
     }
     
-    // Basic IP validation
-    const ipRegex = /^(?:[0-9]{1,3}\.){3}[0-9]{1,3}$/;
-    if (!ipRegex.test(ip)) {
-        alert('Please enter a valid IP address');
+    // Basic IPv4/IPv6 address or CIDR validation (the server checks it fully)
+    const ipv4Regex = /^(?:[0-9]{1,3}\.){3}[0-9]{1,3}(?:\/[0-9]{1,2})?$/;
+    const ipv6Regex = /^[0-9a-fA-F:.]*:[0-9a-fA-F:.]*(?:\/[0-9]{1,3})?$/;
+    if (!ipv4Regex.test(ip) && !ipv6Regex.test(ip)) {
+        alert('Please enter a valid IP address or CIDR range');
         return;
     }
     
@@ -257,9 +497,18 @@ function updateDeviceIpList() {
 }
 
 function saveDevice() {
+    const hostnames = (document.getElementById('deviceHostnames')?.value || '')
+        .split(',')
+        .map(h => h.trim())
+        .filter(h => h);
+    const certIdentities = (document.getElementById('deviceCertIdentities')?.value || '')
+        .split(',')
+        .map(c => c.trim())
+        .filter(c => c);
+
     // Validate step 2
-    if (deviceIpAddresses.length === 0) {
-        alert('Please add at least one IP address');
+    if (deviceIpAddresses.length === 0 && hostnames.length === 0 && certIdentities.length === 0) {
+        alert('Please add at least one IP address, hostname or certificate identity');
         return;
     }
     
@@ -276,6 +525,8 @@ function saveDevice() {
         device_type: deviceType,
         listener_id: listenerId,
         ip_addresses: deviceIpAddresses,
+        hostnames: hostnames,
+        cert_identities: certIdentities,
         description: description || ''
     };
     
@@ -287,7 +538,14 @@ function saveDevice() {
         },
         body: JSON.stringify(device)
     })
-    .then(res => res.json())
+    .then(res => {
+        if (!res.ok) {
+            return res.text().then(text => {
+                throw new Error(text || `HTTP ${res.status}`);
+            });
+        }
+        return res.json();
+    })
     .then(data => {
         closeDeviceModal();
         fetchDevices();
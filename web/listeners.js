@@ -5,7 +5,9 @@ function getProtocolIcon(protocol) {
     const icons = {
         'UDP': '<i class="fas fa-broadcast-tower"></i>',
         'TCP': '<i class="fas fa-exchange-alt"></i>',
-        'TLS': '<i class="fas fa-lock"></i>'
+        'TLS': '<i class="fas fa-lock"></i>',
+        'RELP': '<i class="fas fa-check-double"></i>',
+        'UNIX': '<i class="fas fa-plug"></i>'
     };
     return icons[protocol] || '<i class="fas fa-network-wired"></i>';
 }
@@ -15,7 +17,9 @@ function getProtocolColor(protocol) {
     const colors = {
         'UDP': '#10b981',
         'TCP': '#3b82f6',
-        'TLS': '#8b5cf6'
+        'TLS': '#8b5cf6',
+        'RELP': '#f59e0b',
+        'UNIX': '#ec4899'
     };
     return colors[protocol] || '#9ca3af';
 }
@@ -136,27 +140,138 @@ function handleProtocolChange() {
     const protocol = document.getElementById('listenerProtocol')?.value;
     const tlsGroup = document.getElementById('listenerTlsGroup');
     const framingGroup = document.getElementById('listenerFramingGroup');
+    const unixGroup = document.getElementById('listenerUnixGroup');
+    const proxyGroup = document.getElementById('listenerProxyGroup');
+    const accessGroup = document.getElementById('listenerAccessGroup');
+    const multilineGroup = document.getElementById('listenerMultilineGroup');
     
-    // Show/hide TLS certificate fields
+    // Show/hide TLS certificate fields (optional for RELP)
     if (tlsGroup) {
-        if (protocol === 'TLS') {
+        if (protocol === 'TLS' || protocol === 'RELP') {
             tlsGroup.style.display = 'block';
         } else {
             tlsGroup.style.display = 'none';
         }
     }
     
-    // Show/hide framing field (for TCP and TLS)
+    // Show/hide framing field (for TCP, TLS and UNIX streams)
     if (framingGroup) {
-        if (protocol === 'TCP' || protocol === 'TLS') {
+        const unixStream = protocol === 'UNIX' && document.getElementById('listenerSocketType')?.value === 'stream';
+        if (protocol === 'TCP' || protocol === 'TLS' || unixStream) {
             framingGroup.style.display = 'block';
         } else {
             framingGroup.style.display = 'none';
         }
+        // TLS is always octet-counted (RFC5425), so auto-detection only applies to TCP and UNIX
+        const framingSelect = document.getElementById('listenerFraming');
+        const autoOption = framingSelect?.querySelector('option[value="auto"]');
+        if (autoOption) {
+            autoOption.disabled = protocol === 'TLS';
+            if (autoOption.disabled && framingSelect.value === 'auto') {
+                framingSelect.value = 'octet-counting';
+            }
+        }
+    }
+    
+    // Show/hide PROXY protocol fields (for TCP and TLS behind a load balancer)
+    if (proxyGroup) {
+        proxyGroup.style.display = (protocol === 'TCP' || protocol === 'TLS') ? 'block' : 'none';
+    }
+    
+    // Multi-line joining is only available on TCP streams
+    if (multilineGroup) {
+        multilineGroup.style.display = protocol === 'TCP' ? 'block' : 'none';
+    }
+    
+    // Access lists need a sender address, which UNIX sockets don't have
+    if (accessGroup) {
+        accessGroup.style.display = protocol === 'UNIX' ? 'none' : 'block';
+    }
+    
+    // Show/hide socket fields for UNIX
+    if (unixGroup) {
+        unixGroup.style.display = protocol === 'UNIX' ? 'block' : 'none';
+        if (protocol === 'UNIX') {
+            loadListenerDeviceOptions();
+        }
+    }
+}
+
+function loadListenerDeviceOptions() {
+    const select = document.getElementById('listenerDevice');
+    if (!select) return;
+    apiFetch(`${API_BASE}/api/devices`)
+        .then(res => res.json())
+        .then(devices => {
+            const current = select.value;
+            select.innerHTML = '<option value="">Select a device...</option>' + (devices || []).map(device =>
+                `<option value="${escapeHtml(device.id)}">${escapeHtml(device.name || device.id)}</option>`
+            ).join('');
+            select.value = current;
+        })
+        .catch(err => console.error('Error loading devices:', err));
+}
+
+// addProxyFields enables PROXY protocol when trusted proxies are entered in the wizard
+function addProxyFields(listenerData) {
+    const trusted = (document.getElementById('listenerProxyTrusted')?.value || '')
+        .split(/[\s,]+/)
+        .filter(value => value);
+    if (trusted.length > 0) {
+        listenerData.proxy_protocol = true;
+        listenerData.proxy_trusted_cidrs = trusted;
+    }
+}
+
+// addAccessFields adds the wizard's access lists and rate limit to listenerData
+function addAccessFields(listenerData) {
+    const list = id => (document.getElementById(id)?.value || '')
+        .split(/[\s,]+/)
+        .filter(value => value);
+    const allow = list('listenerAllowCidrs');
+    const deny = list('listenerDenyCidrs');
+    if (allow.length > 0) {
+        listenerData.allow_cidrs = allow;
+    }
+    if (deny.length > 0) {
+        listenerData.deny_cidrs = deny;
+    }
+    const rate = parseFloat(document.getElementById('listenerRateLimit')?.value);
+    if (rate > 0) {
+        listenerData.rate_limit = { messages_per_second: rate };
+    }
+}
+
+// addMultilineFields adds the wizard's multi-line rules to listenerData
+function addMultilineFields(listenerData) {
+    const pattern = document.getElementById('listenerMultilinePattern')?.value.trim() || '';
+    const leadingWhitespace = document.getElementById('listenerMultilineWhitespace')?.checked || false;
+    if (pattern || leadingWhitespace) {
+        listenerData.multiline = { leading_whitespace: leadingWhitespace };
+        if (pattern) {
+            listenerData.multiline.continuation_pattern = pattern;
+        }
+    }
+}
+
+// addUnixSocketFields adds the UNIX socket settings from the wizard to listenerData
+function addUnixSocketFields(listenerData) {
+    delete listenerData.port;
+    listenerData.socket_path = document.getElementById('listenerSocketPath')?.value;
+    listenerData.socket_type = document.getElementById('listenerSocketType')?.value || 'datagram';
+    listenerData.device_id = document.getElementById('listenerDevice')?.value;
+    const mode = document.getElementById('listenerSocketMode')?.value;
+    if (mode) {
+        listenerData.socket_mode = mode;
+    }
+    const framing = document.getElementById('listenerFraming')?.value;
+    if (framing && listenerData.socket_type === 'stream') {
+        listenerData.framing = framing;
     }
 }
 
 function fetchListeners() {
+    fetchInternalCA();
     apiFetch(`${API_BASE}/api/listeners`)
         .then(res => res.json())
         .then(data => {
@@ -218,8 +333,8 @@ function renderListeners(listeners) {
                             <i class="fas fa-network-wired"></i>
                         </div>
                         <div class="listener-info-content">
-                            <span class="listener-info-label">Port</span>
-                            <span class="listener-info-value">${listener.port}</span>
+                            <span class="listener-info-label">${listener.protocol === 'UNIX' ? 'Socket' : 'Port'}</span>
+                            <span class="listener-info-value">${listener.protocol === 'UNIX' ? escapeHtml(listener.socket_path || '') : listener.port}</span>
                         </div>
                     </div>
                     <div class="listener-info-item">
@@ -228,7 +343,7 @@ function renderListeners(listeners) {
                         </div>
                         <div class="listener-info-content">
                             <span class="listener-info-label">RFC Standard</span>
-                            <span class="listener-info-value">${listener.parser || 'N/A'}</span>
+                            <span class="listener-info-value">${listener.parser || 'auto'}</span>
                         </div>
                     </div>
                     <div class="listener-info-item">
@@ -240,7 +355,7 @@ function renderListeners(listeners) {
                             <span class="listener-info-value">${listener.framing || 'N/A'}</span>
                         </div>
                     </div>
-                    ${listener.protocol === 'TLS' && listener.cert_file ? `
+                    ${(listener.protocol === 'TLS' || listener.protocol === 'RELP') && listener.cert_file ? `
                     <div class="listener-info-item">
                         <div class="listener-info-icon">
                             <i class="fas fa-lock"></i>
@@ -253,7 +368,18 @@ function renderListeners(listeners) {
                     ` : ''}
                 </div>
             </div>
+            <div class="listener-card-info" id="listenerStats-${listener.id}" style="display: none;"></div>
             <div class="listener-card-actions">
+                <button class="btn-secondary btn-sm" onclick="toggleListenerStats('${listener.id}')">
+                    <i class="fas fa-chart-bar"></i>
+                    Stats
+                </button>
+                ${listener.protocol === 'TLS' || listener.protocol === 'RELP' ? `
+                <button class="btn-secondary btn-sm" onclick="issueListenerCertificate('${listener.id}')">
+                    <i class="fas fa-certificate"></i>
+                    Issue Certificate
+                </button>
+                ` : ''}
                 <button class="btn-secondary btn-sm" onclick="deleteListener('${listener.id}')">
                     <i class="fas fa-trash"></i>
                     Delete
@@ -264,6 +390,177 @@ function renderListeners(listeners) {
     }).join('');
 }
 
+// toggleListenerStats shows or hides a listener's counters and open connections
+function toggleListenerStats(listenerId) {
+    const panel = document.getElementById(`listenerStats-${listenerId}`);
+    if (!panel) return;
+    if (panel.style.display !== 'none') {
+        panel.style.display = 'none';
+        return;
+    }
+    panel.style.display = 'block';
+    panel.innerHTML = '<span class="listener-info-label">Loading...</span>';
+
+    apiFetch(`${API_BASE}/api/listeners/${encodeURIComponent(listenerId)}/stats`)
+        .then(res => res.json())
+        .then(stats => {
+            const counters = [
+                ['Messages', stats.messages_received],
+                ['Bytes', stats.bytes_received],
+                ['Parse Failures', stats.parse_failures],
+                ['Rejected (unknown sender)', stats.rejected],
+                ['Oversized', stats.oversized],
+                ['Queue Drops', stats.queue_drops],
+                ['Connections', stats.connections]
+            ];
+            const connections = stats.open_connections || [];
+            panel.innerHTML = `
+                <div class="listener-info-grid">
+                    ${counters.map(([label, value]) => `
+                    <div class="listener-info-item">
+                        <div class="listener-info-content">
+                            <span class="listener-info-label">${label}</span>
+                            <span class="listener-info-value">${formatNumber(value || 0)}</span>
+                        </div>
+                    </div>`).join('')}
+                </div>
+                <span class="listener-info-label">Since ${new Date(stats.since).toLocaleString()}</span>
+                ${connections.length > 0 ? `
+                <div class="listener-info-label" style="margin-top: 12px;">Open connections</div>
+                ${connections.map(conn => `
+                <div class="listener-info-value">
+                    ${escapeHtml(conn.remote_addr)} - ${formatNumber(conn.messages)} messages
+                    ${conn.tls_version ? ` - ${escapeHtml(conn.tls_version)}` : ''}
+                    ${conn.client_subject ? ` - ${escapeHtml(conn.client_subject)}` : ''}
+                </div>`).join('')}` : ''}
+            `;
+        })
+        .catch(err => {
+            console.error('Error loading listener stats:', err);
+            panel.innerHTML = '<span class="listener-info-label">Failed to load statistics</span>';
+        });
+}
+
+// fetchInternalCA shows the built-in CA, or a button to create it
+function fetchInternalCA() {
+    const panel = document.getElementById('internalCAPanel');
+    if (!panel) return;
+
+    apiFetch(`${API_BASE}/api/ca`)
+        .then(res => res.json())
+        .then(data => {
+            if (!data.created) {
+                panel.innerHTML = `
+                    <div class="listener-card-info">
+                        <span class="listener-info-label">Internal CA</span>
+                        <span class="listener-info-value">Not created. The internal CA issues listener certificates and device client certificates.</span>
+                    </div>
+                    <div class="listener-card-actions">
+                        <button class="btn-primary btn-sm" onclick="createInternalCA()">
+                            <i class="fas fa-certificate"></i>
+                            Create CA
+                        </button>
+                    </div>`;
+                return;
+            }
+            panel.innerHTML = `
+                <div class="listener-card-info">
+                    <div class="listener-info-grid">
+                        <div class="listener-info-item">
+                            <div class="listener-info-content">
+                                <span class="listener-info-label">Internal CA</span>
+                                <span class="listener-info-value">${escapeHtml(data.ca.subject)}</span>
+                            </div>
+                        </div>
+                        <div class="listener-info-item">
+                            <div class="listener-info-content">
+                                <span class="listener-info-label">Expires</span>
+                                <span class="listener-info-value">${new Date(data.ca.not_after).toLocaleDateString()}</span>
+                            </div>
+                        </div>
+                    </div>
+                    <span class="listener-info-label">SHA-256 ${escapeHtml(data.ca.fingerprint)}</span>
+                </div>
+                <div class="listener-card-actions">
+                    <button class="btn-secondary btn-sm" onclick="downloadCAFile('certificate', 'qlog-ca.pem')">
+                        <i class="fas fa-download"></i>
+                        CA Certificate
+                    </button>
+                    <button class="btn-secondary btn-sm" onclick="downloadCAFile('crl', 'qlog-ca.crl')">
+                        <i class="fas fa-download"></i>
+                        CRL
+                    </button>
+                </div>`;
+        })
+        .catch(err => {
+            console.error('Error loading internal CA:', err);
+            panel.innerHTML = '';
+        });
+}
+
+function createInternalCA() {
+    const commonName = prompt('Name of the CA:', 'qLog Internal CA');
+    if (commonName === null) return;
+
+    apiFetch(`${API_BASE}/api/ca`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ common_name: commonName })
+    })
+    .then(async res => {
+        if (!res.ok) throw new Error(await res.text());
+        fetchInternalCA();
+    })
+    .catch(err => {
+        console.error('Error creating internal CA:', err);
+        alert('Error creating CA: ' + err.message);
+    });
+}
+
+function downloadCAFile(resource, filename) {
+    apiFetch(`${API_BASE}/api/ca/${resource}`)
+        .then(async res => {
+            if (!res.ok) throw new Error(await res.text());
+            downloadBlob(await res.blob(), filename);
+        })
+        .catch(err => {
+            console.error('Error downloading CA file:', err);
+            alert('Error downloading file: ' + err.message);
+        });
+}
+
+// issueListenerCertificate replaces a listener's certificate with one from
+// the internal CA, valid for this server's host name and addresses
+function issueListenerCertificate(listenerId) {
+    const extra = prompt('Additional host names or IP addresses senders use to reach this server (comma separated, optional):', '');
+    if (extra === null) return;
+
+    const names = extra.split(',').map(s => s.trim()).filter(Boolean);
+    const isIP = name => /^[0-9.]+$/.test(name) || name.includes(':');
+    apiFetch(`${API_BASE}/api/listeners/${encodeURIComponent(listenerId)}/certificate`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            dns_names: names.filter(name => !isIP(name)),
+            ip_addresses: names.filter(isIP)
+        })
+    })
+    .then(async res => {
+        if (!res.ok) throw new Error(await res.text());
+        return res.json();
+    })
+    .then(data => {
+        const names = [...(data.dns_names || []), ...(data.ip_addresses || [])].join(', ');
+        alert(`Certificate issued for ${names}, valid until ${new Date(data.not_after).toLocaleDateString()}.` +
+            (data.start_error ? `\n\nThe listener could not be started: ${data.start_error}` : ''));
+        fetchListeners();
+    })
+    .catch(err => {
+        console.error('Error issuing certificate:', err);
+        alert('Error issuing certificate: ' + err.message);
+    });
+}
+
 function openAddListenerModal() {
     const modal = document.getElementById('addListenerModal');
     if (modal) {
@@ -326,8 +623,7 @@ function listenerWizardNext() {
         // Validate current step
         if (currentWizardStep === 1) {
             const name = document.getElementById('listenerName')?.value;
-            const port = document.getElementById('listenerPort')?.value;
-            if (!name || !port) {
+            if (!name) {
                 alert('Please fill in all required fields');
                 return;
             }
@@ -340,6 +636,17 @@ function listenerWizardNext() {
                 return;
             }
             
+            // UNIX listeners bind a socket path instead of a port
+            if (protocol === 'UNIX') {
+                if (!document.getElementById('listenerSocketPath')?.value || !document.getElementById('listenerDevice')?.value) {
+                    alert('UNIX listeners require a socket path and a device');
+                    return;
+                }
+            } else if (!document.getElementById('listenerPort')?.value) {
+                alert('Please enter a port');
+                return;
+            }
+            
             // Validate TLS certificates if protocol is TLS
             if (protocol === 'TLS') {
                 const certFile = document.getElementById('listenerCertFile')?.files[0];
@@ -410,8 +717,8 @@ function updateReviewSummary() {
         framingItem.style.display = 'none';
     }
     
-    // Show/hide certificate info for TLS
-    if (protocol === 'TLS') {
+    // Show/hide certificate info for TLS (and RELP over TLS)
+    if (protocol === 'TLS' || protocol === 'RELP') {
         const certItem = document.getElementById('reviewListenerCert');
         const keyItem = document.getElementById('reviewListenerKey');
         const caCertItem = document.getElementById('reviewListenerCaCert');
@@ -461,7 +768,7 @@ function saveListener() {
     const framing = document.getElementById('listenerFraming')?.value;
     const description = document.getElementById('listenerDescription')?.value;
     
-    if (!name || !port || !protocol || !parser) {
+    if (!name || (!port && protocol !== 'UNIX') || !protocol || !parser) {
         alert('Please fill in all required fields');
         return;
     }
@@ -474,10 +781,11 @@ function saveListener() {
         saveBtn.textContent = 'Creating...';
     }
     
-    // Upload certificates if TLS
-    if (protocol === 'TLS') {
-        const certInput = document.getElementById('listenerCertFile');
-        const keyInput = document.getElementById('listenerKeyFile');
+    // Upload certificates if TLS, or RELP with a certificate selected
+    const certInput = document.getElementById('listenerCertFile');
+    const keyInput = document.getElementById('listenerKeyFile');
+    const relpTls = protocol === 'RELP' && (certInput?.files[0] || keyInput?.files[0]);
+    if (protocol === 'TLS' || relpTls) {
         const caCertInput = document.getElementById('listenerCaCertFile');
         
         if (!certInput?.files[0] || !keyInput?.files[0]) {
@@ -547,9 +855,25 @@ function createListener(name, port, protocol, parser, framing, description) {
         parser: parser
     };
     
+    const parserFallback = document.getElementById('listenerParserFallback')?.value;
+    if (parserFallback && parser !== 'raw') {
+        listenerData.parser_fallback = parserFallback;
+    }
     if (framing && (protocol === 'TCP' || protocol === 'TLS')) {
         listenerData.framing = framing;
     }
+    if (protocol === 'TCP' || protocol === 'TLS') {
+        addProxyFields(listenerData);
+    }
+    if (protocol !== 'UNIX') {
+        addAccessFields(listenerData);
+    }
+    if (protocol === 'TCP') {
+        addMultilineFields(listenerData);
+    }
+    if (protocol === 'UNIX') {
+        addUnixSocketFields(listenerData);
+    }
     if (description) {
         listenerData.description = description;
     }
@@ -574,8 +898,15 @@ function createListener(name, port, protocol, parser, framing, description) {
         document.getElementById('listenerName').value = '';
         document.getElementById('listenerPort').value = '';
         document.getElementById('listenerDescription').value = '';
+        const proxyTrusted = document.getElementById('listenerProxyTrusted');
+        if (proxyTrusted) proxyTrusted.value = '';
+        ['listenerAllowCidrs', 'listenerDenyCidrs', 'listenerRateLimit'].forEach(id => {
+            const input = document.getElementById(id);
+            if (input) input.value = '';
+        });
         document.getElementById('listenerProtocol').value = 'UDP';
         document.getElementById('listenerParser').value = 'RFC5424';
+        document.getElementById('listenerParserFallback').value = 'alternate';
     })
     .catch(err => {
         console.error('Error creating listener:', err);
@@ -596,9 +927,19 @@ function createListenerWithCertPaths(name, port, protocol, parser, framing, desc
         parser: parser
     };
     
+    const parserFallback = document.getElementById('listenerParserFallback')?.value;
+    if (parserFallback && parser !== 'raw') {
+        listenerData.parser_fallback = parserFallback;
+    }
     if (framing && (protocol === 'TCP' || protocol === 'TLS')) {
         listenerData.framing = framing;
     }
+    if (protocol === 'TCP' || protocol === 'TLS') {
+        addProxyFields(listenerData);
+    }
+    if (protocol !== 'UNIX') {
+        addAccessFields(listenerData);
+    }
     if (description) {
         listenerData.description = description;
     }
@@ -610,6 +951,7 @@ function createListenerWithCertPaths(name, port, protocol, parser, framing, desc
     }
     if (certPaths.caCertPath) {
         listenerData.ca_cert_file = certPaths.caCertPath;
+        listenerData.client_auth = document.getElementById('listenerClientAuth')?.value || 'optional';
     }
     
     fetch(`${API_BASE}/api/listeners`, {
@@ -632,8 +974,15 @@ function createListenerWithCertPaths(name, port, protocol, parser, framing, desc
         document.getElementById('listenerName').value = '';
         document.getElementById('listenerPort').value = '';
         document.getElementById('listenerDescription').value = '';
+        const proxyTrusted = document.getElementById('listenerProxyTrusted');
+        if (proxyTrusted) proxyTrusted.value = '';
+        ['listenerAllowCidrs', 'listenerDenyCidrs', 'listenerRateLimit'].forEach(id => {
+            const input = document.getElementById(id);
+            if (input) input.value = '';
+        });
         document.getElementById('listenerProtocol').value = 'UDP';
         document.getElementById('listenerParser').value = 'RFC5424';
+        document.getElementById('listenerParserFallback').value = 'alternate';
     })
     .catch(err => {
         console.error('Error creating listener:', err);
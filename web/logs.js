@@ -657,12 +657,35 @@ async function fetchListenerInfo() {
 
 function getDeviceByIP(ipAddress, devices) {
     if (!ipAddress || !devices) return null;
-    const ip = ipAddress.split(':')[0]; // Remove port if present
+    const ip = stripPort(ipAddress);
     return devices.find(device => 
-        device.ip_addresses && device.ip_addresses.includes(ip)
+        device.ip_addresses && device.ip_addresses.some(entry => ipMatches(ip, entry))
     ) || null;
 }
 
+// stripPort removes the port from "1.2.3.4:514" or "[2001:db8::1]:514"
+function stripPort(address) {
+    const bracketed = address.match(/^\[([^\]]+)\](?::\d+)?$/);
+    if (bracketed) return bracketed[1];
+    if (address.split(':').length === 2) return address.split(':')[0];
+    return address;
+}
+
+// ipMatches checks an IP against a device entry: an exact IP or an IPv4 CIDR range
+function ipMatches(ip, entry) {
+    if (!entry.includes('/')) return entry.toLowerCase() === ip.toLowerCase();
+    const [base, bits] = entry.split('/');
+    const toInt = addr => {
+        const parts = addr.split('.');
+        if (parts.length !== 4) return null;
+        return parts.reduce((acc, part) => (acc << 8) + (parseInt(part, 10) & 255), 0) >>> 0;
+    };
+    const ipInt = toInt(ip), baseInt = toInt(base), prefix = parseInt(bits, 10);
+    if (ipInt === null || baseInt === null || isNaN(prefix)) return false;
+    const mask = prefix === 0 ? 0 : (~0 << (32 - prefix)) >>> 0;
+    return ((ipInt & mask) >>> 0) === ((baseInt & mask) >>> 0);
+}
+
 function renderLogs(logs) {
     const container = document.getElementById('logsList');
     if (!container) {
@@ -703,10 +726,11 @@ function renderLogsWithDevices(logs, devices, listeners) {
         
         // Get device information
         const ipAddress = log.remote_addr || (log.parsed_fields && log.parsed_fields.source_ip) || (log.parsed_fields && log.parsed_fields.ip) || '';
-        const device = getDeviceByIP(ipAddress, devices);
-        const deviceName = device ? device.name : (log.hostname || (log.parsed_fields && log.parsed_fields.device_name) || '-');
-        const deviceId = device ? device.id : null;
-        const listenerId = device ? device.listener_id : null;
+        // Prefer the device the server recorded; older logs fall back to matching by IP
+        const device = (log.device_id && devices.find(d => d.id === log.device_id)) || (log.device_id ? null : getDeviceByIP(ipAddress, devices));
+        const deviceName = log.device_name || (device ? device.name : (log.hostname || (log.parsed_fields && log.parsed_fields.device_name) || '-'));
+        const deviceId = log.device_id || (device ? device.id : null);
+        const listenerId = log.listener_id || (device ? device.listener_id : null);
         
         // Get listener information if available
         let listenerName = '-';
@@ -1003,6 +1027,21 @@ function renderDeviceSpecificDetail(log, displayInfo, severity, severityColor) {
         html += `</div></div>`;
     }
     
+    // raw_message is the frame as received; show the parsed body too when it differs
+    if (log.message && log.message !== log.raw_message) {
+        html += `
+        <div class="detail-section" style="background: #1a1f2e; padding: 20px; border-radius: 12px; border: 1px solid #2d3441; margin-top: 20px;">
+            <h4 style="margin: 0 0 16px 0; color: #e4e7eb; font-size: 16px; font-weight: 600; display: flex; align-items: center; gap: 8px;">
+                <span style="width: 4px; height: 16px; background: #9ca3af; border-radius: 2px;"></span>
+                Message Body
+            </h4>
+            <div style="background: #0f1419; padding: 16px; border-radius: 8px; border: 1px solid #2d3441; overflow-x: auto;">
+                <pre style="white-space: pre-wrap; font-family: 'SF Mono', 'Monaco', 'Cascadia Code', 'Roboto Mono', monospace; font-size: 12px; line-height: 1.6; color: #e4e7eb; margin: 0; word-break: break-word;">${escapeHtml(log.message)}</pre>
+            </div>
+        </div>
+    `;
+    }
+
     html += `
         <div class="detail-section" style="background: #1a1f2e; padding: 20px; border-radius: 12px; border: 1px solid #2d3441; margin-top: 20px;">
             <h4 style="margin: 0 0 16px 0; color: #e4e7eb; font-size: 16px; font-weight: 600; display: flex; align-items: center; gap: 8px;">
@@ -1068,6 +1107,16 @@ function renderDefaultDetail(log, severity, severityColor) {
             <div class="log-detail-label">Remote Address</div>
             <div class="log-detail-value">${log.remote_addr || '-'}</div>
         </div>
+        ${log.listener_id ? `
+        <div class="log-detail-item">
+            <div class="log-detail-label">Listener</div>
+            <div class="log-detail-value">${escapeHtml(log.listener_id)}</div>
+        </div>
+        <div class="log-detail-item">
+            <div class="log-detail-label">Received At</div>
+            <div class="log-detail-value">${formatTimestamp(log.received_at)}</div>
+        </div>
+        ` : ''}
         ${log.structured_data && Object.keys(log.structured_data).length > 0 ? `
         <div class="log-detail-item">
             <div class="log-detail-label">Structured Data</div>
@@ -1075,7 +1124,7 @@ function renderDefaultDetail(log, severity, severityColor) {
         </div>
         ` : ''}
         <div class="log-detail-item">
-            <div class="log-detail-label">Raw Message</div>
+            <div class="log-detail-label">Raw Message (as received)</div>
             <div class="log-detail-value"><pre style="white-space: pre-wrap;">${escapeHtml(log.raw_message || '-')}</pre></div>
         </div>
     `;
@@ -500,6 +500,8 @@ function executeSharedQuery(widgetId, query) {
 }
 
 function renderSharedQueryResults(container, data) {
+    // /api/query returns { columns, rows, meta }
+    if (data && Array.isArray(data.rows)) data = data.rows;
     if (!data || !Array.isArray(data) || data.length === 0) {
         container.innerHTML = '<div style="padding: 20px; text-align: center; color: var(--text-secondary);"><i class="fas fa-info-circle"></i> No results</div>';
         return;
@@ -14,6 +14,18 @@ window.apiFetch = async (url, options = {}) => {
 // Also create a local const for convenience
 const apiFetch = window.apiFetch;
 
+// downloadBlob saves a response body as a file
+function downloadBlob(blob, filename) {
+    const url = URL.createObjectURL(blob);
+    const a = document.createElement('a');
+    a.href = url;
+    a.download = filename;
+    document.body.appendChild(a);
+    a.click();
+    document.body.removeChild(a);
+    URL.revokeObjectURL(url);
+}
+
 function formatTimestamp(timestamp) {
     const date = new Date(timestamp);
     return date.toLocaleString('en-US', {
@@ -812,7 +812,7 @@ function renderQueryBuilder(config) {
             <div style="flex: 1; display: flex; flex-direction: column; min-height: 0;">
                 <div id="query-builder-editor-${queryId}" style="flex: 1; min-height: 200px; background: #1a1f2e; border: 1px solid #2d3441; border-radius: 8px; padding: 12px; font-family: 'SF Mono', 'Monaco', 'Cascadia Code', 'Roboto Mono', monospace; font-size: 13px; color: #e4e7eb; overflow: auto; position: relative;">
                     <textarea id="query-builder-textarea-${queryId}" 
-                              placeholder="Start typing your query... Use Ctrl+Space for autocomplete"
+                              placeholder="e.g. severity<=3 | stats count by event_type | sort -count... Use Ctrl+Space for autocomplete"
                               style="width: 100%; height: 100%; background: transparent; border: none; color: inherit; font-family: inherit; font-size: inherit; resize: none; outline: none; padding: 0;"
                               spellcheck="false">${escapeHtml(config.query || '')}</textarea>
                 </div>
@@ -1471,11 +1471,11 @@ window.populateDeviceSuggestions = populateDeviceSuggestions;
 
 // Query Builder Functions
 let queryAutocompleteData = {
-    keywords: ['SELECT', 'FROM', 'WHERE', 'AND', 'OR', 'NOT', 'IN', 'LIKE', 'ORDER', 'BY', 'GROUP', 'HAVING', 'LIMIT', 'OFFSET', 'AS', 'COUNT', 'SUM', 'AVG', 'MAX', 'MIN', 'DISTINCT', 'JOIN', 'INNER', 'LEFT', 'RIGHT', 'ON', 'UNION', 'ALL'],
+    keywords: ['AND', 'OR', 'NOT', 'where', 'stats', 'sort', 'head', 'fields', 'by', 'as'],
     tables: ['logs'],
-    fields: ['id', 'timestamp', 'severity', 'message', 'device_type', 'event_type', 'event_category', 'hostname', 'appname', 'raw_message', 'parsed_fields', 'priority', 'facility', 'version', 'remote_addr', 'protocol'],
-    operators: ['=', '!=', '<>', '<', '>', '<=', '>=', 'LIKE', 'NOT LIKE', 'IN', 'NOT IN', 'IS NULL', 'IS NOT NULL'],
-    functions: ['COUNT', 'SUM', 'AVG', 'MAX', 'MIN', 'UPPER', 'LOWER', 'SUBSTR', 'LENGTH', 'REPLACE', 'TRIM', 'DATE', 'DATETIME', 'json_extract']
+    fields: ['id', 'timestamp', 'severity', 'message', 'device_type', 'event_type', 'event_category', 'hostname', 'appname', 'raw_message', 'parsed_fields.', 'priority', 'facility', 'version', 'remote_addr', 'protocol'],
+    operators: ['=', '!=', '<', '>', '<=', '>=', '~', '!~'],
+    functions: ['count', 'dc', 'sum', 'avg', 'max', 'min']
 };
 
 function setupQueryBuilder(widget) {
@@ -1529,13 +1529,13 @@ function showQueryAutocomplete(textarea, queryId) {
     let suggestions = [];
     const textLower = textBefore.toLowerCase();
     
-    if (textLower.includes('select') && !textLower.includes('from')) {
+    if (textLower.match(/\|\s*stats\s+\w*$/)) {
+        // Suggest aggregates
+        suggestions = queryAutocompleteData.functions.map(f => ({ type: 'function', value: f }));
+    } else if (textLower.match(/\b(by|sort|fields)\s+[\w,\s-]*$/)) {
         // Suggest fields
         suggestions = queryAutocompleteData.fields.map(f => ({ type: 'field', value: f }));
-    } else if (textLower.includes('from')) {
-        // Suggest tables
-        suggestions = queryAutocompleteData.tables.map(t => ({ type: 'table', value: t }));
-    } else if (textLower.match(/\bwhere\b/i) || textLower.match(/\band\b/i) || textLower.match(/\bor\b/i)) {
+    } else if (!textLower.includes('|') || textLower.match(/\bwhere\b/i) || textLower.match(/\band\b/i) || textLower.match(/\bor\b/i)) {
         // Suggest fields and operators
         suggestions = [
             ...queryAutocompleteData.fields.map(f => ({ type: 'field', value: f })),
@@ -1666,7 +1666,8 @@ function executeQuery(queryId) {
     .then(data => {
         if (resultsDiv) {
             if (data.error) {
-                resultsDiv.innerHTML = `<div style="padding: 20px; color: var(--error);"><i class="fas fa-exclamation-triangle"></i> Error: ${escapeHtml(data.error)}</div>`;
+                const position = data.position ? ` (at position ${data.position})` : '';
+                resultsDiv.innerHTML = `<div style="padding: 20px; color: var(--error);"><i class="fas fa-exclamation-triangle"></i> Error: ${escapeHtml(data.error + position)}</div>`;
             } else {
                 renderQueryResults(resultsDiv, data);
             }
@@ -1680,6 +1681,8 @@ function executeQuery(queryId) {
 }
 
 function renderQueryResults(container, data) {
+    // /api/query returns { columns, rows, meta }
+    if (data && Array.isArray(data.rows)) data = data.rows;
     if (!data || !Array.isArray(data) || data.length === 0) {
         container.innerHTML = '<div style="padding: 20px; text-align: center; color: var(--text-secondary);"><i class="fas fa-info-circle"></i> No results</div>';
         return;
@@ -1728,16 +1731,9 @@ function formatQuery(queryId) {
                      document.getElementById('config-query');
     if (!textarea) return;
     
-    // Simple SQL formatting
+    // Put each piped command on its own line
     let query = textarea.value;
-    query = query.replace(/\bSELECT\b/gi, '\nSELECT');
-    query = query.replace(/\bFROM\b/gi, '\nFROM');
-    query = query.replace(/\bWHERE\b/gi, '\nWHERE');
-    query = query.replace(/\bAND\b/gi, '\n  AND');
-    query = query.replace(/\bOR\b/gi, '\n  OR');
-    query = query.replace(/\bORDER BY\b/gi, '\nORDER BY');
-    query = query.replace(/\bGROUP BY\b/gi, '\nGROUP BY');
-    query = query.replace(/\bLIMIT\b/gi, '\nLIMIT');
+    query = query.replace(/\s*\|\s*/g, '\n| ');
     query = query.trim();
     
     textarea.value = query;